@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// from returns the name of the collection, or view, the documents of c are
+// read from, which is the collection its pipeline runs on if configured.
+func (c Collection) from() string {
+	if c.From != "" {
+		return c.From
+	}
+	return c.Name
+}
+
+// toPipeline converts a pipeline from the config file to its stages. A
+// string is parsed as an array of MongoDB extended JSON; anything else is a
+// YAML list of documents.
+func toPipeline(v interface{}) ([]bson.M, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if v, ok := v.(string); ok {
+		var stages []bson.M
+		if err := bson.UnmarshalJSON([]byte(v), &stages); err != nil {
+			return nil, err
+		}
+		return stages, nil
+	}
+	list, err := fromYAML(v)
+	if err != nil {
+		return nil, err
+	}
+	a, ok := list.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("not a list of stages")
+	}
+	stages := make([]bson.M, len(a))
+	for i, e := range a {
+		stage, ok := e.(bson.M)
+		if !ok || len(stage) != 1 {
+			return nil, fmt.Errorf("stage %d is not a document with a single operator", i)
+		}
+		stages[i] = stage
+	}
+	return stages, nil
+}
+
+// pipeline returns the stages of the pipeline of c, followed by those that
+// select the documents of its output to scan, so that filter, sort,
+// sampling and projection apply to the documents the pipeline outputs. Like
+// a natural scan, one that is retried is sorted by _id to resume from.
+func (s *Generator) pipeline(c Collection) ([]bson.M, error) {
+	stages, err := toPipeline(c.Pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("mongoschema: invalid pipeline for collection %s: %s", c.Name, err)
+	}
+	sampling := s.sampling(c)
+	if sampling == SamplingSmart {
+		return nil, fmt.Errorf("mongoschema: smart sampling is not supported by the pipeline of collection %s", c.Name)
+	}
+	if sampling == SamplingNatural && len(c.Sort) == 0 && s.Retries > 0 {
+		c.Sort = []string{"_id"}
+	}
+	selection, err := s.stages(c)
+	if err != nil {
+		return nil, err
+	}
+	return append(stages, selection...), nil
+}
+
+// aggregate opens a cursor over the documents output by the pipeline of c.
+func (s *Generator) aggregate(collection *mgo.Collection, c Collection) (Iter, error) {
+	pipeline, err := s.pipeline(c)
+	if err != nil {
+		return nil, err
+	}
+	return s.pipe(collection, pipeline, true), nil
+}
+
+// isView reports whether c is read from a view, according to the metadata
+// of the collections.
+func (s *Generator) isView(c Collection) bool {
+	return s.infos[c.from()].Type == "view"
+}
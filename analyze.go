@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	AnalysisClient = "client"
+	AnalysisServer = "server"
+)
+
+// bsonTypes maps the names returned by $type to primitive types. Objects and
+// arrays are analyzed further, integers depend on the int policy, and null is
+// like a missing field unless nulls are tracked.
+var bsonTypes = map[string]Type{
+	"double":              PrimitiveDouble,
+	"string":              PrimitiveString,
+	"binData":             PrimitiveBinary,
+	"undefined":           NilType,
+	"objectId":            PrimitiveObjectId,
+	"bool":                PrimitiveBool,
+	"date":                PrimitiveTimestamp,
+	"regex":               PrimitiveRegEx,
+	"dbPointer":           PrimitiveDBPointer,
+	"javascript":          PrimitiveJavaScript,
+	"symbol":              PrimitiveSymbol,
+	"javascriptWithScope": PrimitiveJavaScript,
+	"timestamp":           PrimitiveMongoTimestamp,
+	"decimal":             PrimitiveDecimal128,
+	"minKey":              PrimitiveMinKey,
+	"maxKey":              PrimitiveMaxKey,
+}
+
+// analyzer infers the type of a collection on the server, with aggregation
+// pipelines that group the fields of the documents by name and BSON type,
+// instead of shipping every document to the client. Embedded documents and
+// arrays are analyzed by further pipelines, one per path and type. Values are
+// not seen, so neither enums nor examples are detected.
+type analyzer struct {
+	gen *Generator
+	// run runs a pipeline on the collection and decodes all its results.
+	run func(pipeline []bson.M, result interface{}) error
+}
+
+type typeCount struct {
+	ID struct {
+		Key  string `bson:"k"`
+		Type string `bson:"t"`
+	} `bson:"_id"`
+	Count uint `bson:"n"`
+}
+
+// analyzeStruct returns the type of the documents that stages produce.
+func (a *analyzer) analyzeStruct(stages []bson.M) (*StructType, error) {
+	var total []struct {
+		Count uint `bson:"n"`
+	}
+	if err := a.run(append(stages[:len(stages):len(stages)], bson.M{"$count": "n"}), &total); err != nil {
+		return nil, err
+	}
+	s := newStructType()
+	if len(total) == 0 {
+		return s, nil
+	}
+	s.Count = total[0].Count
+
+	var counts []typeCount
+	if err := a.run(append(stages[:len(stages):len(stages)],
+		bson.M{"$project": bson.M{"kv": bson.M{"$objectToArray": "$$ROOT"}}},
+		bson.M{"$unwind": "$kv"},
+		bson.M{"$group": bson.M{
+			"_id": bson.M{"k": "$kv.k", "t": bson.M{"$type": "$kv.v"}},
+			"n":   bson.M{"$sum": 1},
+		}},
+	), &counts); err != nil {
+		return nil, err
+	}
+	for _, c := range counts {
+		if c.ID.Type == "null" && !a.gen.tracksNulls() {
+			continue
+		}
+		// Field paths cannot address such keys, so they are not analyzed
+		// further.
+		if strings.ContainsAny(c.ID.Key, ".$") {
+			a.gen.logger().Warn("cannot analyze field on the server", "field", c.ID.Key)
+			continue
+		}
+		f := &Field{Type: NilType, Count: c.Count, Nulls: c.Count}
+		if c.ID.Type != "null" {
+			t, err := a.valueType(stages, c.ID.Key, c.ID.Type)
+			if err != nil {
+				return nil, err
+			}
+			f = &Field{Type: t, Count: c.Count}
+		}
+		if e, ok := s.Fields[c.ID.Key]; ok {
+			e.merge(f, a.gen)
+		} else {
+			s.Fields[c.ID.Key] = f
+		}
+	}
+	return s, nil
+}
+
+// valueType returns the type of the values of BSON type typ in field of the
+// documents that stages produce.
+func (a *analyzer) valueType(stages []bson.M, field, typ string) (Type, error) {
+	match := bson.M{"$match": bson.M{field: bson.M{"$type": typ}}}
+	switch typ {
+	case "object":
+		return a.analyzeStruct(append(stages[:len(stages):len(stages)],
+			match,
+			bson.M{"$replaceRoot": bson.M{"newRoot": "$" + field}},
+		))
+	case "array":
+		return a.analyzeSlice(append(stages[:len(stages):len(stages)],
+			match,
+			bson.M{"$project": bson.M{"_id": 0, "e": "$" + field}},
+			bson.M{"$unwind": "$e"},
+		))
+	}
+	switch typ {
+	case "int":
+		return a.gen.intType(0, PrimitiveInt32), nil
+	case "long":
+		// Without the values, a long is assumed not to fit in an int32.
+		return a.gen.intType(math.MaxInt64, PrimitiveInt64), nil
+	}
+	if t, ok := bsonTypes[typ]; ok {
+		return t, nil
+	}
+	return nil, fmt.Errorf("mongoschema: unknown BSON type %q", typ)
+}
+
+// analyzeSlice returns the type of the slices whose elements are the e field
+// of the documents that stages produce.
+func (a *analyzer) analyzeSlice(stages []bson.M) (Type, error) {
+	var counts []typeCount
+	if err := a.run(append(stages[:len(stages):len(stages)],
+		bson.M{"$group": bson.M{"_id": bson.M{"t": bson.M{"$type": "$e"}}, "n": bson.M{"$sum": 1}}},
+	), &counts); err != nil {
+		return nil, err
+	}
+	var elem Type = NilType
+	for _, c := range counts {
+		if c.ID.Type == "null" {
+			continue
+		}
+		t, err := a.valueType(stages, "e", c.ID.Type)
+		if err != nil {
+			return nil, err
+		}
+		elem = elem.Merge(t, a.gen)
+	}
+	return SliceType{Type: elem}, nil
+}
+
+// stages returns the pipeline stages that select the documents of c to
+// analyze.
+func (s *Generator) stages(c Collection) ([]bson.M, error) {
+	filter, err := toBSON(c.Filter)
+	if err != nil {
+		return nil, fmt.Errorf("mongoschema: invalid filter for collection %s: %s", c.Name, err)
+	}
+	projection, err := toBSON(c.Projection)
+	if err != nil {
+		return nil, fmt.Errorf("mongoschema: invalid projection for collection %s: %s", c.Name, err)
+	}
+	var stages []bson.M
+	if filter != nil {
+		stages = append(stages, bson.M{"$match": filter})
+	}
+	sampling := s.sampling(c)
+	if len(c.Sort) > 0 && sampling != SamplingNatural {
+		return nil, fmt.Errorf("mongoschema: sort requires natural sampling for collection %s", c.Name)
+	}
+	switch sampling {
+	case SamplingNatural:
+		if len(c.Sort) > 0 {
+			stages = append(stages, bson.M{"$sort": sortSpec(c.Sort)})
+		}
+		if c.skip > 0 {
+			stages = append(stages, bson.M{"$skip": c.skip})
+		}
+		if limit := s.limit(c); limit != 0 {
+			stages = append(stages, bson.M{"$limit": limit})
+		}
+	case SamplingRandom:
+		size := s.limit(c)
+		if size == 0 {
+			size = defaultSampleSize
+		}
+		stages = append(stages, bson.M{"$sample": bson.M{"size": size}})
+	case SamplingSmart:
+		return nil, fmt.Errorf("mongoschema: smart sampling is not supported by server analysis")
+	default:
+		return nil, fmt.Errorf("mongoschema: unknown sampling %q for collection %s", sampling, c.Name)
+	}
+	if projection != nil {
+		stages = append(stages, bson.M{"$project": projection})
+	}
+	return stages, nil
+}
+
+// sortSpec converts a sort in the form of mgo.Query.Sort to the document of a
+// $sort stage.
+func sortSpec(fields []string) bson.D {
+	var spec bson.D
+	for _, f := range fields {
+		order := 1
+		switch {
+		case strings.HasPrefix(f, "-"):
+			f, order = f[1:], -1
+		case strings.HasPrefix(f, "+"):
+			f = f[1:]
+		}
+		spec = append(spec, bson.DocElem{Name: f, Value: order})
+	}
+	return spec
+}
+
+// analyze infers the type of c on the server.
+func (m *mongoSource) analyze(c Collection) (*StructType, error) {
+	stages, err := m.gen.stages(c)
+	if c.Pipeline != nil {
+		stages, err = m.gen.pipeline(c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	session := m.session.Copy()
+	defer session.Close()
+	collection := session.DB(m.gen.DB).C(c.from())
+	a := &analyzer{gen: m.gen, run: func(pipeline []bson.M, result interface{}) error {
+		return m.gen.pipe(collection, pipeline, true).All(result)
+	}}
+	return a.analyzeStruct(stages)
+}
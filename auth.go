@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// dialTimeout is the timeout mgo.Dial uses.
+const dialTimeout = 10 * time.Second
+
+// AuthConfig holds credentials that override the ones in the URL. Like any
+// setting, the username and password may refer to environment variables as
+// ${VAR}.
+type AuthConfig struct {
+	Username  string `yaml:"username"`
+	Password  string `yaml:"password"`
+	Database  string `yaml:"database"`
+	Mechanism string `yaml:"mechanism"`
+}
+
+// TLSConfig enables TLS when Enabled or any of its files is set.
+type TLSConfig struct {
+	Enabled            bool   `yaml:"enabled"`
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+func (t TLSConfig) enabled() bool {
+	return t.Enabled || t.CAFile != "" || t.CertFile != "" || t.InsecureSkipVerify
+}
+
+// ReadPreference selects the replica set members that are read from. Without
+// a mode, reads go to any member, like mgo.Eventual.
+type ReadPreference struct {
+	Mode string `yaml:"mode"`
+	// Tags are the tag sets of the eligible secondaries, in order of
+	// preference, such as [{use: analytics}].
+	Tags []map[string]string `yaml:"tags"`
+}
+
+var readModes = map[string]mgo.Mode{
+	"primary":            mgo.Primary,
+	"primaryPreferred":   mgo.PrimaryPreferred,
+	"secondary":          mgo.Secondary,
+	"secondaryPreferred": mgo.SecondaryPreferred,
+	"nearest":            mgo.Nearest,
+}
+
+// apply sets the read mode and tag sets of session.
+func (r ReadPreference) apply(session *mgo.Session) {
+	mode, ok := readModes[r.Mode]
+	if !ok {
+		mode = mgo.Eventual
+	}
+	session.SetMode(mode, true)
+	if len(r.Tags) == 0 {
+		return
+	}
+	tags := make([]bson.D, len(r.Tags))
+	for i, set := range r.Tags {
+		var keys []string
+		for k := range set {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			tags[i] = append(tags[i], bson.DocElem{Name: k, Value: set[k]})
+		}
+	}
+	session.SelectServers(tags...)
+}
+
+// unsupportedMechanisms are the mechanisms that MongoDB supports but mgo does
+// not implement.
+var unsupportedMechanisms = map[string]bool{
+	"SCRAM-SHA-256": true,
+	"MONGODB-AWS":   true,
+}
+
+// dialInfo parses the URL and applies the auth and TLS config to it.
+func (s *Generator) dialInfo() (*mgo.DialInfo, error) {
+	raw, urlTLS, err := s.dialURL()
+	if err != nil {
+		return nil, err
+	}
+	info, err := mgo.ParseURL(raw)
+	if err != nil {
+		return nil, err
+	}
+	info.Timeout = dialTimeout
+	info.PoolLimit = s.Connection.PoolLimit
+	if s.ReplicaSet != "" {
+		info.ReplicaSetName = s.ReplicaSet
+	}
+	if s.Direct {
+		info.Direct = true
+	}
+	if s.Auth.Username != "" {
+		info.Username = s.Auth.Username
+	}
+	if s.Auth.Password != "" {
+		info.Password = s.Auth.Password
+	}
+	if s.Auth.Database != "" {
+		info.Source = s.Auth.Database
+	}
+	if m := strings.ToUpper(s.Auth.Mechanism); m != "" {
+		if unsupportedMechanisms[m] {
+			return nil, fmt.Errorf("mongoschema: auth mechanism %s is not supported by the mgo driver", m)
+		}
+		if m == "X509" {
+			m = "MONGODB-X509"
+		}
+		info.Mechanism = m
+	}
+	if s.TLS.enabled() || urlTLS {
+		config, err := s.TLS.config()
+		if err != nil {
+			return nil, err
+		}
+		info.DialServer = func(addr *mgo.ServerAddr) (net.Conn, error) {
+			return tls.DialWithDialer(s.Connection.dialer(), "tcp", addr.String(), config)
+		}
+	} else if s.Connection.KeepAlive != "" {
+		info.DialServer = func(addr *mgo.ServerAddr) (net.Conn, error) {
+			return s.Connection.dialer().Dial("tcp", addr.String())
+		}
+	}
+	return info, nil
+}
+
+func (t TLSConfig) config() (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: t.InsecureSkipVerify}
+	if t.CAFile != "" {
+		pem, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("mongoschema: no certificates found in %s", t.CAFile)
+		}
+	}
+	if t.CertFile != "" {
+		// The key may be in the same PEM file as the certificate, as mongo
+		// shell's --tlsCertificateKeyFile expects.
+		keyFile := t.KeyFile
+		if keyFile == "" {
+			keyFile = t.CertFile
+		}
+		cert, err := tls.LoadX509KeyPair(t.CertFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
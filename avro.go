@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+)
+
+var avroNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// avroWriter converts Type trees to Avro schemas. Avro names must be
+// defined exactly once, so it tracks the names already defined and refers to
+// them by name afterwards.
+type avroWriter struct {
+	gen     *Generator
+	decls   map[string]Type
+	defined map[string]bool
+}
+
+// renderAvro writes an Avro record schema for every collection. Named
+// structs and enums are defined where they are first used.
+func (s *Generator) renderAvro(w io.Writer, schemas []Schema) error {
+	for _, schema := range schemas {
+		a := &avroWriter{gen: s, decls: map[string]Type{}, defined: map[string]bool{}}
+		for _, n := range schema.Decls {
+			a.decls[n.Name] = n.Type
+		}
+		doc := a.record(a.unique(schema.Collection.Struct), schema.Root)
+		namespace := s.AvroNamespace
+		if namespace == "" {
+			namespace = s.Package
+		}
+		if namespace != "" {
+			doc["namespace"] = namespace
+		}
+		if err := writeJSON(w, doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *avroWriter) record(name string, s *StructType) map[string]interface{} {
+	var fields []interface{}
+	names := map[string]bool{}
+	for _, k := range s.keys(a.gen) {
+		fieldName := avroName(k)
+		for base, i := fieldName, 2; names[fieldName]; i++ {
+			fieldName = fmt.Sprintf("%s_%d", base, i)
+		}
+		names[fieldName] = true
+
+		field := map[string]interface{}{"name": fieldName}
+		typ := a.schema(s.Fields[k].Type, name+a.gen.makeFieldName(k))
+		if a.gen.InferOptional && s.required(k) {
+			field["type"] = typ
+		} else {
+			field["type"] = a.nullable(typ)
+			field["default"] = nil
+		}
+		if fieldName != k {
+			field["aliases"] = []string{k}
+			field["doc"] = fmt.Sprintf("BSON field %q", k)
+		}
+		fields = append(fields, field)
+	}
+	if fields == nil {
+		fields = []interface{}{}
+	}
+	return map[string]interface{}{"type": "record", "name": name, "fields": fields}
+}
+
+// schema returns the Avro schema of t. Nested structs become records named
+// after their path.
+func (a *avroWriter) schema(t Type, name string) interface{} {
+	switch v := t.(type) {
+	case *StructType:
+		return a.record(a.unique(name), v)
+	case SliceType:
+		if isNil(v.Type) {
+			return map[string]interface{}{"type": "array", "items": "null"}
+		}
+		return map[string]interface{}{"type": "array", "items": a.schema(v.Type, name+"Item")}
+	case TupleType:
+		if e, ok := v.uniform(a.gen); ok {
+			return map[string]interface{}{"type": "array", "items": a.schema(e, name+"Item")}
+		}
+		return map[string]interface{}{"type": "array", "items": a.schema(newMixedType(a.gen, v.Types...), name+"Item")}
+	case MapType:
+		return map[string]interface{}{"type": "map", "values": a.schema(v.Value, name+"Value")}
+	case MixedType:
+		var union []interface{}
+		for i, e := range v {
+			union = append(union, a.union(a.schema(e, fmt.Sprintf("%s%d", name, i+1)))...)
+		}
+		return union
+	case NamedType:
+		if a.defined[v.Name] {
+			return v.Name
+		}
+		if st, ok := a.decls[v.Name].(*StructType); ok {
+			a.defined[v.Name] = true
+			return a.record(v.Name, st)
+		}
+		return a.schema(a.decls[v.Name], v.Name)
+	case EnumType:
+		for _, e := range v.Values {
+			if !avroNameRe.MatchString(e) {
+				return "string"
+			}
+		}
+		a.defined[name] = true
+		return map[string]interface{}{"type": "enum", "name": name, "symbols": v.Values}
+	case OverrideType:
+		return a.schema(v.Inferred, name)
+	case GeoJSONType:
+		if a.defined["Geo"+v.Shape] {
+			return "Geo" + v.Shape
+		}
+		return a.record(a.unique("Geo"+v.Shape), v.Struct())
+	case LegacyPointType:
+		return a.schema(v.Tuple(), name)
+	case PrimitiveType:
+		return a.primitive(v)
+	}
+	return "null"
+}
+
+// nullable makes the schema t a union with null, null first so that it can
+// be the default.
+func (a *avroWriter) nullable(t interface{}) interface{} {
+	union := []interface{}{"null"}
+	for _, e := range a.union(t) {
+		if e != "null" {
+			union = append(union, e)
+		}
+	}
+	if len(union) == 1 {
+		return "null"
+	}
+	return union
+}
+
+// union returns the members of t as a union, which cannot be nested.
+func (a *avroWriter) union(t interface{}) []interface{} {
+	if u, ok := t.([]interface{}); ok {
+		return u
+	}
+	return []interface{}{t}
+}
+
+func (a *avroWriter) primitive(p PrimitiveType) interface{} {
+	switch p {
+	case PrimitiveBool:
+		return "boolean"
+	case PrimitiveDouble:
+		return "double"
+	case PrimitiveInt32:
+		return "int"
+	case PrimitiveInt64, PrimitiveMongoTimestamp:
+		return "long"
+	case PrimitiveTimestamp:
+		return map[string]interface{}{"type": "long", "logicalType": "timestamp-millis"}
+	case PrimitiveObjectId:
+		if a.defined["ObjectId"] {
+			return "ObjectId"
+		}
+		a.defined["ObjectId"] = true
+		return map[string]interface{}{"type": "fixed", "name": "ObjectId", "size": 12}
+	case PrimitiveUUID:
+		return map[string]interface{}{"type": "string", "logicalType": "uuid"}
+	case PrimitiveBinary, PrimitiveBytes:
+		return "bytes"
+	case PrimitiveDBRef:
+		if a.defined["DBRef"] {
+			return "DBRef"
+		}
+		a.defined["DBRef"] = true
+		return map[string]interface{}{
+			"type": "record",
+			"name": "DBRef",
+			"fields": []interface{}{
+				map[string]interface{}{"name": "ref", "type": "string", "aliases": []string{"$ref"}},
+				map[string]interface{}{"name": "id", "type": "string", "aliases": []string{"$id"}},
+				map[string]interface{}{"name": "db", "type": []interface{}{"null", "string"}, "default": nil, "aliases": []string{"$db"}},
+			},
+		}
+	}
+	return "string"
+}
+
+// unique returns name, or name with a numeric suffix if it is already
+// defined or reserved by a named type, and marks the result defined.
+func (a *avroWriter) unique(name string) string {
+	for base, i := name, 2; a.defined[name] || a.decls[name] != nil; i++ {
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+	a.defined[name] = true
+	return name
+}
+
+// avroName replaces the characters not allowed in Avro names with
+// underscores.
+func avroName(k string) string {
+	if avroNameRe.MatchString(k) {
+		return k
+	}
+	b := []byte(k)
+	for i, c := range b {
+		if !(c == '_' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || i > 0 && c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// baseStruct returns the name of the struct embedding the base fields.
+func (s *Generator) baseStruct() string {
+	if s.BaseStruct != "" {
+		return s.BaseStruct
+	}
+	return "BaseModel"
+}
+
+// embedBase moves the base fields out of the roots of schemas into a struct
+// embedded in each of them, declared with the first. A collection lacking
+// any of the base fields, or having one of a different Go type than the
+// other collections, keeps its own fields.
+func (s *Generator) embedBase(schemas []Schema, h *hoister) {
+	base := newStructType()
+	var types []string
+	var embedding []int
+	for i, schema := range schemas {
+		root := schema.Root
+		keys := root.keys(s)
+		var missing []string
+		var ts []string
+		for _, k := range s.BaseFields {
+			if !sscontains(keys, k) {
+				missing = append(missing, k)
+				continue
+			}
+			goType, _ := s.fieldType(root, k)
+			ts = append(ts, goType)
+		}
+		if len(missing) > 0 {
+			s.logger().Info("base fields not embedded", "collection", schema.Collection.Name, "missing", strings.Join(missing, ", "))
+			continue
+		}
+		if types == nil {
+			types = ts
+		}
+		if conflict := typeConflict(s.BaseFields, types, ts); conflict != "" {
+			s.logger().Warn("base fields not embedded", "collection", schema.Collection.Name, "conflict", conflict)
+			continue
+		}
+		for _, k := range s.BaseFields {
+			if e, ok := base.Fields[k]; ok {
+				e.merge(root.Fields[k], s)
+			} else {
+				base.Fields[k] = root.Fields[k]
+			}
+			delete(root.Fields, k)
+		}
+		base.Count += root.Count
+		embedding = append(embedding, i)
+	}
+	if len(embedding) == 0 {
+		s.logger().Warn("no collection has all the base fields")
+		return
+	}
+	decl := &NamedType{Name: h.unique(s.baseStruct()), Type: base}
+	for _, i := range embedding {
+		schemas[i].Root.embed = decl
+	}
+	first := &schemas[embedding[0]]
+	first.Decls = append([]NamedType{*decl}, first.Decls...)
+}
+
+// typeConflict returns the first of keys whose Go type in got differs from
+// want, or "".
+func typeConflict(keys, want, got []string) string {
+	for i, k := range keys {
+		if want[i] != got[i] {
+			return fmt.Sprintf("%s is %s, not %s", k, got[i], want[i])
+		}
+	}
+	return ""
+}
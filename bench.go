@@ -0,0 +1,18 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// report writes the number of documents scanned in elapsed, their rate and
+// the peak resident memory of the process to w.
+func (s *Generator) report(w io.Writer, elapsed time.Duration) {
+	fmt.Fprintf(w, "scanned %d documents in %s (%.0f docs/s)", s.scanned, elapsed.Round(time.Millisecond),
+		float64(s.scanned)/elapsed.Seconds())
+	if rss, ok := peakRSS(); ok {
+		fmt.Fprintf(w, ", peak RSS %.1f MiB", float64(rss)/(1<<20))
+	}
+	fmt.Fprintln(w)
+}
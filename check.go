@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Check re-infers the schema of every collection and compares it to the
+// struct types declared in the Go file named filename, which is typically
+// the previous output of mongoschema. Added, removed and retyped fields are
+// reported to w, and drift is true if there were any.
+func (s *Generator) Check(ctx context.Context, filename string, w io.Writer) (drift bool, err error) {
+	if len(s.Databases) > 0 {
+		return false, errDatabases
+	}
+	run, cancel := s.withTimeout(ctx)
+	defer cancel()
+	src, err := s.source(run)
+	if err != nil {
+		return false, err
+	}
+	defer src.Close()
+	schemas, err := s.infer(run, src)
+	if err != nil {
+		return false, err
+	}
+	// The schemas of a partial scan would report fields as removed.
+	if ctx.Err() != nil {
+		return false, interrupted(ctx)
+	}
+	return s.checkSchemas(schemas, filename, w)
+}
+
+func (s *Generator) checkSchemas(schemas []Schema, filename string, w io.Writer) (drift bool, err error) {
+	src, err := s.typesSource(schemas)
+	if err != nil {
+		return false, err
+	}
+	fset := token.NewFileSet()
+	want, err := parser.ParseFile(fset, "generated.go", src, 0)
+	if err != nil {
+		return false, fmt.Errorf("mongoschema: parsing generated code: %s", err)
+	}
+	have, err := parser.ParseFile(fset, filename, nil, 0)
+	if err != nil {
+		return false, err
+	}
+	return diffTypes(fset, have, want, w), nil
+}
+
+// typesSource renders the Go type declarations of the schemas as a file that
+// can be parsed.
+func (s *Generator) typesSource(schemas []Schema) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "package generated")
+	if err := s.renderTypes(&buf, schemas); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// diffTypes reports the differences between the type declarations of have
+// and want to w, and returns whether there were any.
+func diffTypes(fset *token.FileSet, have, want *ast.File, w io.Writer) bool {
+	c := checker{fset: fset, w: w, have: typeSpecs(have), want: typeSpecs(want)}
+	for _, spec := range c.want {
+		c.compareSpec(spec)
+	}
+	return c.drift
+}
+
+type checker struct {
+	fset  *token.FileSet
+	w     io.Writer
+	have  map[string]*ast.TypeSpec
+	want  map[string]*ast.TypeSpec
+	drift bool
+}
+
+func typeSpecs(f *ast.File) map[string]*ast.TypeSpec {
+	specs := map[string]*ast.TypeSpec{}
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			ts := spec.(*ast.TypeSpec)
+			specs[ts.Name.Name] = ts
+		}
+	}
+	return specs
+}
+
+func (c *checker) report(format string, args ...interface{}) {
+	c.drift = true
+	fmt.Fprintf(c.w, format+"\n", args...)
+}
+
+func (c *checker) compareSpec(want *ast.TypeSpec) {
+	have, ok := c.have[want.Name.Name]
+	if !ok {
+		c.report("%s: type missing", want.Name.Name)
+		return
+	}
+	c.compare(want.Name.Name, have.Type, want.Type)
+}
+
+// compare reports the differences between the types of the field at path.
+// Struct types are compared field by field, keyed by their bson names.
+func (c *checker) compare(path string, have, want ast.Expr) {
+	hs, hok := have.(*ast.StructType)
+	ws, wok := want.(*ast.StructType)
+	if !hok || !wok {
+		if h, w := c.expr(have), c.expr(want); h != w {
+			c.report("%s: type changed from %s to %s", path, h, w)
+		}
+		return
+	}
+	hf, wf := bsonFields(hs, c.have), bsonFields(ws, c.want)
+	var keys []string
+	for k := range wf {
+		keys = append(keys, k)
+	}
+	for k := range hf {
+		if _, ok := wf[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		h, hok := hf[k]
+		w, wok := wf[k]
+		switch {
+		case !hok:
+			c.report("%s.%s: added (%s)", path, k, c.expr(w.typ))
+		case !wok:
+			c.report("%s.%s: removed (%s)", path, k, c.expr(h.typ))
+		case h.from != "" && h.from == w.from:
+			// Compared with the struct both inline, not in each embedding it.
+		default:
+			c.compare(path+"."+k, h.typ, w.typ)
+		}
+	}
+}
+
+// expr prints e, abbreviating struct bodies to keep reports to one line.
+func (c *checker) expr(e ast.Expr) string {
+	var buf bytes.Buffer
+	printer.Fprint(&buf, c.fset, e)
+	str := buf.String()
+	if i := strings.IndexByte(str, '\n'); i >= 0 {
+		str = str[:i] + "...}"
+	}
+	return str
+}
+
+// bsonField is the type of a field of a struct, and the name of the struct
+// it was inlined from, if any.
+type bsonField struct {
+	typ  ast.Expr
+	from string
+}
+
+// bsonFields maps the bson key of each field in s to its type. As in mgo,
+// the key of an untagged field is its lowercased name, and the fields of an
+// embedded struct tagged inline, declared among specs, are merged into s.
+func bsonFields(s *ast.StructType, specs map[string]*ast.TypeSpec) map[string]bsonField {
+	return inlineFields(s, specs, map[string]bool{})
+}
+
+// inlineFields is bsonFields, not inlining the structs in seen again, in
+// case one embeds itself.
+func inlineFields(s *ast.StructType, specs map[string]*ast.TypeSpec, seen map[string]bool) map[string]bsonField {
+	fields := map[string]bsonField{}
+	inlined := map[string]bsonField{}
+	for _, f := range s.Fields.List {
+		var opts []string
+		if f.Tag != nil {
+			tag := reflect.StructTag(strings.Trim(f.Tag.Value, "`"))
+			opts = strings.Split(tag.Get("bson"), ",")
+		}
+		if len(f.Names) == 0 {
+			name := embeddedName(f.Type)
+			if name == "" {
+				continue
+			}
+			if hasOption(opts, "inline") {
+				if spec, ok := specs[name]; ok && !seen[name] {
+					if st, ok := spec.Type.(*ast.StructType); ok {
+						seen[name] = true
+						for k, bf := range inlineFields(st, specs, seen) {
+							if bf.from == "" {
+								bf.from = name
+							}
+							inlined[k] = bf
+						}
+					}
+				}
+				continue
+			}
+			addBSONField(fields, name, opts, f.Type)
+			continue
+		}
+		for _, name := range f.Names {
+			addBSONField(fields, name.Name, opts, f.Type)
+		}
+	}
+	// As in mgo, the fields of s take precedence over the inlined ones.
+	for k, bf := range inlined {
+		if _, ok := fields[k]; !ok {
+			fields[k] = bf
+		}
+	}
+	return fields
+}
+
+// addBSONField adds the field name of type t to fields under its bson key,
+// given by the options of its bson tag.
+func addBSONField(fields map[string]bsonField, name string, opts []string, t ast.Expr) {
+	key := strings.ToLower(name)
+	if len(opts) > 0 {
+		if opts[0] == "-" {
+			return
+		} else if opts[0] != "" {
+			key = opts[0]
+		}
+	}
+	fields[key] = bsonField{typ: t}
+}
+
+// embeddedName returns the name of the type of an embedded field, or "" if
+// it is not a named type.
+func embeddedName(t ast.Expr) string {
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	switch t := t.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.SelectorExpr:
+		return t.Sel.Name
+	}
+	return ""
+}
+
+// hasOption reports whether opt is among the options of a struct tag.
+func hasOption(opts []string, opt string) bool {
+	for _, o := range opts {
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+// TestCheckEmbeddedBase checks a file whose structs embed their base fields
+// inline, reporting the drift of a base field once, with the base struct.
+func TestCheckEmbeddedBase(t *testing.T) {
+	g, err := loadConfig("testdata/orders_base.yaml", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Quiet = true
+	fixtures, err := LoadFixtures("testdata/orders.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schemas, err := g.infer(context.Background(), fixtures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	drift, err := g.checkSchemas(schemas, "testdata/orders_base.go", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "BaseModel.total: type changed from int to float64\n"
+	if !drift || buf.String() != want {
+		t.Fatalf("got drift %t and report\n%s\nwant\n%s", drift, buf.String(), want)
+	}
+}
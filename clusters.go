@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// clusterURL returns the URL of an entry of the clusters of a collection,
+// which is either the name of one of the configured clusters or a URL.
+func (s *Generator) clusterURL(entry string) string {
+	if url, ok := s.Clusters[entry]; ok {
+		return url
+	}
+	return entry
+}
+
+// clusterLabel names an entry of the clusters of a collection in logs, by
+// its hosts if it is a URL, leaving out its credentials and options.
+func clusterLabel(entry string) string {
+	i := strings.Index(entry, "://")
+	if i < 0 {
+		return entry
+	}
+	hosts := entry[i+3:]
+	if j := strings.IndexAny(hosts, "/?"); j >= 0 {
+		hosts = hosts[:j]
+	}
+	if j := strings.LastIndex(hosts, "@"); j >= 0 {
+		hosts = hosts[j+1:]
+	}
+	return hosts
+}
+
+// cluster returns the source reading from the cluster entry, connecting to
+// it the first time with the settings of the config.
+func (m *mongoSource) cluster(ctx context.Context, entry string) (*mongoSource, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if src, ok := m.clusters[entry]; ok {
+		return src, nil
+	}
+	g := *m.gen
+	g.URL = m.gen.clusterURL(entry)
+	session, err := g.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	src := &mongoSource{gen: m.gen, session: session, limiter: m.limiter}
+	if d := duration(m.gen.Connection.Heartbeat); d > 0 {
+		src.heartbeat(d)
+	}
+	if m.clusters == nil {
+		m.clusters = map[string]*mongoSource{}
+	}
+	m.clusters[entry] = src
+	return src, nil
+}
+
+func (m *mongoSource) closeClusters() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, src := range m.clusters {
+		src.Close()
+	}
+	m.clusters = nil
+}
+
+// scanClusters scans c on each of its clusters concurrently, each sampled
+// like a collection of its own, and merges the results. The fields that
+// differ between the clusters, such as those of regions that drifted, are
+// reported before.
+func (s *Generator) scanClusters(ctx context.Context, m *mongoSource, c Collection) (*StructType, error) {
+	names := make([]string, len(c.Clusters))
+	srcs := make([]*mongoSource, len(c.Clusters))
+	for i, entry := range c.Clusters {
+		names[i] = clusterLabel(entry)
+		src, err := m.cluster(ctx, entry)
+		if err != nil {
+			return nil, fmt.Errorf("mongoschema: connecting to cluster %s: %s", names[i], err)
+		}
+		srcs[i] = src
+	}
+	part := c
+	part.Clusters = nil
+	roots := make([]*StructType, len(srcs))
+	errs := make([]error, len(srcs))
+	var wg sync.WaitGroup
+	for i, src := range srcs {
+		wg.Add(1)
+		go func(i int, src *mongoSource) {
+			defer wg.Done()
+			roots[i], errs[i] = s.scanCollection(ctx, src, part)
+		}(i, src)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("mongoschema: cluster %s: %s", names[i], err)
+		}
+	}
+	for _, d := range s.rootDifferences(c.Name, names, roots) {
+		s.logger().Warn("field differs between clusters", "collection", c.Name, "difference", d)
+	}
+	root := newStructType()
+	for _, r := range roots {
+		if r != nil {
+			root.Merge(r, s)
+		}
+	}
+	return root, nil
+}
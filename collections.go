@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Collections is the list of collections to scan. In the YAML config it may
+// also be "*", which like an empty list means every collection of the
+// database.
+type Collections []Collection
+
+func (c *Collections) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var all string
+	if err := unmarshal(&all); err == nil {
+		if all != "*" {
+			return fmt.Errorf("mongoschema: collections must be a list or \"*\", not %q", all)
+		}
+		*c = nil
+		return nil
+	}
+	return unmarshal((*[]Collection)(c))
+}
+
+// lister is implemented by sources that can enumerate their collections.
+type lister interface {
+	collectionNames() ([]string, error)
+}
+
+// resolveCollections lists the collections of src matching Include and not
+// Exclude if none are configured, and derives the struct names that are not
+// configured from the collection names.
+func (s *Generator) resolveCollections(src Source) error {
+	if len(s.Collections) == 0 {
+		l, ok := src.(lister)
+		if !ok {
+			return fmt.Errorf("mongoschema: source %q cannot list collections", s.Source)
+		}
+		names, err := l.collectionNames()
+		if err != nil {
+			return err
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			ok, err := s.selected(name)
+			if err != nil {
+				return err
+			}
+			if ok {
+				s.Collections = append(s.Collections, Collection{Name: name})
+			}
+		}
+		if len(s.Collections) == 0 {
+			return fmt.Errorf("mongoschema: no collections found in %s", s.DB)
+		}
+	}
+	for i, c := range s.Collections {
+		if c.Struct == "" {
+			s.Collections[i].Struct = s.structName(c.Name)
+		}
+	}
+	return nil
+}
+
+// selected reports whether the collection named name matches the Include
+// and not the Exclude glob patterns. System collections are never selected.
+func (s *Generator) selected(name string) (bool, error) {
+	if strings.HasPrefix(name, "system.") {
+		return false, nil
+	}
+	included := len(s.Include) == 0
+	for _, pattern := range s.Include {
+		ok, err := path.Match(pattern, name)
+		if err != nil {
+			return false, fmt.Errorf("mongoschema: invalid include pattern %q: %s", pattern, err)
+		}
+		included = included || ok
+	}
+	if !included {
+		return false, nil
+	}
+	for _, pattern := range s.Exclude {
+		ok, err := path.Match(pattern, name)
+		if err != nil {
+			return false, fmt.Errorf("mongoschema: invalid exclude pattern %q: %s", pattern, err)
+		}
+		if ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (m *mongoSource) collectionNames() ([]string, error) {
+	return m.session.DB(m.gen.DB).CollectionNames()
+}
+
+// collectionNames lists the collections of a dump, in which time-series
+// collections are dumped as their bucket collections.
+func (d *dumpSource) collectionNames() ([]string, error) {
+	if d.archive {
+		names, err := archiveCollections(d.gen.Dump, d.gen.DB)
+		return logicalNames(names), err
+	}
+	names, err := listFiles([]string{filepath.Join(d.gen.Dump, d.gen.DB), d.gen.Dump}, []string{".bson", ".bson.gz"})
+	return logicalNames(names), err
+}
+
+func (e *exportSource) collectionNames() ([]string, error) {
+	if e.file {
+		return []string{exportName(e.gen.Export)}, nil
+	}
+	return listFiles([]string{filepath.Join(e.gen.Export, e.gen.DB), e.gen.Export}, exportExts)
+}
+
+// listFiles returns the names, without extension, of the files with one of
+// exts in the first of dirs that has any.
+func listFiles(dirs, exts []string) ([]string, error) {
+	for _, dir := range dirs {
+		infos, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, fi := range infos {
+			if fi.IsDir() {
+				continue
+			}
+			for _, ext := range exts {
+				if strings.HasSuffix(fi.Name(), ext) {
+					names = append(names, strings.TrimSuffix(fi.Name(), ext))
+					break
+				}
+			}
+		}
+		if len(names) > 0 {
+			return names, nil
+		}
+	}
+	return nil, nil
+}
+
+// archiveCollections returns the collections of db in a mongodump archive by
+// reading every block header.
+func archiveCollections(name, db string) ([]string, error) {
+	r, err := openMaybeGzip(name)
+	if err != nil {
+		return nil, err
+	}
+	i, err := newArchiveIter(r, db, "")
+	if err != nil {
+		return nil, err
+	}
+	defer i.Close()
+	seen := map[string]bool{}
+	var names []string
+	for {
+		doc, err := readDoc(i.r)
+		if err == io.EOF {
+			return names, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var h archiveHeader
+		if err := bson.Unmarshal(doc, &h); err != nil {
+			return nil, err
+		}
+		if !seen[h.Collection] && (db == "" || h.DB == db) {
+			seen[h.Collection] = true
+			names = append(names, h.Collection)
+		}
+		if err := i.skipBlock(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// structName derives a struct name from the name of a collection, which is
+// usually plural: order_items becomes OrderItem.
+func (s *Generator) structName(collection string) string {
+	parts := split(collection)
+	if len(parts) == 0 {
+		return s.makeFieldName(collection)
+	}
+	last := len(parts) - 1
+	parts[last] = s.singular(parts[last])
+	return s.makeFieldName(strings.Join(parts, "_"))
+}
+
+// irregularPlurals maps the plurals that the suffix rules of singular get
+// wrong.
+var irregularPlurals = map[string]string{
+	"analyses": "analysis",
+	"children": "child",
+	"criteria": "criterion",
+	"feet":     "foot",
+	"geese":    "goose",
+	"indices":  "index",
+	"matrices": "matrix",
+	"men":      "man",
+	"mice":     "mouse",
+	"movies":   "movie",
+	"people":   "person",
+	"teeth":    "tooth",
+	"vertices": "vertex",
+	"women":    "woman",
+}
+
+// uncountables end like plurals but are singular.
+var uncountables = map[string]bool{
+	"data":        true,
+	"information": true,
+	"metadata":    true,
+	"news":        true,
+	"series":      true,
+	"species":     true,
+}
+
+var pluralSuffixes = []struct{ plural, singular string }{
+	{"sses", "ss"},
+	{"shes", "sh"},
+	{"ches", "ch"},
+	{"xes", "x"},
+	{"zzes", "zz"},
+	{"ies", "y"},
+}
+
+// singular returns the English singular of the plural word, consulting the
+// configured Singulars before the built-in rules.
+func (s *Generator) singular(word string) string {
+	lower := strings.ToLower(word)
+	if w, ok := s.Singulars[lower]; ok {
+		return w
+	}
+	if w, ok := irregularPlurals[lower]; ok {
+		return w
+	}
+	if uncountables[lower] {
+		return word
+	}
+	for _, suffix := range pluralSuffixes {
+		if strings.HasSuffix(lower, suffix.plural) && len(lower) > len(suffix.plural) {
+			return word[:len(word)-len(suffix.plural)] + suffix.singular
+		}
+	}
+	if strings.HasSuffix(lower, "ss") || strings.HasSuffix(lower, "us") || strings.HasSuffix(lower, "is") {
+		return word
+	}
+	if strings.HasSuffix(lower, "s") && len(lower) > 1 {
+		return word[:len(word)-1]
+	}
+	return word
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// configErrors collects the problems found in a config, so that they can all
+// be reported at once.
+type configErrors []string
+
+func (e *configErrors) add(format string, args ...interface{}) {
+	*e = append(*e, fmt.Sprintf(format, args...))
+}
+
+// oneOf checks that value, the setting key, is empty or one of valid.
+func (e *configErrors) oneOf(key, value string, valid ...string) {
+	if value == "" {
+		return
+	}
+	for _, v := range valid {
+		if value == v {
+			return
+		}
+	}
+	e.add("%s: %q is not one of %s", key, value, strings.Join(valid, ", "))
+}
+
+// patterns checks that the glob patterns of the setting key are valid.
+func (e *configErrors) patterns(key string, patterns []string) {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			e.add("%s: invalid pattern %q", key, p)
+		}
+	}
+}
+
+func (e configErrors) Error() string {
+	return "mongoschema: invalid config:\n  " + strings.Join(e, "\n  ")
+}
+
+// Validate checks the config for missing and invalid settings, without
+// connecting to the database.
+func (s *Generator) Validate() error {
+	var errs configErrors
+	switch s.Source {
+	case "", SourceMongo:
+		if s.URL == "" && len(s.Clusters) == 0 && len(s.Databases) == 0 && s.ImportSchema == "" {
+			errs.add("url: required for the mongo source")
+		}
+	case SourceDump:
+		if s.Dump == "" {
+			errs.add("dump: required for the dump source")
+		}
+	case SourceJSON:
+		if s.Export == "" {
+			errs.add("export: required for the json source")
+		}
+	default:
+		errs.oneOf("source", s.Source, SourceMongo, SourceDump, SourceJSON)
+	}
+	if s.Direct && s.ReplicaSet != "" {
+		errs.add("direct: cannot discover the replica_set %s", s.ReplicaSet)
+	}
+	errs.oneOf("sampling", s.Sampling, SamplingNatural, SamplingRandom, SamplingSmart)
+	errs.oneOf("analysis", s.Analysis, AnalysisClient, AnalysisServer)
+	errs.oneOf("optional_style", s.OptionalStyle, OptionalPointer, OptionalNull)
+	errs.oneOf("int_policy", s.IntPolicy, IntPolicyInt64, IntPolicyPreserve, IntPolicyObserved)
+	errs.oneOf("struct_naming", s.StructNaming, StructNamingPath, StructNamingField)
+	errs.oneOf("legacy_coordinates", s.LegacyCoords, LegacyCoordsArray, LegacyCoordsPoint)
+	errs.oneOf("field_order", s.FieldOrder, FieldOrderAlpha, FieldOrderPresence, FieldOrderDocument, FieldOrderIDFirst, FieldOrderRequired)
+	errs.oneOf("format", s.Format, s.formats()...)
+	formats := map[string]bool{}
+	for i, r := range s.Renderers {
+		key := fmt.Sprintf("renderers[%d]", i)
+		switch _, registered := renderers[r.Format]; {
+		case r.Format == "":
+			errs.add("%s.format: required", key)
+		case sscontains(builtinFormats, r.Format) || registered || formats[r.Format]:
+			errs.add("%s.format: format %s is already defined", key, r.Format)
+		}
+		formats[r.Format] = true
+		if len(r.Command) == 0 {
+			errs.add("%s.command: required", key)
+		}
+		if !strings.HasPrefix(r.Ext, ".") {
+			errs.add("%s.ext: %q does not start with a dot", key, r.Ext)
+		}
+	}
+	errs.oneOf("sql_dialect", s.SQLDialect, SQLDialectPostgres, SQLDialectMySQL, SQLDialectBigQuery)
+	errs.oneOf("sql_nested", s.SQLNested, SQLNestedJSON, SQLNestedFlatten)
+	errs.oneOf("mongoose_lang", s.MongooseLang, MongooseJS, MongooseTS)
+	if s.Registry.URL != "" {
+		if f := s.registryFormat(); f != FormatAvro && f != FormatJSONSchema {
+			errs.add("registry.format: required to be avro or jsonschema unless the output format is")
+		}
+	}
+	errs.oneOf("registry.subject", s.Registry.Subject, SubjectTopic, SubjectRecord, SubjectTopicRecord)
+	errs.oneOf("python_style", s.PythonStyle, PythonPydantic, PythonDataclass)
+	errs.oneOf("flat_style", s.FlatStyle, FlatCSV, FlatJSON)
+	if (s.Header || s.HeaderHash) && s.commentPrefix() == "" {
+		errs.add("header: not supported by the %s format", s.Format)
+	}
+	errs.oneOf("proto_numbering", s.ProtoNumbering, ProtoNumberingSequential, ProtoNumberingHash)
+	errs.oneOf("output.layout", s.Output.Layout, LayoutSingle, LayoutCollection)
+	errs.oneOf("output.sink", s.Output.Sink, SinkFile, SinkStdout, SinkHTTP)
+	if s.Output.Sink == SinkHTTP && s.Output.URL == "" {
+		errs.add("output.url: required for the http sink")
+	} else if s.Output.Sink != SinkHTTP && (s.Output.URL != "" || s.Output.Token != "") {
+		errs.add("output.url: only used by the http sink")
+	}
+	if s.JVMPackage != "" && !jvmPackageRe.MatchString(s.JVMPackage) {
+		errs.add("jvm_package: %q is not a valid package name", s.JVMPackage)
+	}
+	if s.JavaClass != "" && !token.IsIdentifier(s.JavaClass) {
+		errs.add("java_class: %q is not a valid identifier", s.JavaClass)
+	}
+	if s.Format == FormatJava && s.Output.Layout == LayoutCollection {
+		errs.add("output.layout: the java format nests all classes in java_class, so requires the single layout")
+	}
+	if _, ok := readModes[s.ReadPreference.Mode]; !ok && s.ReadPreference.Mode != "" {
+		errs.add("read_preference.mode: %q is not one of primary, primaryPreferred, secondary, secondaryPreferred, nearest", s.ReadPreference.Mode)
+	} else if len(s.ReadPreference.Tags) > 0 && (s.ReadPreference.Mode == "" || s.ReadPreference.Mode == "primary") {
+		errs.add("read_preference.tags: require a mode other than primary")
+	}
+	if s.ValidateMethods && s.Format != "" && s.Format != FormatGo {
+		errs.add("validate_methods: only supported by the go format")
+	}
+	if s.DocComments && s.Format != "" && s.Format != FormatGo {
+		errs.add("doc_comments: only supported by the go format")
+	}
+	if s.IndexMethods && s.Format != "" && s.Format != FormatGo {
+		errs.add("index_methods: only supported by the go format")
+	}
+	if s.Helpers && s.Format != "" && s.Format != FormatGo {
+		errs.add("helpers: only supported by the go format")
+	}
+	if s.SharedStructs && !s.NamedStructs {
+		errs.add("shared_structs: requires named_structs")
+	}
+	if s.SharedThreshold < 0 || s.SharedThreshold > 1 {
+		errs.add("shared_threshold: must be between 0 and 1")
+	}
+	if s.LowMemory && s.Source == SourceJSON {
+		errs.add("low_memory: not supported by the json source")
+	}
+	if s.FieldOrder == FieldOrderDocument && s.Source == SourceJSON {
+		errs.add("field_order: document order is not supported by the json source")
+	}
+	if s.MaxTimeMS < 0 {
+		errs.add("max_time_ms: must not be negative")
+	}
+	if s.Retries < 0 {
+		errs.add("retries: must not be negative")
+	}
+	if s.RateLimit < 0 {
+		errs.add("rate_limit: must not be negative")
+	}
+	if s.BatchSize < 0 {
+		errs.add("batch_size: must not be negative")
+	}
+	errs.duration("batch_delay", s.BatchDelay)
+	errs.duration("connection.socket_timeout", s.Connection.SocketTimeout)
+	errs.duration("connection.keepalive", s.Connection.KeepAlive)
+	errs.duration("connection.heartbeat", s.Connection.Heartbeat)
+	if s.Connection.PoolLimit < 0 {
+		errs.add("connection.pool_limit: must not be negative")
+	}
+	if s.PerShard && s.Analysis == AnalysisServer {
+		errs.add("per_shard: not supported with server analysis")
+	}
+	if s.SecondaryOnly && s.ReadPreference.Mode != "" && s.ReadPreference.Mode != "secondary" {
+		errs.add("secondary_only: conflicts with read_preference.mode %s", s.ReadPreference.Mode)
+	}
+	if s.Source != "" && s.Source != SourceMongo {
+		limits := []struct {
+			key string
+			set bool
+		}{
+			{"rate_limit", s.RateLimit > 0},
+			{"batch_size", s.BatchSize > 0},
+			{"batch_delay", s.BatchDelay != ""},
+			{"secondary_only", s.SecondaryOnly},
+			{"per_shard", s.PerShard},
+			{"connection", s.Connection != ConnectionConfig{}},
+		}
+		for _, l := range limits {
+			if l.set {
+				errs.add("%s: only supported by the mongo source", l.key)
+			}
+		}
+	}
+	errs.duration("timeout", s.Timeout)
+	if m := strings.ToUpper(s.Auth.Mechanism); unsupportedMechanisms[m] {
+		errs.add("auth.mechanism: %s is not supported by the mgo driver", m)
+	} else {
+		errs.oneOf("auth.mechanism", m, "SCRAM-SHA-1", "MONGODB-CR", "MONGODB-X509", "X509", "PLAIN", "GSSAPI")
+	}
+	if s.GeoJSON != "" && s.GeoJSON != GeoJSONGenerated && !strings.Contains(s.GeoJSON, "/") {
+		errs.add("geojson: %q is neither %s nor an import path", s.GeoJSON, GeoJSONGenerated)
+	}
+	if s.Package != "" && !token.IsIdentifier(s.Package) {
+		errs.add("package: %q is not a valid Go identifier", s.Package)
+	}
+	if s.MapKeyPattern != "" {
+		if _, err := regexp.Compile(s.MapKeyPattern); err != nil {
+			errs.add("map_key_pattern: %s", err)
+		}
+	}
+	errs.patterns("include", s.Include)
+	errs.patterns("exclude", s.Exclude)
+	errs.patterns("ignored_fields", s.IgnoredFields)
+	errs.patterns("opaque_fields", s.OpaqueFields)
+	if s.ImportSchema != "" && len(s.Databases) > 0 {
+		errs.add("import_schema: not supported with databases")
+	}
+	if s.DecodeWorkers < 0 {
+		errs.add("decode_workers: %d is negative", s.DecodeWorkers)
+	}
+	if s.FixedArrayMin < 0 {
+		errs.add("fixed_array_min: %d is negative", s.FixedArrayMin)
+	}
+	if s.MaxDepth < 0 {
+		errs.add("max_depth: %d is negative", s.MaxDepth)
+	}
+	if s.MinPresence != "" {
+		if _, _, err := s.minPresence(); err != nil {
+			errs.add("min_presence: %q is neither a number of documents nor a percentage between 0%% and 100%%", s.MinPresence)
+		}
+	}
+	if s.ConflictIDs && s.Format != "" && s.Format != FormatGo {
+		errs.add("conflict_ids: only supported by the go format")
+	}
+	if s.RareComments && s.Format != "" && s.Format != FormatGo {
+		errs.add("rare_comments: only supported by the go format")
+	}
+	if len(s.BaseFields) > 0 && s.Format != "" && s.Format != FormatGo {
+		errs.add("base_fields: only supported by the go format")
+	}
+	for _, k := range s.BaseFields {
+		if !isValidFieldName(k) {
+			errs.add("base_fields: %q is not a valid field name", k)
+		}
+	}
+	if s.BaseStruct != "" && (!token.IsIdentifier(s.BaseStruct) || !token.IsExported(s.BaseStruct)) {
+		errs.add("base_struct: %q is not a valid exported Go identifier", s.BaseStruct)
+	}
+	var overrides []string
+	for key := range s.Overrides {
+		overrides = append(overrides, key)
+	}
+	sort.Strings(overrides)
+	for _, key := range overrides {
+		if name := s.Overrides[key]; name == "" {
+			errs.add("overrides[%s]: type required", key)
+		}
+		if !strings.Contains(key, ".") {
+			errs.add("overrides: %q is not a collection and field path", key)
+		}
+	}
+	for i, t := range s.Tags {
+		if t.Key == "" {
+			errs.add("tags[%d].key: required", i)
+		}
+		errs.oneOf(fmt.Sprintf("tags[%d].case", i), t.Case,
+			CaseOriginal, CaseSnake, CaseCamel, CasePascal, CaseKebab, CaseLower)
+	}
+	errs.oneOf("unknown_types", s.UnknownTypes, UnknownTypesRaw, UnknownTypesInterface)
+	errs.oneOf("epoch_times", s.EpochTimes, EpochTimesComment, EpochTimesTime)
+	if s.EpochTimes != "" && s.Format != "" && s.Format != FormatGo {
+		errs.add("epoch_times: only supported by the go format")
+	}
+	errs.oneOf("omitempty", s.OmitEmpty, OmitEmptyAlways, OmitEmptyOptional, OmitEmptyNever)
+	var omitEmptyFields []string
+	for key := range s.OmitEmptyFields {
+		omitEmptyFields = append(omitEmptyFields, key)
+	}
+	sort.Strings(omitEmptyFields)
+	for _, key := range omitEmptyFields {
+		if !strings.Contains(key, ".") {
+			errs.add("omitempty_fields: %q is not a collection and field path", key)
+		}
+	}
+	for i, a := range s.Acronyms {
+		if a == "" || strings.IndexFunc(a, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) >= 0 {
+			errs.add("acronyms[%d]: %q is not a word of letters and digits", i, a)
+		}
+	}
+	var fieldNames []string
+	for key := range s.FieldNames {
+		fieldNames = append(fieldNames, key)
+	}
+	sort.Strings(fieldNames)
+	for _, key := range fieldNames {
+		if !strings.Contains(key, ".") {
+			errs.add("field_names: %q is not a collection and field path", key)
+		}
+		if name := s.FieldNames[key]; !token.IsIdentifier(name) || !token.IsExported(name) {
+			errs.add("field_names.%s: %q is not a valid exported Go identifier", key, name)
+		}
+	}
+	if len(s.FieldNames) > 0 && s.Format != "" && s.Format != FormatGo {
+		errs.add("field_names: only supported by the go format")
+	}
+	for i, key := range s.ExtraTags {
+		errs.oneOf(fmt.Sprintf("extra_tags[%d]", i), key, TagMsgpack, TagCBOR)
+		for _, t := range s.Tags {
+			if t.Key == key {
+				errs.add("extra_tags[%d]: %s is already in tags", i, key)
+			}
+		}
+	}
+	if len(s.ExtraTags) > 0 && s.Format != "" && s.Format != FormatGo {
+		errs.add("extra_tags: only supported by the go format")
+	}
+	errs.collections("collections", s.Collections, s)
+	var pkgs []string
+	for pkg := range s.Packages {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+	for _, pkg := range pkgs {
+		if !token.IsIdentifier(pkg) {
+			errs.add("packages: %q is not a valid Go identifier", pkg)
+		} else if pkg == s.Package {
+			errs.add("packages: %s is the package of the other collections", pkg)
+		}
+		errs.patterns("packages."+pkg, s.Packages[pkg])
+	}
+	dbs := map[string]bool{}
+	packages := map[string]bool{}
+	for i, d := range s.Databases {
+		key := fmt.Sprintf("databases[%d]", i)
+		if d.DB == "" {
+			errs.add("%s.db: required", key)
+		}
+		g := s.database(d)
+		if d.Cluster != "" && s.Clusters[d.Cluster] == "" {
+			errs.add("%s.cluster: unknown cluster %q", key, d.Cluster)
+		} else if (s.Source == "" || s.Source == SourceMongo) && g.URL == "" {
+			errs.add("%s.url: required for the mongo source", key)
+		}
+		if id := g.URL + "/" + d.DB; dbs[id] {
+			errs.add("%s.db: database %s is listed twice", key, d.DB)
+		} else {
+			dbs[id] = true
+		}
+		if !token.IsIdentifier(g.Package) {
+			errs.add("%s.package: %q is not a valid Go identifier", key, g.Package)
+		} else if packages[g.Package] {
+			errs.add("%s.package: package %s is used twice", key, g.Package)
+		}
+		packages[g.Package] = true
+		errs.oneOf(key+".output.layout", d.Output.Layout, LayoutSingle, LayoutCollection)
+		errs.patterns(key+".include", d.Include)
+		errs.patterns(key+".exclude", d.Exclude)
+		errs.collections(key+".collections", d.Collections, s)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// collections checks the collections of the setting key of the config s.
+func (e *configErrors) collections(key string, cs Collections, s *Generator) {
+	names := map[string]bool{}
+	structs := map[string]bool{}
+	for i, c := range cs {
+		key := fmt.Sprintf("%s[%d]", key, i)
+		switch {
+		case c.Name == "":
+			e.add("%s.name: required", key)
+		case names[c.Name]:
+			e.add("%s.name: collection %s is listed twice", key, c.Name)
+		}
+		names[c.Name] = true
+		if c.Struct != "" {
+			if !token.IsIdentifier(c.Struct) || !token.IsExported(c.Struct) {
+				e.add("%s.struct: %q is not a valid exported Go identifier", key, c.Struct)
+			} else if structs[c.Struct] {
+				e.add("%s.struct: struct %s is used twice", key, c.Struct)
+			}
+			structs[c.Struct] = true
+		}
+		e.oneOf(key+".sampling", c.Sampling, SamplingNatural, SamplingRandom, SamplingSmart)
+		e.patterns(key+".ignored_fields", c.IgnoredFields)
+		e.patterns(key+".opaque_fields", c.OpaqueFields)
+		e.duration(key+".timeout", c.Timeout)
+		if _, err := toBSON(c.Filter); err != nil {
+			e.add("%s.filter: %s", key, err)
+		}
+		if _, err := toBSON(c.Projection); err != nil {
+			e.add("%s.projection: %s", key, err)
+		}
+		if _, err := toPipeline(c.Pipeline); err != nil {
+			e.add("%s.pipeline: %s", key, err)
+		}
+		mongo := s.Source == "" || s.Source == SourceMongo
+		if c.Pipeline != nil && !mongo {
+			e.add("%s.pipeline: requires the mongo source", key)
+		}
+		if len(c.Clusters) > 0 && !mongo {
+			e.add("%s.clusters: requires the mongo source", key)
+		}
+		for _, entry := range c.Clusters {
+			if _, ok := s.Clusters[entry]; !ok && !strings.Contains(entry, "://") {
+				e.add("%s.clusters: %q is neither a configured cluster nor a URL", key, entry)
+			}
+		}
+		if _, err := toBSON(c.Reference); err != nil {
+			e.add("%s.reference: %s", key, err)
+		}
+		if c.Reference != nil {
+			switch {
+			case !mongo:
+				e.add("%s.reference: requires the mongo source", key)
+			case c.Pipeline != nil:
+				e.add("%s.reference: not supported with a pipeline", key)
+			case s.FieldOrder != FieldOrderDocument:
+				e.add("%s.reference: requires the document field order", key)
+			}
+		}
+		if c.Discriminator != "" {
+			switch {
+			case strings.Contains(c.Discriminator, "."):
+				e.add("%s.discriminator: must be a top-level field", key)
+			case s.Format != "" && s.Format != FormatGo:
+				e.add("%s.discriminator: only supported by the go format", key)
+			case s.Analysis == AnalysisServer:
+				e.add("%s.discriminator: not supported with server analysis", key)
+			}
+		}
+		if c.From != "" && c.Pipeline == nil {
+			e.add("%s.from: requires a pipeline", key)
+		}
+		if c.Pipeline != nil && c.Sampling == SamplingSmart {
+			e.add("%s.sampling: smart sampling is not supported with a pipeline", key)
+		}
+		if len(c.Sort) > 0 && c.Sampling != "" && c.Sampling != SamplingNatural {
+			e.add("%s.sort: requires natural sampling", key)
+		}
+		for _, f := range c.Sort {
+			if strings.TrimLeft(f, "+-") == "" {
+				e.add("%s.sort: %q is not a field", key, f)
+			}
+		}
+	}
+}
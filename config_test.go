@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestConfigErrorLines checks that the errors of an invalid config give the
+// line of the key they are about.
+func TestConfigErrorLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mongoschema")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "config.yaml")
+	src := `url: localhost
+db: test
+package: main
+collections:
+  - name: company
+    struct: Company
+  # The struct is not exported.
+  - name: orders
+    struct: order
+  - struct: Place
+field_names:
+  company.jobs_url: jobs
+profiles:
+  dev:
+    package: "dev-main"
+`
+	if err := ioutil.WriteFile(name, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		profile string
+		want    []string
+	}{
+		{"", []string{
+			`line 9: collections[1].struct: "order" is not a valid exported Go identifier`,
+			`line 10: collections[2].name: required`,
+			`line 12: field_names.company.jobs_url: "jobs" is not a valid exported Go identifier`,
+		}},
+		{"dev", []string{
+			`line 15: package: "dev-main" is not a valid Go identifier`,
+		}},
+	} {
+		_, err := loadConfig(name, tc.profile)
+		if err == nil {
+			t.Fatalf("profile %q: no error", tc.profile)
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(err.Error(), "\n  "+want) {
+				t.Errorf("profile %q: %q not in\n%s", tc.profile, want, err)
+			}
+		}
+	}
+}
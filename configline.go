@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// keyPathRe matches the key path a config error starts with, such as
+// collections[2].struct.
+var keyPathRe = regexp.MustCompile(`^([^\s:]+): `)
+
+// located prefixes each of the errors with the line of src, the config
+// source, that sets the key it is about, looked up first among the settings
+// of the profile if one was applied. Errors about keys src does not set,
+// such as missing required ones, are left as they are.
+func (e configErrors) located(src []byte, profile string) configErrors {
+	lines := yamlLines(strings.Split(string(src), "\n"))
+	located := make(configErrors, len(e))
+	for i, msg := range e {
+		located[i] = msg
+		m := keyPathRe.FindStringSubmatch(msg)
+		if m == nil {
+			continue
+		}
+		path := parseKeyPath(m[1])
+		line, found := -1, false
+		if profile != "" {
+			line, found = lines.find(append([]keySegment{{name: "profiles", index: -1}, {name: profile, index: -1}}, path...))
+		}
+		if !found {
+			line, _ = lines.find(path)
+		}
+		if line >= 0 {
+			located[i] = fmt.Sprintf("line %d: %s", line+1, msg)
+		}
+	}
+	return located
+}
+
+// keySegment is a key of a key path, or the index of a list item if index
+// is not negative.
+type keySegment struct {
+	name  string
+	index int
+}
+
+// parseKeyPath splits a key path such as collections[2].struct into its
+// segments. Brackets not holding an index hold a key, which may contain
+// dots, as in overrides[company.name].
+func parseKeyPath(path string) []keySegment {
+	var segs []keySegment
+	for path != "" {
+		var key string
+		switch i := strings.IndexAny(path, ".["); {
+		case i < 0:
+			key, path = path, ""
+		case path[i] == '.':
+			key, path = path[:i], path[i+1:]
+		default:
+			key, path = path[:i], path[i:]
+		}
+		if key != "" {
+			segs = append(segs, keySegment{name: key, index: -1})
+		}
+		for strings.HasPrefix(path, "[") {
+			end := strings.IndexByte(path, ']')
+			if end < 0 {
+				return segs
+			}
+			inner := path[1:end]
+			if n, err := strconv.Atoi(inner); err == nil {
+				segs = append(segs, keySegment{index: n})
+			} else {
+				segs = append(segs, keySegment{name: inner, index: -1})
+			}
+			path = strings.TrimPrefix(path[end+1:], ".")
+		}
+	}
+	return segs
+}
+
+// yamlLines are the lines of a YAML source in block style, enough to find
+// the line of a key.
+type yamlLines []string
+
+// indent returns the number of spaces line i is indented by, the column of
+// its key or value after any list item dashes, and whether it is a list
+// item. Blank and comment lines have an indent of -1.
+func (l yamlLines) indent(i int) (indent, col int, item bool) {
+	line := strings.TrimRight(l[i], " \t\r")
+	trimmed := strings.TrimLeft(line, " ")
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return -1, -1, false
+	}
+	indent = len(line) - len(trimmed)
+	col = indent
+	for trimmed == "-" || strings.HasPrefix(trimmed, "- ") {
+		item = true
+		rest := strings.TrimLeft(trimmed[1:], " ")
+		col += len(trimmed) - len(rest)
+		trimmed = rest
+	}
+	return indent, col, item
+}
+
+// key returns the key set on line i after column col, unquoted, or "".
+func (l yamlLines) key(i, col int) string {
+	text := strings.TrimRight(l[i], " \t\r")[col:]
+	if strings.HasPrefix(text, `"`) || strings.HasPrefix(text, "'") {
+		end := strings.IndexByte(text[1:], text[0])
+		if end < 0 {
+			return ""
+		}
+		return text[1 : end+1]
+	}
+	if i := strings.Index(text, ": "); i >= 0 {
+		return text[:i]
+	}
+	return strings.TrimSuffix(text, ":")
+}
+
+// end returns the line after the block of the key on line i at column col,
+// or of the list item whose dash is at column col if item is true.
+func (l yamlLines) end(i, col, hi int, item bool) int {
+	for i++; i < hi; i++ {
+		indent, _, it := l.indent(i)
+		if indent < 0 {
+			continue
+		}
+		// The items of a list can be indented as much as its key.
+		if indent < col || indent == col && (item || !it) {
+			return i
+		}
+	}
+	return hi
+}
+
+// find returns the line setting path, or the deepest of its parents that
+// was found and false, or -1 if none was.
+func (l yamlLines) find(path []keySegment) (int, bool) {
+	line, lo, hi := -1, 0, len(l)
+	for s, seg := range path {
+		first := lo
+		for first < hi {
+			if indent, _, _ := l.indent(first); indent >= 0 {
+				break
+			}
+			first++
+		}
+		if first == hi {
+			return line, false
+		}
+		found := -1
+		indent, col, item := l.indent(first)
+		if seg.index >= 0 {
+			if !item {
+				return line, false
+			}
+			n := 0
+			for i := first; i < hi; i++ {
+				if in, _, it := l.indent(i); in == indent && it {
+					if n == seg.index {
+						found = i
+						break
+					}
+					n++
+				}
+			}
+			if found < 0 {
+				return line, false
+			}
+			// The first key of the item is on the line of its dash.
+			line, lo, hi = found, found, l.end(found, indent, hi, true)
+			continue
+		}
+		names := []string{seg.name}
+		// Keys such as the field paths of field_names contain dots.
+		if rest := path[s:]; len(rest) > 1 {
+			var joined []string
+			for _, r := range rest {
+				if r.index >= 0 {
+					joined = nil
+					break
+				}
+				joined = append(joined, r.name)
+			}
+			if joined != nil {
+				names = append(names, strings.Join(joined, "."))
+			}
+		}
+		for _, name := range names {
+			for i := first; i < hi; i++ {
+				if _, c, _ := l.indent(i); c == col && l.key(i, c) == name {
+					found = i
+					break
+				}
+			}
+			if found >= 0 {
+				if name != seg.name {
+					return found, true
+				}
+				break
+			}
+		}
+		if found < 0 {
+			return line, false
+		}
+		line, lo, hi = found, found+1, l.end(found, col, hi, false)
+	}
+	return line, true
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// recordIDs records id, the _id of the document whose type is st, as the
+// example of the type of each of its fields, nested ones included. Merging
+// keeps the first example of each type, which locates the documents behind
+// a conflict.
+func recordIDs(st *StructType, id string, gen *Generator) {
+	for _, f := range st.Fields {
+		if f.Type != NilType {
+			f.TypeIDs = map[string]string{shortType(f.Type, gen): id}
+		}
+		if nested := nestedStruct(f.Type); nested != nil {
+			recordIDs(nested, id, gen)
+		}
+	}
+}
+
+// formatID formats the _id of a document for a comment.
+func formatID(id interface{}) string {
+	switch v := id.(type) {
+	case bson.ObjectId:
+		return v.Hex()
+	case string:
+		return strconv.Quote(v)
+	}
+	return fmt.Sprint(id)
+}
+
+// conflictIDs describes the types of a field that held more than one, each
+// with the _id of a document where it held it.
+func conflictIDs(f *Field) string {
+	var types []string
+	for t := range f.TypeIDs {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for i, t := range types {
+		types[i] = t + " in _id=" + f.TypeIDs[t]
+	}
+	return strings.Join(types, ", ")
+}
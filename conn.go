@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net"
+	"time"
+
+	"gopkg.in/mgo.v2"
+)
+
+// ConnectionConfig tunes the connections to the server for long runs.
+// SocketTimeout bounds each network operation, KeepAlive is the period of
+// TCP keepalives, PoolLimit caps the sockets per server and Heartbeat pings
+// the server at that interval, redialing when it fails.
+type ConnectionConfig struct {
+	SocketTimeout string `yaml:"socket_timeout"`
+	KeepAlive     string `yaml:"keepalive"`
+	PoolLimit     int    `yaml:"pool_limit"`
+	Heartbeat     string `yaml:"heartbeat"`
+}
+
+// dialer returns the dialer of the connections to the servers.
+func (c ConnectionConfig) dialer() *net.Dialer {
+	return &net.Dialer{Timeout: dialTimeout, KeepAlive: duration(c.KeepAlive)}
+}
+
+// apply sets the socket timeout of session, if configured.
+func (c ConnectionConfig) apply(session *mgo.Session) {
+	if d := duration(c.SocketTimeout); d > 0 {
+		session.SetSocketTimeout(d)
+	}
+}
+
+// redialer is implemented by sources whose connections can be dropped and
+// dialed again after a network error.
+type redialer interface {
+	redial()
+}
+
+// redial drops the sockets of the session of m, which its copies would
+// otherwise inherit broken, so that the next operations dial the servers
+// again. mgo keeps resyncing the cluster in the background.
+func (m *mongoSource) redial() {
+	m.session.Refresh()
+}
+
+// heartbeat pings the server every interval until m is closed, redialing
+// when a ping fails so that idle connections dropped by the network do not
+// fail the next scan.
+func (m *mongoSource) heartbeat(interval time.Duration) {
+	m.stop = make(chan struct{})
+	m.stopped = make(chan struct{})
+	go func() {
+		defer close(m.stopped)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-t.C:
+			}
+			if err := m.ping(); err != nil {
+				m.gen.logger().Warn("heartbeat failed, redialing", "error", err)
+				m.redial()
+			}
+		}
+	}()
+}
+
+func (m *mongoSource) ping() error {
+	session := m.session.Copy()
+	defer session.Close()
+	return session.Ping()
+}
+
+// stopHeartbeat stops the heartbeat of m, if any, and waits for its last
+// ping.
+func (m *mongoSource) stopHeartbeat() {
+	if m.stop != nil {
+		close(m.stop)
+		<-m.stopped
+		m.stop = nil
+	}
+}
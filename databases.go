@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+var errDatabases = errors.New("mongoschema: databases are only supported when generating")
+
+// Database overrides the settings of the config for one of several databases
+// generated in one run. Each database is generated as its own package.
+type Database struct {
+	DB string `yaml:"db"`
+	// URL or Cluster, one of the configured clusters, select the server of
+	// the database if it is not the one of the config.
+	URL     string `yaml:"url"`
+	Cluster string `yaml:"cluster"`
+	// Package defaults to the database name, and Output to the output of the
+	// config in a directory named after the package.
+	Package     string      `yaml:"package"`
+	Output      Output      `yaml:"output"`
+	Include     []string    `yaml:"include"`
+	Exclude     []string    `yaml:"exclude"`
+	Collections Collections `yaml:"collections"`
+}
+
+// generateDatabases generates every configured database in turn, until ctx
+// is done.
+func (s *Generator) generateDatabases(ctx context.Context) error {
+	if s.ImportSchema != "" {
+		return errors.New("mongoschema: import_schema is not supported with databases")
+	}
+	for _, d := range s.Databases {
+		if ctx.Err() != nil {
+			s.logger().Warn("run stopped, database left out", "db", d.DB)
+			continue
+		}
+		g := s.database(d)
+		err := g.generateDB(ctx)
+		s.scanned += g.scanned
+		if err != nil {
+			return fmt.Errorf("mongoschema: database %s: %s", d.DB, err)
+		}
+	}
+	return nil
+}
+
+// database returns a copy of the settings with the overrides of d.
+func (s *Generator) database(d Database) *Generator {
+	g := *s
+	g.Databases = nil
+	g.state = nil
+	g.scanned = 0
+	g.DB = d.DB
+	if d.URL != "" {
+		g.URL = d.URL
+	}
+	if d.Cluster != "" {
+		g.URL = s.Clusters[d.Cluster]
+	}
+	g.Package = d.Package
+	if g.Package == "" {
+		g.Package = packageName(d.DB)
+	}
+	g.Output = d.Output
+	if g.Output == (Output{}) && s.Output != (Output{}) {
+		g.Output = s.Output
+		g.Output.Dir = filepath.Join(s.Output.Dir, g.Package)
+	}
+	// The state file caches collections by name, and the snapshot and
+	// exported types hold them by name, so each database needs its own.
+	if s.State != "" {
+		ext := filepath.Ext(s.State)
+		g.State = strings.TrimSuffix(s.State, ext) + "." + d.DB + ext
+	}
+	if s.Snapshot != "" {
+		ext := filepath.Ext(s.Snapshot)
+		g.Snapshot = strings.TrimSuffix(s.Snapshot, ext) + "." + d.DB + ext
+	}
+	if s.ExportSchema != "" {
+		ext := filepath.Ext(s.ExportSchema)
+		g.ExportSchema = strings.TrimSuffix(s.ExportSchema, ext) + "." + d.DB + ext
+	}
+	if d.Include != nil {
+		g.Include = d.Include
+	}
+	if d.Exclude != nil {
+		g.Exclude = d.Exclude
+	}
+	g.Collections = d.Collections
+	return &g
+}
+
+// packageName derives a Go package name from the database name db, keeping
+// only its lower-cased letters and digits.
+func packageName(db string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(db) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) && b.Len() > 0 {
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() == 0 {
+		return "db"
+	}
+	return b.String()
+}
@@ -0,0 +1,84 @@
+package main
+
+import "fmt"
+
+// declWalker declares the structs of the output formats that have no
+// anonymous types, such as GraphQL: the root of each collection and its
+// named structs, each followed by the structs nested in it, which become
+// declarations of their own named after the struct and field embedding them.
+type declWalker struct {
+	names map[string]bool
+	// pending are the nested structs still to declare.
+	pending []NamedType
+}
+
+// declFunc declares the struct name with the fields of st, documented by
+// note if it is not empty.
+type declFunc func(name string, st *StructType, note string)
+
+// newDeclWalker returns a declWalker for schemas, which does not give nested
+// structs the names they declare, nor those reserved by the format.
+func newDeclWalker(schemas []Schema, reserved ...string) declWalker {
+	d := declWalker{names: map[string]bool{}}
+	for _, name := range reserved {
+		d.names[name] = true
+	}
+	for _, schema := range schemas {
+		d.names[schema.Collection.Struct] = true
+		for _, n := range schema.Decls {
+			d.names[n.Name] = true
+		}
+	}
+	return d
+}
+
+// walk declares the root of each of schemas, documented by the note of its
+// collection if notes is not nil, and the named structs among its Decls with
+// decl, and passes its other declarations, such as enums, to other if it is
+// not nil.
+func (d *declWalker) walk(schemas []Schema, notes func(Collection) string, decl declFunc, other func(NamedType)) {
+	for _, schema := range schemas {
+		var note string
+		if notes != nil {
+			note = notes(schema.Collection)
+		}
+		d.declare(schema.Collection.Struct, schema.Root, note, decl)
+		for _, n := range schema.Decls {
+			if st, ok := n.Type.(*StructType); ok {
+				d.declare(n.Name, st, "", decl)
+			} else if other != nil {
+				other(n)
+			}
+		}
+	}
+}
+
+// declare declares the struct name with decl, followed by the structs that
+// decl named with nested, depth first.
+func (d *declWalker) declare(name string, st *StructType, note string, decl declFunc) {
+	outer := d.pending
+	d.pending = nil
+	decl(name, st, note)
+	nested := d.pending
+	d.pending = outer
+	for _, n := range nested {
+		d.declare(n.Name, n.Type.(*StructType), "", decl)
+	}
+}
+
+// nested returns the name of the struct st nested in the struct being
+// declared, based on name, and declares it after that struct.
+func (d *declWalker) nested(name string, st *StructType) string {
+	name = d.unique(name)
+	d.pending = append(d.pending, NamedType{Name: name, Type: st})
+	return name
+}
+
+// unique returns name, or name with a number appended if it is taken.
+func (d *declWalker) unique(name string) string {
+	for base, i := name, 2; d.names[name]; i++ {
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+	d.names[name] = true
+	return name
+}
@@ -0,0 +1,40 @@
+package main
+
+// collapseDeep replaces the documents nested in root more than MaxDepth
+// levels deep, root being the first level, by maps of any value, and marks
+// the fields whose types were cut.
+func (s *Generator) collapseDeep(root *StructType) {
+	collapseFields(root, 1, s.MaxDepth)
+}
+
+func collapseFields(st *StructType, depth, max int) {
+	for _, f := range st.Fields {
+		if depth >= max {
+			if nestedStruct(f.Type) != nil {
+				f.Type = collapseType(f.Type)
+				f.collapsed = true
+			}
+			continue
+		}
+		if nested := nestedStruct(f.Type); nested != nil {
+			collapseFields(nested, depth+1, max)
+		}
+	}
+}
+
+// collapseType returns t with its documents replaced by maps.
+func collapseType(t Type) Type {
+	switch v := t.(type) {
+	case *StructType:
+		return MapType{Value: LiteralType{Literal: "interface{}"}}
+	case SliceType:
+		return SliceType{Type: collapseType(v.Type)}
+	case MixedType:
+		m := make(MixedType, len(v))
+		for i, e := range v {
+			m[i] = collapseType(e)
+		}
+		return m
+	}
+	return t
+}
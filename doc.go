@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// bsonNames maps primitive types to the aliases of their BSON types, as used
+// by $type.
+var bsonNames = map[PrimitiveType]string{
+	PrimitiveBinary:         "binData",
+	PrimitiveBool:           "bool",
+	PrimitiveDouble:         "double",
+	PrimitiveInt32:          "int",
+	PrimitiveInt64:          "long",
+	PrimitiveObjectId:       "objectId",
+	PrimitiveString:         "string",
+	PrimitiveTimestamp:      "date",
+	PrimitiveDBRef:          "object",
+	PrimitiveBytes:          "binData",
+	PrimitiveDecimal128:     "decimal",
+	PrimitiveRegEx:          "regex",
+	PrimitiveJavaScript:     "javascript",
+	PrimitiveDBPointer:      "dbPointer",
+	PrimitiveSymbol:         "symbol",
+	PrimitiveMongoTimestamp: "timestamp",
+	PrimitiveMinKey:         "minKey",
+	PrimitiveMaxKey:         "maxKey",
+	PrimitiveUUID:           "binData",
+}
+
+// bsonTypeNames appends the BSON types of the values of type t to names,
+// without repeating them. Unless the int policy preserves the width of
+// integers, their BSON type is not known.
+func (s *Generator) bsonTypeNames(t Type, names []string) []string {
+	add := func(name string) []string {
+		if sscontains(names, name) {
+			return names
+		}
+		return append(names, name)
+	}
+	switch v := t.(type) {
+	case PrimitiveType:
+		if (v == PrimitiveInt32 || v == PrimitiveInt64) && s.IntPolicy != IntPolicyPreserve {
+			return add("int or long")
+		}
+		return add(bsonNames[v])
+	case *StructType, MapType, GeoJSONType:
+		return add("object")
+	case SliceType, TupleType, LegacyPointType, legacyPointDecl:
+		return add("array")
+	case epochDecl:
+		return add("long")
+	case EnumType:
+		return add("string")
+	case NamedType:
+		return s.bsonTypeNames(v.Type, names)
+	case OverrideType:
+		return s.bsonTypeNames(v.Inferred, names)
+	case MixedType:
+		for _, e := range v {
+			names = s.bsonTypeNames(e, names)
+		}
+		return names
+	}
+	if t == NilType {
+		return add("null")
+	}
+	return names
+}
+
+// writeFieldDoc writes the doc comment of the field named k of st, which
+// lists the BSON types it held and how often it was present.
+func (s *Generator) writeFieldDoc(w io.Writer, st *StructType, k string) {
+	f := st.Fields[k]
+	names := s.bsonTypeNames(f.Type, nil)
+	if f.Nulls > 0 {
+		names = s.bsonTypeNames(NilType, names)
+	}
+	if len(names) == 0 {
+		names = []string{"unknown"}
+	}
+	if st.synthetic {
+		fmt.Fprintf(w, "// %s holds %s.\n", s.goFieldName(st, k), strings.Join(names, " or "))
+		return
+	}
+	fmt.Fprintf(w, "// %s holds %s, present in %s of the documents.\n",
+		s.goFieldName(st, k), strings.Join(names, " or "), st.presence(k))
+}
+
+// writeStructDoc writes the doc comment of the struct of a collection.
+func (s *Generator) writeStructDoc(w io.Writer, schema Schema) {
+	c := schema.Collection
+	what, options := s.describeCollection(c)
+	fmt.Fprintf(w, "// %s is %s", c.Struct, what)
+	if s.DB != "" {
+		fmt.Fprintf(w, " of the %s database", s.DB)
+	}
+	fmt.Fprintf(w, "%s,\n// inferred from %d documents at %s.\n",
+		options, schema.Root.Count, s.generated().Format(time.RFC3339))
+}
+
+// generated returns the time the output is generated at, which is the same
+// for every struct.
+func (s *Generator) generated() time.Time {
+	if s.genTime.IsZero() {
+		s.genTime = time.Now().UTC().Truncate(time.Second)
+	}
+	return s.genTime
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"math/bits"
+)
+
+// distribution summarizes non-negative integers, such as the sizes of the
+// documents of a collection, in a histogram of power-of-two buckets, which
+// bounds its memory whatever the number of values. Bucket i holds the values
+// from 2^(i-1) up to 2^i-1, and bucket 0 the zeros. The 95th percentile is
+// estimated as the largest value of the bucket it falls in.
+type distribution struct {
+	count, sum, min, max uint64
+	buckets              [65]uint64
+}
+
+func (d *distribution) add(v uint64) {
+	if d.count == 0 || v < d.min {
+		d.min = v
+	}
+	if v > d.max {
+		d.max = v
+	}
+	d.count++
+	d.sum += v
+	d.buckets[bits.Len64(v)]++
+}
+
+func (d *distribution) merge(o *distribution) {
+	if o.count == 0 {
+		return
+	}
+	if d.count == 0 || o.min < d.min {
+		d.min = o.min
+	}
+	if o.max > d.max {
+		d.max = o.max
+	}
+	d.count += o.count
+	d.sum += o.sum
+	for i, n := range o.buckets {
+		d.buckets[i] += n
+	}
+}
+
+// bucketRange returns the smallest and largest values of bucket i.
+func bucketRange(i int) (lo, hi uint64) {
+	if i == 0 {
+		return 0, 0
+	}
+	return 1 << (i - 1), 1<<i - 1
+}
+
+// percentile estimates the pth percentile, p being between 0 and 1.
+func (d *distribution) percentile(p float64) uint64 {
+	rank := uint64(p * float64(d.count))
+	var seen uint64
+	for i, n := range d.buckets {
+		if seen += n; n > 0 && seen >= rank {
+			if _, hi := bucketRange(i); hi < d.max {
+				return hi
+			}
+			return d.max
+		}
+	}
+	return d.max
+}
+
+type histogramBucket struct {
+	Min   uint64 `json:"min"`
+	Max   uint64 `json:"max"`
+	Count uint64 `json:"count"`
+}
+
+func (d *distribution) MarshalJSON() ([]byte, error) {
+	v := struct {
+		Min       uint64            `json:"min"`
+		Avg       float64           `json:"avg"`
+		P95       uint64            `json:"p95"`
+		Max       uint64            `json:"max"`
+		Histogram []histogramBucket `json:"histogram"`
+	}{Min: d.min, P95: d.percentile(0.95), Max: d.max, Histogram: []histogramBucket{}}
+	if d.count > 0 {
+		v.Avg = float64(d.sum) / float64(d.count)
+	}
+	for i, n := range d.buckets {
+		if n > 0 {
+			lo, hi := bucketRange(i)
+			v.Histogram = append(v.Histogram, histogramBucket{Min: lo, Max: hi, Count: n})
+		}
+	}
+	return json.Marshal(v)
+}
+
+// docStats are the distributions of the sizes, in bytes of BSON, and of the
+// numbers of fields, nested ones included, of the documents scanned.
+type docStats struct {
+	sizes  distribution
+	fields distribution
+}
+
+// add records a document of type t and size bytes, zero if not known, as
+// for the json source.
+func (d *docStats) add(t Type, size int) {
+	if d == nil {
+		return
+	}
+	if size > 0 {
+		d.sizes.add(uint64(size))
+	}
+	if st, ok := t.(*StructType); ok {
+		d.fields.add(uint64(countFields(st, func(*Field) bool { return true })))
+	}
+}
+
+// collectsDocStats reports whether the scans collect the statistics of the
+// documents, for the report of the run.
+func (s *Generator) collectsDocStats() bool {
+	return s.Report != "" && s.runReport != nil
+}
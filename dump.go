@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// archiveMagic starts every file written by mongodump --archive.
+const archiveMagic = 0x8199e26d
+
+// bsonTerminator ends a block in a mongodump archive.
+const bsonTerminator = 0xffffffff
+
+var errNoDump = errors.New("mongoschema: no dump specified")
+
+// dumpSource reads collections offline from the output of mongodump, which is
+// either a directory of .bson files or a single --archive file. Both may be
+// gzipped.
+type dumpSource struct {
+	gen     *Generator
+	archive bool
+}
+
+func newDumpSource(gen *Generator) (*dumpSource, error) {
+	if gen.Dump == "" {
+		return nil, errNoDump
+	}
+	fi, err := os.Stat(gen.Dump)
+	if err != nil {
+		return nil, err
+	}
+	return &dumpSource{gen: gen, archive: !fi.IsDir()}, nil
+}
+
+func (d *dumpSource) Open(c Collection) (Iter, error) {
+	if d.gen.sampling(c) != SamplingNatural {
+		return nil, fmt.Errorf("mongoschema: dumps only support natural sampling")
+	}
+	if c.Filter != nil {
+		return nil, fmt.Errorf("mongoschema: dumps do not support filters")
+	}
+	if len(c.Sort) > 0 || c.Projection != nil {
+		return nil, fmt.Errorf("mongoschema: dumps do not support sort or projection")
+	}
+	if ts := d.gen.timeSeries(c); ts != nil {
+		return d.openBuckets(c, ts)
+	}
+	if d.archive {
+		r, err := openMaybeGzip(d.gen.Dump)
+		if err != nil {
+			return nil, err
+		}
+		return newArchiveIter(r, d.gen.DB, c.Name)
+	}
+	for _, name := range []string{
+		filepath.Join(d.gen.Dump, d.gen.DB, c.Name+".bson"),
+		filepath.Join(d.gen.Dump, d.gen.DB, c.Name+".bson.gz"),
+		filepath.Join(d.gen.Dump, c.Name+".bson"),
+		filepath.Join(d.gen.Dump, c.Name+".bson.gz"),
+	} {
+		r, err := openMaybeGzip(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &bsonIter{r: r}, nil
+	}
+	return nil, fmt.Errorf("mongoschema: no dump found for collection %s in %s", c.Name, d.gen.Dump)
+}
+
+func (d *dumpSource) Close() {}
+
+// readCloser pairs a reader with the file it ultimately reads from.
+type readCloser struct {
+	*bufio.Reader
+	io.Closer
+}
+
+// openMaybeGzip opens the named file, transparently decompressing it if it
+// starts with the gzip magic number.
+func openMaybeGzip(name string) (io.ReadCloser, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(f)
+	if magic, err := r.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		z, err := gzip.NewReader(r)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return readCloser{bufio.NewReader(z), f}, nil
+	}
+	return readCloser{r, f}, nil
+}
+
+// readDoc reads the next BSON document from r. It returns nil at a block
+// terminator, and io.EOF at the end of input.
+func readDoc(r io.Reader) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.LittleEndian.Uint32(size[:])
+	if n == bsonTerminator {
+		return nil, nil
+	}
+	if n < 5 {
+		return nil, fmt.Errorf("mongoschema: invalid BSON document size %d", n)
+	}
+	doc := make([]byte, n)
+	copy(doc, size[:])
+	if _, err := io.ReadFull(r, doc[4:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return doc, nil
+}
+
+// bsonIter iterates over a .bson file, which is a plain sequence of documents.
+type bsonIter struct {
+	r   io.ReadCloser
+	err error
+}
+
+func (i *bsonIter) Next(result interface{}) bool {
+	if i.err != nil {
+		return false
+	}
+	doc, err := readDoc(i.r)
+	if err == nil && doc == nil {
+		err = errors.New("mongoschema: unexpected terminator in BSON file")
+	}
+	if err != nil {
+		if err != io.EOF {
+			i.err = err
+		}
+		return false
+	}
+	i.err = bson.Unmarshal(doc, result)
+	return i.err == nil
+}
+
+func (i *bsonIter) Close() error {
+	i.r.Close()
+	return i.err
+}
+
+// archiveIter iterates over the documents of one collection in a mongodump
+// archive. After the magic number, an archive is a sequence of blocks, each a
+// header document followed by body documents and a terminator. The first
+// block is the prelude; every other block holds documents of the namespace
+// named in its header.
+type archiveIter struct {
+	r        io.ReadCloser
+	db, name string
+	inBlock  bool
+	err      error
+}
+
+type archiveHeader struct {
+	DB         string `bson:"db"`
+	Collection string `bson:"collection"`
+	EOF        bool   `bson:"EOF"`
+}
+
+func newArchiveIter(r io.ReadCloser, db, name string) (*archiveIter, error) {
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		r.Close()
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(magic[:]) != archiveMagic {
+		r.Close()
+		return nil, errors.New("mongoschema: not a mongodump archive")
+	}
+	i := &archiveIter{r: r, db: db, name: name}
+	if err := i.skipBlock(); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return i, nil
+}
+
+// skipBlock discards the documents up to the next terminator.
+func (i *archiveIter) skipBlock() error {
+	for {
+		doc, err := readDoc(i.r)
+		if err != nil {
+			return err
+		}
+		if doc == nil {
+			return nil
+		}
+	}
+}
+
+func (i *archiveIter) Next(result interface{}) bool {
+	for i.err == nil {
+		doc, err := readDoc(i.r)
+		if err != nil {
+			if err != io.EOF {
+				i.err = err
+			}
+			return false
+		}
+		if doc == nil {
+			i.inBlock = false
+			continue
+		}
+		if i.inBlock {
+			i.err = bson.Unmarshal(doc, result)
+			return i.err == nil
+		}
+		var h archiveHeader
+		if i.err = bson.Unmarshal(doc, &h); i.err != nil {
+			return false
+		}
+		if !h.EOF && h.Collection == i.name && (i.db == "" || h.DB == i.db) {
+			i.inBlock = true
+			continue
+		}
+		i.err = i.skipBlock()
+	}
+	return false
+}
+
+func (i *archiveIter) Close() error {
+	i.r.Close()
+	return i.err
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// EnumType is a string type with a small, known set of values. It is
+// declared as a named string type with a constant for each value.
+type EnumType struct {
+	Values []string
+}
+
+func (e EnumType) GoType(gen *Generator) string {
+	return "string"
+}
+
+func (e EnumType) Merge(t Type, gen *Generator) Type {
+	if isNil(t) {
+		return e
+	}
+	return newMixedType(gen, e, t)
+}
+
+// enums replaces the string fields of schema that only held a few distinct
+// values with named enum types, and returns their declarations. Enums are
+// named after the path to their field, like nested structs.
+func (h *hoister) enums(schema Schema) []NamedType {
+	h.decls = nil
+	seen := map[*StructType]bool{}
+	h.enumFields(schema.Root, schema.Collection.Struct, seen)
+	for _, n := range schema.Decls {
+		if st, ok := n.Type.(*StructType); ok {
+			h.enumFields(st, n.Name, seen)
+		}
+	}
+	return h.decls
+}
+
+func (h *hoister) enumFields(s *StructType, name string, seen map[*StructType]bool) {
+	if seen[s] {
+		return
+	}
+	seen[s] = true
+	for _, k := range s.keys(h.gen) {
+		if !isValidFieldName(k) {
+			continue
+		}
+		f := s.Fields[k]
+		fieldName := name + h.gen.goFieldName(s, k)
+		if f.Type == PrimitiveString && isEnum(f) {
+			n := NamedType{Name: h.unique(fieldName), Type: enumType(f)}
+			h.decls = append(h.decls, n)
+			f.Type = n
+			continue
+		}
+		h.enumTypes(f.Type, fieldName, seen)
+	}
+}
+
+func (h *hoister) enumTypes(t Type, name string, seen map[*StructType]bool) {
+	switch v := t.(type) {
+	case *StructType:
+		h.enumFields(v, name, seen)
+	case SliceType:
+		h.enumTypes(v.Type, name, seen)
+	case MapType:
+		h.enumTypes(v.Value, name+"Value", seen)
+	case MixedType:
+		for _, e := range v {
+			h.enumTypes(e, name, seen)
+		}
+	}
+}
+
+// isEnum reports whether a field held few enough distinct values, each seen
+// more than once on average, to be an enum.
+func isEnum(f *Field) bool {
+	return len(f.Values) > 0 && f.Count > uint(len(f.Values))
+}
+
+// enumType returns the enum type of the values of the field f.
+func enumType(f *Field) EnumType {
+	var values []string
+	for v := range f.Values {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	return EnumType{Values: values}
+}
+
+// writeEnumConsts declares a constant for every value of the enum type name.
+func (s *Generator) writeEnumConsts(w io.Writer, name string, e EnumType) {
+	fmt.Fprintln(w, "const (")
+	names := map[string]bool{}
+	for _, v := range e.Values {
+		c := name + s.makeFieldName(v)
+		if v == "" || !isValidFieldName(v) {
+			c = name + "Value"
+		}
+		for base, i := c, 2; names[c]; i++ {
+			c = fmt.Sprintf("%s%d", base, i)
+		}
+		names[c] = true
+		fmt.Fprintf(w, "%s %s = %q\n", c, name, v)
+	}
+	fmt.Fprintln(w, ")")
+	fmt.Fprintln(w)
+}
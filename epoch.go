@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+)
+
+const (
+	// EpochTimesComment annotates the fields that hold Unix times with
+	// their unit and range.
+	EpochTimesComment = "comment"
+	// EpochTimesTime types the fields that hold Unix times as generated
+	// EpochSeconds or EpochMillis structs, which embed time.Time and are
+	// still stored as numbers.
+	EpochTimesTime = "time"
+)
+
+// The range of the Unix times recognized, from 2000 to 2100.
+var (
+	epochMin = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	epochMax = time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// epochNameRe matches the names of the fields that usually hold times. Numbers
+// in the range of Unix seconds are too common to be taken for times without
+// such a name, unlike those in the range of Unix milliseconds.
+var epochNameRe = regexp.MustCompile(`(?i)(time|date|_at$|At$|^ts$|_ts$|stamp|created|updated|modified|expires?|seen)`)
+
+// epochUnit returns the unit of the Unix times the field k holds, "seconds"
+// or "millis", or "" if it does not hold times: all its values are numbers
+// of the unit within the range of epochMin and epochMax.
+func epochUnit(k string, f *Field) string {
+	switch f.Type {
+	case PrimitiveInt32, PrimitiveInt64, PrimitiveDouble:
+	default:
+		return ""
+	}
+	if f.Min == nil || f.Max == nil {
+		return ""
+	}
+	in := func(unit float64) bool {
+		return *f.Min >= float64(epochMin.Unix())*unit && *f.Max < float64(epochMax.Unix())*unit
+	}
+	switch {
+	case in(1000) && f.Type != PrimitiveInt32:
+		return "millis"
+	case in(1) && epochNameRe.MatchString(k):
+		return "seconds"
+	}
+	return ""
+}
+
+// markEpochs records on the fields of st, and of its nested structs, the
+// unit of the Unix times they hold.
+func (s *Generator) markEpochs(st *StructType) {
+	for k, f := range st.Fields {
+		if f.epoch = epochUnit(k, f); f.epoch != "" {
+			continue
+		}
+		if nested := nestedStruct(f.Type); nested != nil {
+			s.markEpochs(nested)
+		}
+	}
+}
+
+// epochRange describes the unit and range of the Unix times of f.
+func epochRange(f *Field) string {
+	unit := float64(1)
+	if f.epoch == "millis" {
+		unit = 1000
+	}
+	date := func(v float64) string {
+		return time.Unix(int64(v/unit), 0).UTC().Format("2006-01-02")
+	}
+	return fmt.Sprintf("epoch %s, %s to %s", f.epoch, date(*f.Min), date(*f.Max))
+}
+
+// epochDecl is the type of a generated EpochSeconds or EpochMillis struct.
+type epochDecl struct {
+	Millis bool
+}
+
+func (t epochDecl) GoType(gen *Generator) string {
+	return "struct {\ntime.Time\n}"
+}
+
+func (t epochDecl) Merge(o Type, gen *Generator) Type {
+	return t
+}
+
+// epochs replaces the types of the fields of schema marked as holding Unix
+// times with the generated struct of their unit, and returns the
+// declarations of those not declared for an earlier schema.
+func (h *hoister) epochs(schema Schema) []NamedType {
+	h.decls = nil
+	seen := map[*StructType]bool{}
+	h.epochFields(schema.Root, seen)
+	for _, n := range schema.Decls {
+		if st, ok := n.Type.(*StructType); ok {
+			h.epochFields(st, seen)
+		}
+	}
+	return h.decls
+}
+
+func (h *hoister) epochFields(s *StructType, seen map[*StructType]bool) {
+	if seen[s] {
+		return
+	}
+	seen[s] = true
+	for _, f := range s.Fields {
+		if f.epoch != "" {
+			f.Type = h.epochType(f.epoch == "millis")
+			continue
+		}
+		// Named structs are among the declarations of the schema.
+		if nested := nestedStruct(f.Type); nested != nil {
+			h.epochFields(nested, seen)
+		}
+	}
+}
+
+// epochType returns the named struct of Unix times in seconds or millis,
+// declaring it the first time.
+func (h *hoister) epochType(millis bool) NamedType {
+	t := epochDecl{Millis: millis}
+	name, ok := h.epochNames[millis]
+	if !ok {
+		name = "EpochSeconds"
+		if millis {
+			name = "EpochMillis"
+		}
+		name = h.unique(name)
+		h.epochNames[millis] = name
+		h.decls = append(h.decls, NamedType{Name: name, Type: t})
+	}
+	return NamedType{Name: name, Type: t}
+}
+
+// writeEpochMethods writes the methods that store the generated struct as a
+// Unix time, in seconds or milliseconds.
+func writeEpochMethods(w io.Writer, name string, t epochDecl) {
+	unit, get, set := "seconds", "t.Unix()", "time.Unix(int64(n), 0)"
+	if t.Millis {
+		unit, get, set = "milliseconds", "t.UnixNano() / int64(time.Millisecond)", "time.Unix(0, int64(n)*int64(time.Millisecond))"
+	}
+	fmt.Fprintf(w, "// GetBSON stores t as a Unix time in %s.\n", unit)
+	fmt.Fprintf(w, "func (t %s) GetBSON() (interface{}, error) {\n", name)
+	fmt.Fprintf(w, "\treturn %s, nil\n}\n\n", get)
+	fmt.Fprintf(w, "// SetBSON loads t from a Unix time in %s.\n", unit)
+	fmt.Fprintf(w, "func (t *%s) SetBSON(raw bson.Raw) error {\n", name)
+	fmt.Fprintf(w, "\tvar n float64\n")
+	fmt.Fprintf(w, "\tif err := raw.Unmarshal(&n); err != nil {\n\t\treturn err\n\t}\n")
+	fmt.Fprintf(w, "\tt.Time = %s\n\treturn nil\n}\n\n", set)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// counter is implemented by sources that can count the documents of a
+// collection without reading them.
+type counter interface {
+	count(c Collection) (int, error)
+}
+
+// DryRun writes the collections that would be scanned, their document counts
+// and how they would be sampled, without scanning them.
+func (s *Generator) DryRun(ctx context.Context, w io.Writer) error {
+	if len(s.Databases) > 0 {
+		for _, d := range s.Databases {
+			fmt.Fprintf(w, "database %s:\n", d.DB)
+			if err := s.database(d).DryRun(ctx, w); err != nil {
+				return fmt.Errorf("mongoschema: database %s: %s", d.DB, err)
+			}
+			fmt.Fprintln(w)
+		}
+		return nil
+	}
+	src, err := s.source(ctx)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := s.resolveCollections(src); err != nil {
+		return err
+	}
+	if err := s.loadInfos(src); err != nil {
+		return err
+	}
+	if limits := s.limits(); limits != "" {
+		fmt.Fprintf(w, "reading %s\n", limits)
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "COLLECTION\tSTRUCT\tDOCUMENTS\tPLAN")
+	for _, c := range s.Collections {
+		docs := "?"
+		// The output of a pipeline is only counted by running it.
+		if cnt, ok := src.(counter); ok && c.Pipeline == nil {
+			n, err := cnt.count(c)
+			if err != nil {
+				return fmt.Errorf("mongoschema: counting %s: %s", c.Name, err)
+			}
+			docs = strconv.Itoa(n)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Name, c.Struct, docs, s.plan(c))
+	}
+	return tw.Flush()
+}
+
+// plan describes how c would be sampled.
+func (s *Generator) plan(c Collection) string {
+	var plan []string
+	limit := s.limit(c)
+	switch s.sampling(c) {
+	case SamplingRandom:
+		if limit == 0 {
+			limit = defaultSampleSize
+		}
+		plan = append(plan, fmt.Sprintf("random sample of %d", limit))
+	case SamplingSmart:
+		if limit == 0 {
+			limit = defaultSampleSize
+		}
+		plan = append(plan, fmt.Sprintf("oldest and newest %d and random sample of %d", limit/3, limit-2*(limit/3)))
+	default:
+		if limit == 0 {
+			plan = append(plan, "all documents")
+		} else {
+			plan = append(plan, fmt.Sprintf("first %d documents", limit))
+		}
+		if s.Retries > 0 && len(c.Sort) == 0 {
+			plan = append(plan, "resumable in _id order")
+		}
+	}
+	if len(c.Sort) > 0 {
+		plan = append(plan, "sorted by "+strings.Join(c.Sort, ", "))
+	}
+	if c.Pipeline != nil {
+		plan = append(plan, "output of a pipeline on "+c.from())
+	}
+	if len(c.Clusters) > 0 {
+		labels := make([]string, len(c.Clusters))
+		for i, entry := range c.Clusters {
+			labels[i] = clusterLabel(entry)
+		}
+		plan = append(plan, "on clusters "+strings.Join(labels, ", "))
+	}
+	if c.Filter != nil {
+		plan = append(plan, "filtered")
+	}
+	if c.Projection != nil {
+		plan = append(plan, "projected")
+	}
+	if c.Discriminator != "" {
+		plan = append(plan, "variants by "+c.Discriminator)
+	}
+	if c.Reference != nil {
+		plan = append(plan, "fields ordered like a reference document")
+	}
+	if s.Analysis == AnalysisServer {
+		plan = append(plan, "analyzed on the server")
+	} else if s.PerShard && c.Pipeline == nil {
+		plan = append(plan, "sampled per shard")
+	}
+	if n := s.partitions(c); n > 1 && s.Analysis != AnalysisServer && s.sampling(c) != SamplingSmart {
+		plan = append(plan, fmt.Sprintf("%d partitions", n))
+	}
+	if s.State != "" && s.sampling(c) == SamplingNatural {
+		plan = append(plan, "incremental")
+	}
+	if ts := s.timeSeries(c); ts != nil {
+		plan = append(plan, "time-series measurements")
+	} else if s.infos[c.Name].Options.Capped {
+		plan = append(plan, "capped")
+	}
+	if c.Timeout != "" {
+		plan = append(plan, "stopped after "+c.Timeout)
+	}
+	return strings.Join(plan, ", ")
+}
+
+func (m *mongoSource) count(c Collection) (int, error) {
+	filter, err := toBSON(c.Filter)
+	if err != nil {
+		return 0, err
+	}
+	session := m.session.Copy()
+	defer session.Close()
+	return session.DB(m.gen.DB).C(c.Name).Find(filter).SetMaxTime(m.gen.maxTime()).Count()
+}
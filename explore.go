@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// exploreExamples is the number of sample values kept per field while
+// exploring, if the config keeps none.
+const exploreExamples = 5
+
+const exploreHelp = `commands:
+  N         expand or collapse node N
+  v N       show the presence and sample values of field N
+  i N       ignore field N, or stop ignoring it
+  w FILE    write the config, with the ignored fields, to FILE and generate
+            the code as it configures
+  h         show this help
+  q         quit
+`
+
+// explorer browses the inferred types of the collections, one line per
+// collection and field, expanding nested documents on demand.
+type explorer struct {
+	gen      *Generator
+	roots    []*StructType
+	expanded map[string]bool
+	// nodes are the lines listed last, numbered from one.
+	nodes []exploreNode
+	out   io.Writer
+}
+
+// exploreNode is a listed collection, with a nil path, or field.
+type exploreNode struct {
+	coll   int
+	path   []string
+	parent *StructType
+}
+
+func (n exploreNode) key() string {
+	return strconv.Itoa(n.coll) + "/" + strings.Join(n.path, ".")
+}
+
+// Explore scans the collections and lets the user browse the inferred types
+// with the commands read from in, toggle the fields to ignore, and write the
+// resulting config and code.
+func (s *Generator) Explore(ctx context.Context, in io.Reader, out io.Writer) error {
+	if len(s.Databases) > 0 {
+		return errDatabases
+	}
+	run, cancel := s.withTimeout(ctx)
+	defer cancel()
+	src, err := s.source(run)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	examples := s.Examples
+	if examples == 0 {
+		s.Examples = exploreExamples
+	}
+	roots, err := s.scanRoots(run, src)
+	s.Examples = examples
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return interrupted(ctx)
+	}
+	e := &explorer{gen: s, roots: roots, expanded: map[string]bool{}, out: out}
+	e.list()
+	fmt.Fprint(out, "> ")
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		args := strings.Fields(scanner.Text())
+		if len(args) == 1 && (args[0] == "q" || args[0] == "quit") {
+			return nil
+		}
+		if err := e.run(args); err != nil {
+			fmt.Fprintln(out, err)
+		}
+		fmt.Fprint(out, "> ")
+	}
+	return scanner.Err()
+}
+
+// run runs a command.
+func (e *explorer) run(args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	switch args[0] {
+	case "h", "help":
+		fmt.Fprint(e.out, exploreHelp)
+		return nil
+	case "w":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: w FILE")
+		}
+		if err := e.gen.writeConfig(args[1]); err != nil {
+			return err
+		}
+		schemas, err := e.schemas()
+		if err != nil {
+			return err
+		}
+		return e.gen.write(schemas)
+	case "v", "i":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: %s N", args[0])
+		}
+		n, err := e.node(args[1])
+		if err != nil {
+			return err
+		}
+		if n.path == nil {
+			return fmt.Errorf("%s is a collection, not a field", args[1])
+		}
+		if args[0] == "v" {
+			e.show(n)
+			return nil
+		}
+		e.ignore(n)
+		e.list()
+		return nil
+	}
+	n, err := e.node(args[0])
+	if err != nil {
+		return fmt.Errorf("unknown command %q, h for help", args[0])
+	}
+	e.expanded[n.key()] = !e.expanded[n.key()]
+	e.list()
+	return nil
+}
+
+// node returns the listed node numbered arg.
+func (e *explorer) node(arg string) (exploreNode, error) {
+	i, err := strconv.Atoi(arg)
+	if err != nil || i < 1 || i > len(e.nodes) {
+		return exploreNode{}, fmt.Errorf("no node %s", arg)
+	}
+	return e.nodes[i-1], nil
+}
+
+// list numbers and prints the collections and the fields of the expanded
+// nodes.
+func (e *explorer) list() {
+	e.nodes = e.nodes[:0]
+	for i, c := range e.gen.Collections {
+		n := exploreNode{coll: i}
+		e.nodes = append(e.nodes, n)
+		fmt.Fprintf(e.out, "%3d %s %s (%s) %d documents\n", len(e.nodes), e.marker(n, e.roots[i]), c.Name, c.Struct, e.roots[i].Count)
+		if e.expanded[n.key()] {
+			e.fields(i, e.roots[i], nil, 1)
+		}
+	}
+}
+
+func (e *explorer) fields(coll int, st *StructType, prefix []string, depth int) {
+	c := e.gen.Collections[coll]
+	for _, k := range st.keys(e.gen) {
+		f := st.Fields[k]
+		n := exploreNode{coll: coll, path: append(prefix[:len(prefix):len(prefix)], k), parent: st}
+		e.nodes = append(e.nodes, n)
+		nested := nestedStruct(f.Type)
+		line := fmt.Sprintf("%3d %s%s %s %s %.1f%%", len(e.nodes), strings.Repeat("  ", depth), e.marker(n, nested),
+			k, shortType(f.Type, e.gen), 100*float64(f.Count)/float64(st.Count))
+		if ignored(n.path, c.IgnoredFields) {
+			line += " [ignored]"
+		}
+		fmt.Fprintln(e.out, line)
+		if nested != nil && e.expanded[n.key()] {
+			e.fields(coll, nested, n.path, depth+1)
+		}
+	}
+}
+
+// marker shows whether a node with the nested fields of st is expanded.
+func (e *explorer) marker(n exploreNode, st *StructType) string {
+	switch {
+	case st == nil:
+		return " "
+	case e.expanded[n.key()]:
+		return "-"
+	}
+	return "+"
+}
+
+// show prints the statistics of the field n.
+func (e *explorer) show(n exploreNode) {
+	k := n.path[len(n.path)-1]
+	f := n.parent.Fields[k]
+	fmt.Fprintf(e.out, "%s: %s\n", strings.Join(n.path, "."), shortType(f.Type, e.gen))
+	fmt.Fprintf(e.out, "  present in %s\n", n.parent.presence(k))
+	if f.Nulls > 0 {
+		fmt.Fprintf(e.out, "  null in %d\n", f.Nulls)
+	}
+	if len(f.Examples) > 0 {
+		fmt.Fprintf(e.out, "  e.g. %s\n", strings.Join(f.Examples, ", "))
+	}
+}
+
+// ignore adds the path of the field n to the ignored fields of its
+// collection, or removes it. A top-level field is ignored at any depth, like
+// every pattern without dots.
+func (e *explorer) ignore(n exploreNode) {
+	c := &e.gen.Collections[n.coll]
+	pattern := strings.Join(n.path, ".")
+	for i, p := range c.IgnoredFields {
+		if p == pattern {
+			c.IgnoredFields = append(c.IgnoredFields[:i], c.IgnoredFields[i+1:]...)
+			return
+		}
+	}
+	c.IgnoredFields = append(c.IgnoredFields, pattern)
+}
+
+// schemas transforms a copy of the scanned types.
+func (e *explorer) schemas() ([]Schema, error) {
+	roots, err := copyRoots(e.roots)
+	if err != nil {
+		return nil, err
+	}
+	return e.gen.transform(roots)
+}
+
+// nestedStruct returns the struct whose fields are nested in a field of type
+// t, if any.
+func nestedStruct(t Type) *StructType {
+	switch v := t.(type) {
+	case *StructType:
+		return v
+	case SliceType:
+		return nestedStruct(v.Type)
+	case MixedType:
+		for _, m := range v {
+			if st := nestedStruct(m); st != nil {
+				return st
+			}
+		}
+	}
+	return nil
+}
+
+// shortType returns the Go type of t, with nested structs left out.
+func shortType(t Type, gen *Generator) string {
+	switch v := t.(type) {
+	case *StructType:
+		return "struct"
+	case SliceType:
+		return "[]" + shortType(v.Type, gen)
+	}
+	return t.GoType(gen)
+}
+
+// writeConfig writes the config to the file name, leaving out the settings
+// that have their zero value.
+func (s *Generator) writeConfig(name string) error {
+	b, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+	var config yaml.MapSlice
+	if err := yaml.Unmarshal(b, &config); err != nil {
+		return err
+	}
+	v, _ := compactYAML(config)
+	if b, err = yaml.Marshal(v); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, b, 0644)
+}
+
+// compactYAML removes the zero values from v, and reports whether anything
+// is left. Filters and projections are query documents, whose zero values
+// matter, so they are kept whole.
+func compactYAML(v interface{}) (interface{}, bool) {
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		var m yaml.MapSlice
+		for _, item := range v {
+			if (item.Key == "filter" || item.Key == "projection") && item.Value != nil {
+				m = append(m, item)
+				continue
+			}
+			if value, ok := compactYAML(item.Value); ok {
+				m = append(m, yaml.MapItem{Key: item.Key, Value: value})
+			}
+		}
+		return m, len(m) > 0
+	case []interface{}:
+		var a []interface{}
+		for _, e := range v {
+			// Elements are kept, even if empty, to keep their positions.
+			e, _ = compactYAML(e)
+			a = append(a, e)
+		}
+		return a, len(a) > 0
+	case nil:
+		return nil, false
+	case string:
+		return v, v != ""
+	case bool:
+		return v, v
+	case int:
+		return v, v != 0
+	case float64:
+		return v, v != 0
+	}
+	return v, true
+}
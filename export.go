@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+var errNoExport = errors.New("mongoschema: no export specified")
+
+// exportExts are the file extensions tried for each collection, in order.
+var exportExts = []string{".json", ".ndjson", ".jsonl", ".json.gz", ".ndjson.gz", ".jsonl.gz"}
+
+// exportSource reads collections offline from the output of mongoexport: MongoDB
+// extended JSON, either one document per line or a single array. The export
+// is a directory of files named after the collections, or a single file that
+// holds the collection named by its base name. Files may be gzipped.
+type exportSource struct {
+	gen  *Generator
+	file bool
+}
+
+func newExportSource(gen *Generator) (*exportSource, error) {
+	if gen.Export == "" {
+		return nil, errNoExport
+	}
+	fi, err := os.Stat(gen.Export)
+	if err != nil {
+		return nil, err
+	}
+	return &exportSource{gen: gen, file: !fi.IsDir()}, nil
+}
+
+func (e *exportSource) Open(c Collection) (Iter, error) {
+	if e.gen.sampling(c) != SamplingNatural {
+		return nil, fmt.Errorf("mongoschema: exports only support natural sampling")
+	}
+	if c.Filter != nil {
+		return nil, fmt.Errorf("mongoschema: exports do not support filters")
+	}
+	if len(c.Sort) > 0 || c.Projection != nil {
+		return nil, fmt.Errorf("mongoschema: exports do not support sort or projection")
+	}
+	if e.file {
+		if exportName(e.gen.Export) != c.Name {
+			return nil, fmt.Errorf("mongoschema: export %s does not hold collection %s", e.gen.Export, c.Name)
+		}
+		return openExport(e.gen.Export)
+	}
+	for _, dir := range []string{filepath.Join(e.gen.Export, e.gen.DB), e.gen.Export} {
+		for _, ext := range exportExts {
+			iter, err := openExport(filepath.Join(dir, c.Name+ext))
+			if os.IsNotExist(err) {
+				continue
+			}
+			return iter, err
+		}
+	}
+	return nil, fmt.Errorf("mongoschema: no export found for collection %s in %s", c.Name, e.gen.Export)
+}
+
+func (e *exportSource) Close() {}
+
+// exportName returns the collection name of an export file.
+func exportName(file string) string {
+	name := filepath.Base(file)
+	for _, ext := range exportExts {
+		if strings.HasSuffix(name, ext) {
+			return strings.TrimSuffix(name, ext)
+		}
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+func openExport(name string) (*jsonIter, error) {
+	r, err := openMaybeGzip(name)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(r)
+	i := &jsonIter{r: r, dec: json.NewDecoder(br)}
+	// A --jsonArray export is a single array of documents; otherwise the
+	// documents simply follow each other.
+	if startsArray(br) {
+		if _, err := i.dec.Token(); err != nil {
+			r.Close()
+			return nil, err
+		}
+		i.array = true
+	}
+	return i, nil
+}
+
+// startsArray skips leading white space in r and reports whether what follows
+// opens an array.
+func startsArray(r *bufio.Reader) bool {
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return false
+		}
+		if !isSpace(c) {
+			r.UnreadByte()
+			return c == '['
+		}
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// jsonIter iterates over the extended JSON documents of an export file.
+type jsonIter struct {
+	r     io.ReadCloser
+	dec   *json.Decoder
+	array bool
+	err   error
+}
+
+func (i *jsonIter) Next(result interface{}) bool {
+	if i.err != nil || i.array && !i.dec.More() {
+		return false
+	}
+	var doc json.RawMessage
+	if err := i.dec.Decode(&doc); err != nil {
+		if err != io.EOF {
+			i.err = err
+		}
+		return false
+	}
+	i.err = bson.UnmarshalJSON(doc, result)
+	return i.err == nil
+}
+
+func (i *jsonIter) Close() error {
+	i.r.Close()
+	return i.err
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// FixtureSource is a Source of hand-written documents keyed by collection,
+// for checking the output generated from them without a server. Documents
+// are encoded to BSON and decoded again, so that their values have the types
+// they would have when read from a server, such as int32 for small ints.
+// Their fields are in no particular order, so field_order document is not
+// reproducible. Outside this package, the golden command runs the same
+// comparison from a file of fixtures.
+type FixtureSource map[string][]bson.M
+
+func (f FixtureSource) Open(c Collection) (Iter, error) {
+	docs, ok := f[c.Name]
+	if !ok {
+		return nil, fmt.Errorf("mongoschema: no fixture for collection %s", c.Name)
+	}
+	return &fixtureIter{docs: docs}, nil
+}
+
+func (f FixtureSource) Close() {}
+
+func (f FixtureSource) collectionNames() ([]string, error) {
+	var names []string
+	for name := range f {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+type fixtureIter struct {
+	docs []bson.M
+	err  error
+}
+
+func (i *fixtureIter) Next(result interface{}) bool {
+	if len(i.docs) == 0 || i.err != nil {
+		return false
+	}
+	raw, err := bson.Marshal(i.docs[0])
+	i.docs = i.docs[1:]
+	if err == nil {
+		err = bson.Unmarshal(raw, result)
+	}
+	if err != nil {
+		i.err = err
+		return false
+	}
+	return true
+}
+
+func (i *fixtureIter) Close() error {
+	return i.err
+}
+
+// LoadFixtures reads a FixtureSource from the file name, a JSON object
+// mapping each collection to an array of its documents in MongoDB extended
+// JSON.
+func LoadFixtures(name string) (FixtureSource, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var f FixtureSource
+	if err := bson.UnmarshalJSON(b, &f); err != nil {
+		return nil, fmt.Errorf("mongoschema: %s: %s", name, err)
+	}
+	return f, nil
+}
+
+// goldenTime is the generation time of golden output, which would otherwise
+// change the doc comments on every run.
+var goldenTime = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// goldenVersion is the version of mongoschema in the headers of golden
+// output, which would otherwise change with every release build.
+const goldenVersion = "golden"
+
+// Golden generates the output for the documents of src and compares it to
+// the golden file name, returning an error that shows the first line that
+// differs. With update, the golden file is written instead. The output is
+// that of a single file, whatever the configured layout.
+func (s *Generator) Golden(src Source, name string, update bool) error {
+	if len(s.Databases) > 0 {
+		return errDatabases
+	}
+	s.genTime = goldenTime
+	s.genVersion = goldenVersion
+	schemas, err := s.infer(context.Background(), src)
+	if err != nil {
+		return err
+	}
+	got, err := s.generate(schemas)
+	if err != nil {
+		return err
+	}
+	if update {
+		return ioutil.WriteFile(name, got, 0644)
+	}
+	want, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("mongoschema: golden file %s does not exist, run with update to create it", name)
+	}
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(got, want) {
+		return nil
+	}
+	return goldenDiff(name, got, want)
+}
+
+// goldenDiff describes the first line that differs between the output got
+// and the golden file name holding want.
+func goldenDiff(name string, got, want []byte) error {
+	gl, wl := strings.Split(string(got), "\n"), strings.Split(string(want), "\n")
+	i := 0
+	for i < len(gl) && i < len(wl) && gl[i] == wl[i] {
+		i++
+	}
+	line := func(lines []string) string {
+		if i >= len(lines) {
+			return "(end of file)"
+		}
+		return lines[i]
+	}
+	return fmt.Errorf("mongoschema: output differs from %s at line %d:\n  got:  %s\n  want: %s", name, i+1, line(gl), line(wl))
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "write the golden files instead of comparing the output to them")
+
+// TestGolden generates the output of each config in testdata from the
+// fixtures it names and compares it to its golden file.
+func TestGolden(t *testing.T) {
+	for _, tc := range []struct {
+		name, fixtures string
+	}{
+		{"company", "company"},
+		{"company_validator", "company"},
+		{"orders", "orders"},
+		{"orders_validator", "orders"},
+		{"places", "places"},
+		{"places_flat", "places"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g, err := loadConfig("testdata/"+tc.name+".yaml", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			g.Quiet = true
+			fixtures, err := LoadFixtures("testdata/" + tc.fixtures + ".json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := g.Golden(fixtures, "testdata/"+tc.name+".golden", *update); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	FlatCSV  = "csv"
+	FlatJSON = "json"
+)
+
+// flatField is a row of the flat format: a field by its dot-separated path,
+// through arrays of documents like MongoDB queries, with its type and the
+// number of documents, or array elements, it is present in out of those
+// holding its parent.
+type flatField struct {
+	Collection string  `json:"collection"`
+	Path       string  `json:"path"`
+	Type       string  `json:"type"`
+	Count      uint    `json:"count"`
+	Nulls      uint    `json:"nulls"`
+	Parent     uint    `json:"parent_count"`
+	Presence   float64 `json:"presence"`
+}
+
+// renderFlat writes every field of the collections as a row of CSV, or as
+// an object of a JSON array, with types named like the bsonType of
+// $jsonSchema.
+func (s *Generator) renderFlat(w io.Writer, schemas []Schema) error {
+	rows := []flatField{}
+	for _, schema := range schemas {
+		s.flatFields(schema.Root, schema.Collection.Name, "", &rows)
+	}
+	if s.FlatStyle == FlatJSON {
+		return writeJSON(w, rows)
+	}
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"collection", "path", "type", "count", "nulls", "parent_count", "presence"})
+	for _, r := range rows {
+		cw.Write([]string{
+			r.Collection, r.Path, r.Type,
+			strconv.FormatUint(uint64(r.Count), 10),
+			strconv.FormatUint(uint64(r.Nulls), 10),
+			strconv.FormatUint(uint64(r.Parent), 10),
+			strconv.FormatFloat(r.Presence, 'f', 1, 64),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func (s *Generator) flatFields(st *StructType, collection, prefix string, rows *[]flatField) {
+	for _, k := range st.keys(s) {
+		f := st.Fields[k]
+		row := flatField{Collection: collection, Path: prefix + k, Type: flatType(f.Type), Count: f.Count, Nulls: f.Nulls, Parent: st.Count}
+		if st.Count > 0 {
+			row.Presence = math.Round(1000*float64(f.Count)/float64(st.Count)) / 10
+		}
+		*rows = append(*rows, row)
+		if nested := flatStruct(f.Type); nested != nil {
+			s.flatFields(nested, collection, row.Path+".", rows)
+		}
+	}
+}
+
+// flatStruct returns the document whose fields are nested in a field of
+// type t, looking through arrays and named types.
+func flatStruct(t Type) *StructType {
+	switch v := t.(type) {
+	case NamedType:
+		return flatStruct(v.Type)
+	case SliceType:
+		return flatStruct(v.Type)
+	case MixedType:
+		for _, e := range v {
+			if st := flatStruct(e); st != nil {
+				return st
+			}
+		}
+	}
+	// The fields of a made up struct have no counts to list.
+	if st, ok := t.(*StructType); ok && !st.synthetic {
+		return st
+	}
+	return nil
+}
+
+// flatType names t like the bsonType of $jsonSchema, with the element type
+// of arrays in angle brackets and the members of mixed types joined by |.
+func flatType(t Type) string {
+	switch v := t.(type) {
+	case PrimitiveType:
+		switch name := bsonSchemaPrimitive(v)["bsonType"].(type) {
+		case string:
+			return name
+		case []string:
+			return name[0]
+		}
+	case *StructType, MapType, GeoJSONType:
+		return "object"
+	case SliceType:
+		return "array<" + flatType(v.Type) + ">"
+	case TupleType, LegacyPointType:
+		return "array"
+	case NamedType:
+		return flatType(v.Type)
+	case OverrideType:
+		return flatType(v.Inferred)
+	case EnumType:
+		return "string"
+	case MixedType:
+		var names []string
+		for _, e := range v {
+			if name := flatType(e); !sscontains(names, name) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		return strings.Join(names, "|")
+	}
+	if t == NilType {
+		return "null"
+	}
+	return "any"
+}
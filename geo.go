@@ -0,0 +1,142 @@
+package main
+
+import (
+	"gopkg.in/mgo.v2/bson"
+)
+
+// GeoJSONGenerated makes GeoJSON geometries use generated structs rather
+// than the types of an external package.
+const GeoJSONGenerated = "generated"
+
+// geoShapes maps the supported GeoJSON geometry types to the nesting depth of
+// their coordinates.
+var geoShapes = map[string]int{
+	"Point":      1,
+	"LineString": 2,
+	"Polygon":    3,
+}
+
+// GeoJSONType is a GeoJSON geometry of a single shape. Its Go type is the
+// type named after the shape in the package configured by the geojson
+// setting, like geojson.Point. Generated geometries are replaced with named
+// structs like GeoPoint before rendering.
+type GeoJSONType struct {
+	Shape string
+}
+
+func (t GeoJSONType) GoType(gen *Generator) string {
+	goType, _ := qualifiedType(gen.GeoJSON + "." + t.Shape)
+	return goType
+}
+
+func (t GeoJSONType) Merge(o Type, gen *Generator) Type {
+	if isNil(o) || o == t {
+		return t
+	}
+	return newMixedType(gen, t, o)
+}
+
+// Struct returns the struct a geometry of the shape is decoded into.
+func (t GeoJSONType) Struct() *StructType {
+	var coords Type = PrimitiveDouble
+	for i := 0; i < geoShapes[t.Shape]; i++ {
+		coords = SliceType{Type: coords}
+	}
+	return &StructType{
+		Count:     1,
+		synthetic: true,
+		Fields: map[string]*Field{
+			"type":        {Type: PrimitiveString, Count: 1},
+			"coordinates": {Type: coords, Count: 1},
+			"bbox":        {Type: SliceType{Type: PrimitiveDouble}},
+		},
+	}
+}
+
+// geoJSONShape returns the shape of m if it is a GeoJSON geometry of a
+// supported shape, which has a type, coordinates nested as deep as the shape
+// requires and optionally a bbox.
+func geoJSONShape(m bson.M) (string, bool) {
+	shape, _ := m["type"].(string)
+	depth, ok := geoShapes[shape]
+	if !ok {
+		return "", false
+	}
+	for k := range m {
+		if k != "type" && k != "coordinates" && k != "bbox" {
+			return "", false
+		}
+	}
+	v := m["coordinates"]
+	for i := 0; i < depth; i++ {
+		a, ok := v.([]interface{})
+		if !ok {
+			return "", false
+		}
+		if len(a) == 0 {
+			return shape, true
+		}
+		v = a[0]
+	}
+	switch v.(type) {
+	case float64, int, int32, int64:
+		return shape, true
+	}
+	return "", false
+}
+
+// geoJSON replaces the GeoJSON geometries of schema with named structs, if
+// they are generated, and returns the declarations of the structs that were
+// not declared for an earlier schema.
+func (h *hoister) geoJSON(schema Schema) []NamedType {
+	h.decls = nil
+	if h.gen.GeoJSON != GeoJSONGenerated {
+		return nil
+	}
+	seen := map[*StructType]bool{}
+	h.geoFields(schema.Root, seen)
+	for _, n := range schema.Decls {
+		if st, ok := n.Type.(*StructType); ok {
+			h.geoFields(st, seen)
+		}
+	}
+	return h.decls
+}
+
+func (h *hoister) geoFields(s *StructType, seen map[*StructType]bool) {
+	if seen[s] {
+		return
+	}
+	seen[s] = true
+	for _, f := range s.Fields {
+		f.Type = h.geoType(f.Type, seen)
+	}
+}
+
+func (h *hoister) geoType(t Type, seen map[*StructType]bool) Type {
+	switch v := t.(type) {
+	case GeoJSONType:
+		name, ok := h.geoTypes[v.Shape]
+		if !ok {
+			name = h.unique("Geo" + v.Shape)
+			h.geoTypes[v.Shape] = name
+			h.decls = append(h.decls, NamedType{Name: name, Type: v.Struct()})
+		}
+		return NamedType{Name: name, Type: v.Struct()}
+	case *StructType:
+		h.geoFields(v, seen)
+	case NamedType:
+		if st, ok := v.Type.(*StructType); ok {
+			h.geoFields(st, seen)
+		}
+	case SliceType:
+		return SliceType{Type: h.geoType(v.Type, seen)}
+	case MapType:
+		return MapType{Value: h.geoType(v.Value, seen)}
+	case MixedType:
+		for i, e := range v {
+			v[i] = h.geoType(e, seen)
+		}
+	}
+	return t
+}
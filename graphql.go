@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var graphqlNameRe = regexp.MustCompile(`^[_A-Za-z][_0-9A-Za-z]*$`)
+
+// graphqlScalars describes the custom scalars the types may use, which are
+// declared only if they are.
+var graphqlScalars = map[string]string{
+	"ObjectId": "A BSON ObjectId as a hexadecimal string.",
+	"DateTime": "A BSON date as an RFC 3339 string.",
+	"Long":     "A 64-bit integer, which does not fit Int.",
+	"JSON":     "Any JSON value, for mixed types, maps and DBRefs.",
+}
+
+// graphqlWriter renders GraphQL object types. GraphQL has no anonymous
+// types, so nested structs become types of their own named after the type
+// and field embedding them, declared after it.
+type graphqlWriter struct {
+	declWalker
+	gen     *Generator
+	buf     bytes.Buffer
+	scalars map[string]bool
+	// enums are the named enums whose values are all valid GraphQL names.
+	enums map[string]bool
+}
+
+// renderGraphQL writes a GraphQL SDL object type for every collection and
+// named struct, and an enum for every named enum. A field is non-null if it
+// was present and not null in every document.
+func (s *Generator) renderGraphQL(w io.Writer, schemas []Schema) error {
+	var scalarNames []string
+	for name := range graphqlScalars {
+		scalarNames = append(scalarNames, name)
+	}
+	g := &graphqlWriter{declWalker: newDeclWalker(schemas, scalarNames...), gen: s, scalars: map[string]bool{}, enums: map[string]bool{}}
+	for _, schema := range schemas {
+		for _, n := range schema.Decls {
+			if e, ok := n.Type.(EnumType); ok && graphqlEnum(e) {
+				g.enums[n.Name] = true
+			}
+		}
+	}
+	object := func(name string, st *StructType, _ string) { g.object(name, st) }
+	g.walk(schemas, nil, object, func(n NamedType) {
+		if e, ok := n.Type.(EnumType); ok && g.enums[n.Name] {
+			g.enum(n.Name, e)
+		}
+	})
+
+	var scalars []string
+	for name := range g.scalars {
+		scalars = append(scalars, name)
+	}
+	sort.Strings(scalars)
+	for _, name := range scalars {
+		fmt.Fprintf(w, "\"%s\"\nscalar %s\n\n", graphqlScalars[name], name)
+	}
+	_, err := w.Write(g.buf.Bytes())
+	return err
+}
+
+// object declares the type name with the fields of st.
+func (g *graphqlWriter) object(name string, st *StructType) {
+	fmt.Fprintf(&g.buf, "type %s {\n", name)
+	used := map[string]bool{}
+	for _, k := range st.keys(g.gen) {
+		f := st.Fields[k]
+		fieldName := graphqlFieldName(k, used)
+		typ := g.fieldType(f.Type, name+g.gen.makeFieldName(k))
+		if st.required(k) && f.Nulls == 0 {
+			typ += "!"
+		}
+		if g.gen.PresenceComments && !st.synthetic {
+			fmt.Fprintf(&g.buf, "  \"present in %s\"\n", st.presence(k))
+		}
+		fmt.Fprintf(&g.buf, "  %s: %s\n", fieldName, typ)
+	}
+	fmt.Fprint(&g.buf, "}\n\n")
+}
+
+func (g *graphqlWriter) enum(name string, e EnumType) {
+	fmt.Fprintf(&g.buf, "enum %s {\n", name)
+	for _, v := range e.Values {
+		fmt.Fprintf(&g.buf, "  %s\n", v)
+	}
+	fmt.Fprint(&g.buf, "}\n\n")
+}
+
+// fieldType returns the nullable GraphQL type of t, naming the type of a
+// nested struct name.
+func (g *graphqlWriter) fieldType(t Type, name string) string {
+	switch v := t.(type) {
+	case *StructType:
+		return g.nested(name, v)
+	case SliceType:
+		return g.list(v.Type, name)
+	case TupleType:
+		if e, ok := v.uniform(g.gen); ok {
+			return g.list(e, name)
+		}
+		return g.list(nil, name)
+	case NamedType:
+		switch v.Type.(type) {
+		case *StructType:
+			return v.Name
+		case EnumType:
+			if g.enums[v.Name] {
+				return v.Name
+			}
+			return "String"
+		}
+		return g.fieldType(v.Type, name)
+	case OverrideType:
+		return g.fieldType(v.Inferred, name)
+	case GeoJSONType:
+		return g.fieldType(v.Struct(), name)
+	case LegacyPointType:
+		return g.list(PrimitiveDouble, name)
+	case EnumType:
+		return "String"
+	case PrimitiveType:
+		return g.primitive(v)
+	}
+	return g.scalar("JSON")
+}
+
+// list returns a list of elem, whose elements are non-null unless their type
+// is unknown.
+func (g *graphqlWriter) list(elem Type, name string) string {
+	if isNil(elem) {
+		return "[" + g.scalar("JSON") + "]"
+	}
+	typ := g.fieldType(elem, name)
+	if typ == "JSON" {
+		return "[JSON]"
+	}
+	return "[" + typ + "!]"
+}
+
+func (g *graphqlWriter) primitive(p PrimitiveType) string {
+	switch p {
+	case PrimitiveBool:
+		return "Boolean"
+	case PrimitiveDouble:
+		return "Float"
+	case PrimitiveInt32:
+		return "Int"
+	case PrimitiveInt64, PrimitiveMongoTimestamp:
+		return g.scalar("Long")
+	case PrimitiveObjectId:
+		return g.scalar("ObjectId")
+	case PrimitiveTimestamp:
+		return g.scalar("DateTime")
+	case PrimitiveString, PrimitiveBinary, PrimitiveBytes, PrimitiveUUID, PrimitiveDecimal128,
+		PrimitiveRegEx, PrimitiveJavaScript, PrimitiveSymbol:
+		return "String"
+	}
+	return g.scalar("JSON")
+}
+
+// scalar records that the custom scalar name is used.
+func (g *graphqlWriter) scalar(name string) string {
+	g.scalars[name] = true
+	return name
+}
+
+// graphqlFieldName returns the GraphQL name of the key k, camel-casing keys
+// that are not valid names, and records it in used.
+func graphqlFieldName(k string, used map[string]bool) string {
+	name := k
+	if !graphqlNameRe.MatchString(name) || strings.HasPrefix(name, "__") {
+		name = convertCase(k, CaseCamel)
+		if name == "" || name[0] >= '0' && name[0] <= '9' {
+			name = "f" + name
+		}
+	}
+	for base, i := name, 2; used[name]; i++ {
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+	used[name] = true
+	return name
+}
+
+// graphqlEnum reports whether the values of e are valid GraphQL enum values.
+func graphqlEnum(e EnumType) bool {
+	for _, v := range e.Values {
+		if !graphqlNameRe.MatchString(v) || v == "true" || v == "false" || v == "null" {
+			return false
+		}
+	}
+	return len(e.Values) > 0
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// version is the version of mongoschema written in the header of generated
+// files, set when building a release with -ldflags "-X main.version=v1.2".
+var version = "dev"
+
+var errStale = errors.New("mongoschema: generated files are stale")
+
+// hashLabel starts the value of the schema hash in the header.
+const hashLabel = "Schema hash: "
+
+// commentPrefix returns the line comment of the output format, or "" if it
+// has none to write a header in.
+func (s *Generator) commentPrefix() string {
+	switch s.Format {
+	case "", FormatGo, FormatTypeScript, FormatProtobuf, FormatMongoose, FormatKotlin, FormatJava, FormatRust:
+		return "//"
+	case FormatGraphQL, FormatPython, FormatOpenAPI:
+		return "#"
+	case FormatSQL:
+		return "--"
+	}
+	return ""
+}
+
+// writeHeader writes the header of a file generated from schemas: the
+// version of mongoschema, the collections and the time they were read at,
+// in the form Go tools recognize as generated code, and with HeaderHash the
+// hash of their schemas.
+func (s *Generator) writeHeader(w io.Writer, schemas []Schema) {
+	prefix := s.commentPrefix()
+	sources := make([]string, len(schemas))
+	for i, schema := range schemas {
+		sources[i] = schema.Collection.Name
+		if s.DB != "" {
+			sources[i] = s.DB + "/" + sources[i]
+		}
+	}
+	fmt.Fprintf(w, "%s Code generated by mongoschema %s from %s at %s. DO NOT EDIT.\n",
+		prefix, s.version(), strings.Join(sources, ", "), s.generated().Format("2006-01-02T15:04:05Z"))
+	if s.HeaderHash {
+		fmt.Fprintf(w, "%s %s%s\n", prefix, hashLabel, s.schemaHash(schemas))
+	}
+	fmt.Fprintln(w)
+}
+
+// version returns the version of mongoschema written in headers.
+func (s *Generator) version() string {
+	if s.genVersion != "" {
+		return s.genVersion
+	}
+	return version
+}
+
+// schemaHash hashes the field paths and types of the schemas, which are
+// left unchanged by statistics that differ between runs, such as counts.
+func (s *Generator) schemaHash(schemas []Schema) string {
+	var rows []flatField
+	for _, schema := range schemas {
+		s.flatFields(schema.Root, schema.Collection.Name, "", &rows)
+	}
+	lines := make([]string, len(rows))
+	for i, r := range rows {
+		lines[i] = r.Collection + "\t" + r.Path + "\t" + r.Type + "\n"
+	}
+	sort.Strings(lines)
+	h := sha256.New()
+	for _, l := range lines {
+		io.WriteString(h, l)
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil))
+}
+
+// headerHash returns the schema hash in the header of the generated file
+// src, or "" if it has none.
+func headerHash(src []byte) string {
+	sc := bufio.NewScanner(bytes.NewReader(src))
+	for i := 0; i < 3 && sc.Scan(); i++ {
+		if j := strings.Index(sc.Text(), hashLabel); j >= 0 {
+			return strings.TrimSpace(sc.Text()[j+len(hashLabel):])
+		}
+	}
+	return ""
+}
+
+// CheckSink compares the schema hash of each generated file to that of the
+// file already written, in place of writing it, and records the files that
+// are stale: missing, without a hash or generated from another schema.
+type CheckSink struct {
+	Stale []string
+}
+
+func (c *CheckSink) WriteFile(name string, contents []byte) error {
+	if name == "" {
+		return fmt.Errorf("mongoschema: check needs output files to compare")
+	}
+	want := headerHash(contents)
+	have, err := ioutil.ReadFile(name)
+	switch {
+	case os.IsNotExist(err):
+		c.Stale = append(c.Stale, name+": missing")
+	case err != nil:
+		return err
+	case headerHash(have) == "":
+		c.Stale = append(c.Stale, name+": no schema hash")
+	case headerHash(have) != want:
+		c.Stale = append(c.Stale, fmt.Sprintf("%s: schema hash %s, now %s", name, headerHash(have), want))
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// timestampNameRe matches the names of the fields that usually hold the time
+// their document was created or last changed.
+var timestampNameRe = regexp.MustCompile(`(?i)^(created|updated|modified|inserted|added)[_-]?(at|on|date|time)?$`)
+
+// fieldDefault is a field set by a generated constructor to the Go
+// expression value, which refers to either the new ObjectId id or the
+// current time now.
+type fieldDefault struct {
+	name  string
+	value string
+	id    bool
+}
+
+// defaults returns the fields of st a constructor sets: an ObjectId _id to a
+// new ObjectId, and the times of creation and change to the current time.
+func (s *Generator) defaults(st *StructType) []fieldDefault {
+	var defaults []fieldDefault
+	for _, k := range st.keys(s) {
+		if !isValidFieldName(k) || st.Fields[k].Type == NilType {
+			continue
+		}
+		goType, _ := s.fieldType(st, k)
+		d := fieldDefault{name: s.goFieldName(st, k)}
+		switch {
+		case k == "_id" && strings.TrimPrefix(goType, "*") == "bson.ObjectId":
+			d.value, d.id = "id", true
+		case timestampNameRe.MatchString(k) && strings.TrimPrefix(goType, "*") == "time.Time":
+			d.value = "now"
+		case timestampNameRe.MatchString(k) && goType == "sql.NullTime":
+			d.value = "sql.NullTime{Time: now, Valid: true}"
+		default:
+			continue
+		}
+		if strings.HasPrefix(goType, "*") {
+			d.value = "&" + d.value
+		}
+		defaults = append(defaults, d)
+	}
+	return defaults
+}
+
+// baseDefaults returns the fields of the base struct embedded in st that a
+// constructor sets, if any.
+func (s *Generator) baseDefaults(st *StructType) []fieldDefault {
+	if st.embed == nil {
+		return nil
+	}
+	return s.defaults(st.embed.Type.(*StructType))
+}
+
+// writeConstructor writes the NewX function of the struct of a collection,
+// which returns a document with the fields of its defaults set, including
+// those of its embedded base struct.
+func (s *Generator) writeConstructor(w io.Writer, name string, st *StructType) {
+	defaults, base := s.defaults(st), s.baseDefaults(st)
+	var id, now bool
+	for _, d := range append(defaults, base...) {
+		id = id || d.id
+		now = now || !d.id
+	}
+	var set []string
+	if id {
+		set = append(set, "a new _id")
+	}
+	if now {
+		set = append(set, "the current time")
+	}
+	fmt.Fprintf(w, "// New%s returns a new %s", name, name)
+	if len(set) > 0 {
+		fmt.Fprintf(w, " with %s", strings.Join(set, " and "))
+	}
+	fmt.Fprintf(w, ".\nfunc New%s() *%s {\n", name, name)
+	if id {
+		fmt.Fprintln(w, "id := bson.NewObjectId()")
+	}
+	if now {
+		fmt.Fprintln(w, "now := time.Now()")
+	}
+	// Each pointer to the current time gets its own copy, so that changing
+	// one field does not change the others.
+	for _, ds := range [][]fieldDefault{defaults, base} {
+		for i, d := range ds {
+			if d.value == "&now" {
+				local := convertCase(d.name, CaseCamel)
+				fmt.Fprintf(w, "%s := now\n", local)
+				ds[i].value = "&" + local
+			}
+		}
+	}
+	if len(defaults) == 0 && len(base) == 0 {
+		fmt.Fprintf(w, "return &%s{}\n}\n\n", name)
+		return
+	}
+	fmt.Fprintf(w, "return &%s{\n", name)
+	if len(base) > 0 {
+		fmt.Fprintf(w, "%s: %s{\n", st.embed.Name, st.embed.Name)
+		for _, d := range base {
+			fmt.Fprintf(w, "%s: %s,\n", d.name, d.value)
+		}
+		fmt.Fprint(w, "},\n")
+	}
+	for _, d := range defaults {
+		fmt.Fprintf(w, "%s: %s,\n", d.name, d.value)
+	}
+	fmt.Fprint(w, "}\n}\n\n")
+}
+
+// writeIsZero writes the IsZero method of the struct named name, with which
+// the bson package omits an empty struct from a field tagged omitempty.
+func writeIsZero(w io.Writer, name string) {
+	fmt.Fprintf(w, "// IsZero reports whether v has no field set.\n")
+	fmt.Fprintf(w, "func (v %s) IsZero() bool {\n\treturn reflect.ValueOf(v).IsZero()\n}\n\n", name)
+}
+
+// helperImports returns the packages used by the helpers of schemas.
+func (s *Generator) helperImports(schemas []Schema) []string {
+	paths := []string{"reflect"}
+	var id, now bool
+	for _, schema := range schemas {
+		for _, d := range append(s.defaults(schema.Root), s.baseDefaults(schema.Root)...) {
+			id = id || d.id
+			now = now || !d.id
+		}
+	}
+	if id {
+		paths = append(paths, "gopkg.in/mgo.v2/bson")
+	}
+	if now {
+		paths = append(paths, "time")
+	}
+	return paths
+}
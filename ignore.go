@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// prune removes the fields of root that match the ignored fields of the
+// config or of the collection c. A pattern without dots matches a key at
+// any depth. A dotted pattern matches the path of a field from the root, with
+// each segment matched as a glob, so *.legacy_* matches the legacy fields of
+// every top-level document. Array elements do not add a segment, as in
+// MongoDB's dot notation.
+func (s *Generator) prune(root *StructType, c Collection) {
+	patterns := append(append([]string(nil), s.IgnoredFields...), c.IgnoredFields...)
+	if len(patterns) > 0 {
+		pruneFields(root, nil, patterns)
+	}
+}
+
+func pruneFields(s *StructType, prefix, patterns []string) {
+	for k, f := range s.Fields {
+		p := append(prefix[:len(prefix):len(prefix)], k)
+		if ignored(p, patterns) {
+			delete(s.Fields, k)
+			continue
+		}
+		pruneType(f.Type, p, patterns)
+	}
+}
+
+func pruneType(t Type, p, patterns []string) {
+	switch v := t.(type) {
+	case *StructType:
+		pruneFields(v, p, patterns)
+	case SliceType:
+		pruneType(v.Type, p, patterns)
+	case MixedType:
+		for _, e := range v {
+			pruneType(e, p, patterns)
+		}
+	}
+}
+
+// ignored reports whether the field at path p matches any of patterns.
+func ignored(p, patterns []string) bool {
+	for _, pattern := range patterns {
+		segments := strings.Split(pattern, ".")
+		if len(segments) == 1 {
+			if ok, _ := path.Match(pattern, p[len(p)-1]); ok {
+				return true
+			}
+			continue
+		}
+		if len(segments) != len(p) {
+			continue
+		}
+		match := true
+		for i, segment := range segments {
+			if ok, _ := path.Match(segment, p[i]); !ok {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// minPresence parses MinPresence, which is either a number of documents or a
+// percentage like 0.01%.
+func (s *Generator) minPresence() (count uint64, percent float64, err error) {
+	v := strings.TrimSpace(s.MinPresence)
+	if strings.HasSuffix(v, "%") {
+		percent, err = strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64)
+		if err == nil && (percent < 0 || percent > 100) {
+			err = fmt.Errorf("percentage %s out of range", v)
+		}
+		return 0, percent, err
+	}
+	count, err = strconv.ParseUint(v, 10, 64)
+	return count, 0, err
+}
+
+// dropRare removes the fields of root, and of the documents nested in it,
+// present in fewer documents than MinPresence, recording them on the struct
+// they were removed from. The _id field is always kept.
+func (s *Generator) dropRare(root *StructType) {
+	count, percent, err := s.minPresence()
+	if err != nil || count == 0 && percent == 0 {
+		return
+	}
+	rare := func(f *Field, st *StructType) bool {
+		if percent > 0 {
+			return float64(f.Count)*100 < percent*float64(st.Count)
+		}
+		return uint64(f.Count) < count
+	}
+	dropRareFields(root, rare)
+}
+
+func dropRareFields(st *StructType, rare func(*Field, *StructType) bool) {
+	for k, f := range st.Fields {
+		if k != "_id" && rare(f, st) {
+			st.rare = append(st.rare, fmt.Sprintf("%s (%d/%d)", k, f.Count, st.Count))
+			delete(st.Fields, k)
+			continue
+		}
+		dropRareType(f.Type, rare)
+	}
+	sort.Strings(st.rare)
+}
+
+func dropRareType(t Type, rare func(*Field, *StructType) bool) {
+	switch v := t.(type) {
+	case *StructType:
+		dropRareFields(v, rare)
+	case SliceType:
+		dropRareType(v.Type, rare)
+	case MixedType:
+		for _, e := range v {
+			dropRareType(e, rare)
+		}
+	}
+}
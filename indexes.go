@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+)
+
+// indexes returns the indexes of each of cs.
+func (m *mongoSource) indexes(cs []Collection) (map[string][]mgo.Index, error) {
+	session := m.session.Copy()
+	defer session.Close()
+	indexes := map[string][]mgo.Index{}
+	for _, c := range cs {
+		list, err := session.DB(m.gen.DB).C(c.Name).Indexes()
+		if err != nil {
+			return nil, fmt.Errorf("mongoschema: listing the indexes of %s: %s", c.Name, err)
+		}
+		indexes[c.Name] = list
+	}
+	return indexes, nil
+}
+
+// needsIndexes reports whether the config uses the indexes of the
+// collections.
+func (s *Generator) needsIndexes() bool {
+	return s.LegacyCoords != "" || s.IndexComments || s.IndexMethods
+}
+
+// loadIndexes reads the indexes of the collections, if the config uses them
+// and src has them.
+func (s *Generator) loadIndexes(src Source) error {
+	if !s.needsIndexes() {
+		return nil
+	}
+	m, ok := src.(*mongoSource)
+	if !ok {
+		if s.IndexComments || s.IndexMethods {
+			s.logger().Warn("source has no indexes to annotate the types with")
+		}
+		return nil
+	}
+	// Views and the output of pipelines have no indexes of their own.
+	var cs []Collection
+	for _, c := range s.Collections {
+		if c.Pipeline == nil && !s.isView(c) {
+			cs = append(cs, c)
+		}
+	}
+	indexes, err := m.indexes(cs)
+	if err != nil {
+		return err
+	}
+	s.indexes = indexes
+	return nil
+}
+
+// indexKey splits a key of an mgo.Index, like -created or $2dsphere:loc, into
+// its field and its kind, which is empty for an ascending or descending key.
+func indexKey(key string) (field, kind string) {
+	if strings.HasPrefix(key, "$") {
+		if i := strings.Index(key, ":"); i > 0 {
+			return key[i+1:], key[1:i]
+		}
+	}
+	return strings.TrimLeft(key, "+-"), ""
+}
+
+// describeIndex describes the index as seen from its key at position i.
+func describeIndex(index mgo.Index, i int) string {
+	var attrs []string
+	if _, kind := indexKey(index.Key[i]); kind != "" {
+		attrs = append(attrs, kind)
+	}
+	if index.Unique {
+		attrs = append(attrs, "unique")
+	}
+	if index.Sparse {
+		attrs = append(attrs, "sparse")
+	}
+	if index.ExpireAfter > 0 {
+		attrs = append(attrs, "ttl "+index.ExpireAfter.String())
+	}
+	if len(index.Key) > 1 {
+		attrs = append(attrs, fmt.Sprintf("key %d of %d", i+1, len(index.Key)))
+	}
+	if len(attrs) == 0 {
+		return "index " + index.Name
+	}
+	return "index " + index.Name + " (" + strings.Join(attrs, ", ") + ")"
+}
+
+// annotateIndexes records on the fields of root the indexes of c that
+// include them, to be described in comments.
+func (s *Generator) annotateIndexes(root *StructType, c Collection) {
+	for _, index := range s.indexes[c.Name] {
+		if index.Name == "_id_" {
+			continue
+		}
+		for i, key := range index.Key {
+			field, _ := indexKey(key)
+			if f := lookupField(root, strings.Split(field, ".")); f != nil {
+				f.Indexes = append(f.Indexes, describeIndex(index, i))
+			}
+		}
+	}
+}
+
+// lookupField returns the field at path in st, looking through slices of
+// nested documents, or nil if there is none.
+func lookupField(st *StructType, path []string) *Field {
+	f, ok := st.Fields[path[0]]
+	if !ok {
+		return nil
+	}
+	if len(path) == 1 {
+		return f
+	}
+	if nested := nestedStruct(f.Type); nested != nil {
+		return lookupField(nested, path[1:])
+	}
+	return nil
+}
+
+// writeIndexes writes an Indexes method returning the indexes of the
+// collection other than that of _id, which can be passed to EnsureIndex.
+func (s *Generator) writeIndexes(w io.Writer, name string, c Collection) {
+	fmt.Fprintf(w, "// Indexes returns the indexes of the %s collection.\n", c.Name)
+	fmt.Fprintf(w, "func (%s) Indexes() []mgo.Index {\n\treturn []mgo.Index{\n", name)
+	for _, index := range s.indexes[c.Name] {
+		if index.Name == "_id_" {
+			continue
+		}
+		fmt.Fprintf(w, "{Name: %q, Key: %#v", index.Name, index.Key)
+		if index.Unique {
+			fmt.Fprint(w, ", Unique: true")
+		}
+		if index.Sparse {
+			fmt.Fprint(w, ", Sparse: true")
+		}
+		if index.ExpireAfter > 0 {
+			fmt.Fprintf(w, ", ExpireAfter: %d * time.Second", index.ExpireAfter/time.Second)
+		}
+		fmt.Fprint(w, "},\n")
+	}
+	fmt.Fprint(w, "}\n}\n\n")
+}
+
+// expires reports whether any of the collections of schemas has a TTL index,
+// whose Indexes method needs time.
+func (s *Generator) expires(schemas []Schema) bool {
+	for _, schema := range schemas {
+		for _, index := range s.indexes[schema.Collection.Name] {
+			if index.ExpireAfter > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const jsonSchemaDraft07 = "http://json-schema.org/draft-07/schema#"
+
+// renderJSONSchema writes one draft-07 JSON Schema document per collection.
+func (s *Generator) renderJSONSchema(w io.Writer, schemas []Schema) error {
+	b := schemaBuilder{gen: s}
+	for _, schema := range schemas {
+		doc := b.schema(schema.Root)
+		doc["$schema"] = jsonSchemaDraft07
+		doc["title"] = schema.Collection.Struct
+		if note := s.collectionNote(schema.Collection); note != "" {
+			doc["description"] = note
+		}
+		if len(schema.Decls) > 0 {
+			defs := map[string]interface{}{}
+			for _, n := range schema.Decls {
+				defs[n.Name] = b.schema(n.Type)
+			}
+			doc["definitions"] = defs
+		}
+		if err := writeJSON(w, doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderValidator writes one MongoDB $jsonSchema validator per collection.
+func (s *Generator) renderValidator(w io.Writer, schemas []Schema) error {
+	for _, schema := range schemas {
+		if err := writeJSON(w, s.validator(schema)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tracksNulls reports whether null values are recorded in the fields: with
+// NullFields, and for validators, which must accept the nulls the
+// collection already holds. Without NullFields, only the validators see
+// them, and dropNulls removes them from the other outputs.
+func (s *Generator) tracksNulls() bool {
+	return s.NullFields || s.buildsValidators()
+}
+
+// dropNulls removes the nulls recorded in st, its variants and the
+// documents nested in it, as if they had not been tracked: fields count as
+// missing where they were null, and those that were always null are
+// removed.
+func dropNulls(st *StructType) {
+	for k, f := range st.Fields {
+		f.Count -= f.Nulls
+		f.Nulls = 0
+		if f.Count == 0 {
+			delete(st.Fields, k)
+			continue
+		}
+		dropNullsType(f.Type)
+	}
+	for _, v := range st.variants {
+		dropNulls(v)
+	}
+}
+
+func dropNullsType(t Type) {
+	switch v := t.(type) {
+	case *StructType:
+		dropNulls(v)
+	case SliceType:
+		dropNullsType(v.Type)
+	case MixedType:
+		for _, e := range v {
+			dropNullsType(e)
+		}
+	}
+}
+
+// buildsValidators reports whether validators are built from the schemas.
+func (s *Generator) buildsValidators() bool {
+	return s.Format == FormatValidator || s.ApplyValidator
+}
+
+// validator returns the $jsonSchema validator of schema. Every document is
+// checked against it, so it is built from the full root, with the base
+// fields and those of every variant.
+func (s *Generator) validator(schema Schema) map[string]interface{} {
+	b := schemaBuilder{gen: s, bson: true}
+	doc := b.schema(schema.full)
+	doc["title"] = schema.Collection.Struct
+	return map[string]interface{}{"$jsonSchema": doc}
+}
+
+// applyValidators installs the inferred validator on each collection via
+// collMod.
+func (s *Generator) applyValidators(session *mgo.Session, schemas []Schema) error {
+	for _, schema := range schemas {
+		// Time-series collections do not support validators.
+		if s.timeSeries(schema.Collection) != nil {
+			s.logger().Warn("skipping validator of time-series collection", "collection", schema.Collection.Name)
+			continue
+		}
+		cmd := bson.D{
+			{Name: "collMod", Value: schema.Collection.Name},
+			{Name: "validator", Value: s.validator(schema)},
+		}
+		if s.ValidationLevel != "" {
+			cmd = append(cmd, bson.DocElem{Name: "validationLevel", Value: s.ValidationLevel})
+		}
+		if s.ValidationAction != "" {
+			cmd = append(cmd, bson.DocElem{Name: "validationAction", Value: s.ValidationAction})
+		}
+		if err := session.DB(s.DB).Run(cmd, nil); err != nil {
+			return fmt.Errorf("mongoschema: applying validator to %s: %s", schema.Collection.Name, err)
+		}
+	}
+	return nil
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// schemaBuilder converts a Type tree to JSON Schema. With bson set it emits
+// the dialect accepted by MongoDB's $jsonSchema operator, which uses bsonType
+// and does not support $ref. With openapi set it emits OpenAPI 3.0 schemas,
+// which mark fields that may be missing as nullable.
+type schemaBuilder struct {
+	gen     *Generator
+	bson    bool
+	openapi bool
+}
+
+func (b schemaBuilder) schema(t Type) map[string]interface{} {
+	switch v := t.(type) {
+	case *StructType:
+		props := map[string]interface{}{}
+		var required []string
+		for _, k := range v.keys(b.gen) {
+			t := v.Fields[k].Type
+			// The full root of a validator has no named enums, so its
+			// enum fields are still strings.
+			if b.bson && t == PrimitiveString && b.gen.EnumThreshold > 0 && isEnum(v.Fields[k]) {
+				t = enumType(v.Fields[k])
+			}
+			props[k] = b.schema(t)
+			if b.bson && v.Fields[k].Nulls > 0 {
+				props[k] = b.orNull(props[k].(map[string]interface{}))
+			}
+			if b.openapi && !v.required(k) {
+				props[k] = nullable(props[k].(map[string]interface{}))
+			}
+			if b.gen.InferOptional && v.required(k) {
+				required = append(required, k)
+			}
+		}
+		schema := map[string]interface{}{b.typeKey(): "object", "properties": props}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	case SliceType:
+		if isNil(v.Type) {
+			return map[string]interface{}{b.typeKey(): "array"}
+		}
+		return map[string]interface{}{b.typeKey(): "array", "items": b.schema(v.Type)}
+	case TupleType:
+		if b.openapi {
+			// OpenAPI 3.0 has no positional items.
+			return map[string]interface{}{
+				"type":     "array",
+				"items":    b.schema(newMixedType(b.gen, v.Types...)),
+				"minItems": len(v.Types),
+				"maxItems": len(v.Types),
+			}
+		}
+		items := make([]interface{}, len(v.Types))
+		for i, e := range v.Types {
+			items[i] = b.schema(e)
+		}
+		schema := map[string]interface{}{b.typeKey(): "array", "items": items}
+		if !b.bson {
+			// A validator must keep accepting the arrays of other lengths
+			// that later documents may hold.
+			schema["minItems"] = len(items)
+			schema["maxItems"] = len(items)
+		}
+		return schema
+	case MapType:
+		return map[string]interface{}{b.typeKey(): "object", "additionalProperties": b.schema(v.Value)}
+	case MixedType:
+		var anyOf []interface{}
+		for _, e := range v {
+			anyOf = append(anyOf, b.schema(e))
+		}
+		return map[string]interface{}{"anyOf": anyOf}
+	case NamedType:
+		if b.bson {
+			return b.schema(v.Type)
+		}
+		if b.openapi {
+			return map[string]interface{}{"$ref": "#/components/schemas/" + v.Name}
+		}
+		return map[string]interface{}{"$ref": "#/definitions/" + v.Name}
+	case EnumType:
+		return map[string]interface{}{b.typeKey(): "string", "enum": v.Values}
+	case OverrideType:
+		return b.schema(v.Inferred)
+	case GeoJSONType:
+		return b.schema(v.Struct())
+	case LegacyPointType:
+		return b.schema(v.Tuple())
+	case PrimitiveType:
+		switch {
+		case b.bson:
+			return b.bsonPrimitive(v)
+		case b.openapi:
+			return openAPIPrimitive(v)
+		}
+		return jsonSchemaPrimitive(v)
+	}
+	if t == NilType && b.openapi {
+		return map[string]interface{}{"nullable": true}
+	}
+	if t == NilType {
+		return map[string]interface{}{b.typeKey(): "null"}
+	}
+	return map[string]interface{}{}
+}
+
+// orNull makes schema, that of a field which was null in some documents,
+// accept null too.
+func (b schemaBuilder) orNull(schema map[string]interface{}) map[string]interface{} {
+	key := b.typeKey()
+	switch t := schema[key].(type) {
+	case string:
+		if t != "null" {
+			schema[key] = []string{t, "null"}
+		}
+	case []string:
+		if !sscontains(t, "null") {
+			schema[key] = append(t[:len(t):len(t)], "null")
+		}
+	default:
+		return map[string]interface{}{"anyOf": []interface{}{schema, map[string]interface{}{key: "null"}}}
+	}
+	if values, ok := schema["enum"].([]string); ok {
+		enum := make([]interface{}, 0, len(values)+1)
+		for _, v := range values {
+			enum = append(enum, v)
+		}
+		schema["enum"] = append(enum, nil)
+	}
+	return schema
+}
+
+func (b schemaBuilder) typeKey() string {
+	if b.bson {
+		return "bsonType"
+	}
+	return "type"
+}
+
+func jsonSchemaPrimitive(p PrimitiveType) map[string]interface{} {
+	switch p {
+	case PrimitiveBinary:
+		return map[string]interface{}{"type": "string", "contentEncoding": "base64", "format": "binary"}
+	case PrimitiveBool:
+		return map[string]interface{}{"type": "boolean"}
+	case PrimitiveDouble:
+		return map[string]interface{}{"type": "number", "format": "double"}
+	case PrimitiveInt32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case PrimitiveInt64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case PrimitiveObjectId:
+		return map[string]interface{}{"type": "string", "format": "objectid", "pattern": "^[0-9a-fA-F]{24}$"}
+	case PrimitiveString:
+		return map[string]interface{}{"type": "string"}
+	case PrimitiveTimestamp:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case PrimitiveDBRef:
+		return map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"$ref": map[string]interface{}{"type": "string"},
+				"$id":  map[string]interface{}{},
+				"$db":  map[string]interface{}{"type": "string"},
+			},
+			"required": []string{"$ref", "$id"},
+		}
+	case PrimitiveBytes:
+		return map[string]interface{}{"type": "string", "contentEncoding": "base64"}
+	case PrimitiveUUID:
+		return map[string]interface{}{"type": "string", "format": "uuid"}
+	case PrimitiveDecimal128:
+		return map[string]interface{}{"type": "string", "format": "decimal128"}
+	case PrimitiveRegEx:
+		return map[string]interface{}{"type": "string", "format": "regex"}
+	case PrimitiveJavaScript:
+		return map[string]interface{}{"type": "string", "format": "javascript"}
+	case PrimitiveDBPointer:
+		return map[string]interface{}{"type": "object", "format": "dbpointer"}
+	case PrimitiveSymbol:
+		return map[string]interface{}{"type": "string"}
+	case PrimitiveMongoTimestamp:
+		return map[string]interface{}{"type": "integer", "format": "bson-timestamp"}
+	}
+	return map[string]interface{}{}
+}
+
+// bsonPrimitive returns the $jsonSchema of p for a validator, which must
+// accept the documents the collection already holds. Unless IntPolicy
+// preserves it, the BSON width of integers is not known, and doubles may
+// have been widened from integers, so those accept every width they can be
+// stored as.
+func (b schemaBuilder) bsonPrimitive(p PrimitiveType) map[string]interface{} {
+	switch p {
+	case PrimitiveInt32:
+		if b.gen.IntPolicy == IntPolicyPreserve {
+			break
+		}
+		fallthrough
+	case PrimitiveInt64:
+		return map[string]interface{}{"bsonType": []string{"int", "long"}}
+	case PrimitiveDouble:
+		return map[string]interface{}{"bsonType": []string{"int", "long", "double"}}
+	}
+	return bsonSchemaPrimitive(p)
+}
+
+func bsonSchemaPrimitive(p PrimitiveType) map[string]interface{} {
+	switch p {
+	case PrimitiveBinary:
+		return map[string]interface{}{"bsonType": "binData"}
+	case PrimitiveBool:
+		return map[string]interface{}{"bsonType": "bool"}
+	case PrimitiveDouble:
+		return map[string]interface{}{"bsonType": "double"}
+	case PrimitiveInt32:
+		return map[string]interface{}{"bsonType": "int"}
+	case PrimitiveInt64:
+		return map[string]interface{}{"bsonType": "long"}
+	case PrimitiveObjectId:
+		return map[string]interface{}{"bsonType": "objectId"}
+	case PrimitiveString:
+		return map[string]interface{}{"bsonType": "string"}
+	case PrimitiveTimestamp:
+		return map[string]interface{}{"bsonType": "date"}
+	case PrimitiveDBRef:
+		return map[string]interface{}{
+			"bsonType": "object",
+			"required": []string{"$ref", "$id"},
+		}
+	case PrimitiveBytes, PrimitiveUUID:
+		return map[string]interface{}{"bsonType": "binData"}
+	case PrimitiveDecimal128:
+		return map[string]interface{}{"bsonType": "decimal"}
+	case PrimitiveRegEx:
+		return map[string]interface{}{"bsonType": "regex"}
+	case PrimitiveJavaScript:
+		return map[string]interface{}{"bsonType": []string{"javascript", "javascriptWithScope"}}
+	case PrimitiveDBPointer:
+		return map[string]interface{}{"bsonType": "dbPointer"}
+	case PrimitiveSymbol:
+		return map[string]interface{}{"bsonType": "symbol"}
+	case PrimitiveMongoTimestamp:
+		return map[string]interface{}{"bsonType": "timestamp"}
+	case PrimitiveMinKey:
+		return map[string]interface{}{"bsonType": "minKey"}
+	case PrimitiveMaxKey:
+		return map[string]interface{}{"bsonType": "maxKey"}
+	}
+	return map[string]interface{}{}
+}
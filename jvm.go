@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// defaultJavaClass is the class the java format nests its classes in, as a
+// Java file can declare only one public top-level class.
+const defaultJavaClass = "Models"
+
+var jvmPackageRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+// jvmPrimitive is the JVM type of a primitive: its Kotlin type, its Java
+// type when not null, its boxed Java type and the class to import, if any.
+type jvmPrimitive struct {
+	kotlin, java, boxed, imp string
+}
+
+var jvmPrimitives = map[PrimitiveType]jvmPrimitive{
+	PrimitiveBool:           {"Boolean", "boolean", "Boolean", ""},
+	PrimitiveDouble:         {"Double", "double", "Double", ""},
+	PrimitiveInt32:          {"Int", "int", "Integer", ""},
+	PrimitiveInt64:          {"Long", "long", "Long", ""},
+	PrimitiveObjectId:       {"ObjectId", "ObjectId", "ObjectId", "org.bson.types.ObjectId"},
+	PrimitiveString:         {"String", "String", "String", ""},
+	PrimitiveJavaScript:     {"String", "String", "String", ""},
+	PrimitiveSymbol:         {"String", "String", "String", ""},
+	PrimitiveTimestamp:      {"Instant", "Instant", "Instant", "java.time.Instant"},
+	PrimitiveDecimal128:     {"BigDecimal", "BigDecimal", "BigDecimal", "java.math.BigDecimal"},
+	PrimitiveBinary:         {"ByteArray", "byte[]", "byte[]", ""},
+	PrimitiveBytes:          {"ByteArray", "byte[]", "byte[]", ""},
+	PrimitiveUUID:           {"UUID", "UUID", "UUID", "java.util.UUID"},
+	PrimitiveRegEx:          {"BsonRegularExpression", "BsonRegularExpression", "BsonRegularExpression", "org.bson.BsonRegularExpression"},
+	PrimitiveMongoTimestamp: {"BsonTimestamp", "BsonTimestamp", "BsonTimestamp", "org.bson.BsonTimestamp"},
+	PrimitiveDBRef:          {"DBRef", "DBRef", "DBRef", "com.mongodb.DBRef"},
+}
+
+var kotlinKeywords = map[string]bool{
+	"as": true, "break": true, "class": true, "continue": true, "do": true, "else": true,
+	"false": true, "for": true, "fun": true, "if": true, "in": true, "interface": true,
+	"is": true, "null": true, "object": true, "package": true, "return": true, "super": true,
+	"this": true, "throw": true, "true": true, "try": true, "typealias": true, "typeof": true,
+	"val": true, "var": true, "when": true, "while": true,
+}
+
+var javaKeywords = map[string]bool{
+	"abstract": true, "assert": true, "boolean": true, "break": true, "byte": true, "case": true,
+	"catch": true, "char": true, "class": true, "const": true, "continue": true, "default": true,
+	"do": true, "double": true, "else": true, "enum": true, "extends": true, "false": true,
+	"final": true, "finally": true, "float": true, "for": true, "goto": true, "if": true,
+	"implements": true, "import": true, "instanceof": true, "int": true, "interface": true,
+	"long": true, "native": true, "new": true, "null": true, "package": true, "private": true,
+	"protected": true, "public": true, "return": true, "short": true, "static": true,
+	"strictfp": true, "super": true, "switch": true, "synchronized": true, "this": true,
+	"throw": true, "throws": true, "transient": true, "true": true, "try": true, "void": true,
+	"volatile": true, "while": true,
+}
+
+// jvmWriter renders Kotlin data classes or Java classes, annotated for both
+// the MongoDB driver's codecs and Jackson. Like GraphQL, they have no
+// anonymous types, so nested structs become classes of their own named
+// after the class and field embedding them, declared after it.
+type jvmWriter struct {
+	declWalker
+	gen     *Generator
+	kotlin  bool
+	buf     bytes.Buffer
+	imports map[string]bool
+}
+
+// renderKotlin writes a data class for every collection and named struct.
+func (s *Generator) renderKotlin(w io.Writer, schemas []Schema) error {
+	return s.renderJVM(w, schemas, true)
+}
+
+// renderJava writes a class with public fields for every collection and
+// named struct, nested in the class JavaClass.
+func (s *Generator) renderJava(w io.Writer, schemas []Schema) error {
+	return s.renderJVM(w, schemas, false)
+}
+
+func (s *Generator) renderJVM(w io.Writer, schemas []Schema, kotlin bool) error {
+	j := &jvmWriter{declWalker: newDeclWalker(schemas), gen: s, kotlin: kotlin, imports: map[string]bool{}}
+	j.walk(schemas, s.collectionNote, j.class, nil)
+
+	end := ";"
+	if kotlin {
+		end = ""
+	}
+	if s.JVMPackage != "" {
+		fmt.Fprintf(w, "package %s%s\n\n", s.JVMPackage, end)
+	}
+	var imports []string
+	for imp := range j.imports {
+		imports = append(imports, imp)
+	}
+	sort.Strings(imports)
+	for _, imp := range imports {
+		fmt.Fprintf(w, "import %s%s\n", imp, end)
+	}
+	if len(imports) > 0 {
+		fmt.Fprintln(w)
+	}
+	body := strings.TrimRight(j.buf.String(), "\n")
+	if kotlin {
+		_, err := fmt.Fprintln(w, body)
+		return err
+	}
+	class := s.JavaClass
+	if class == "" {
+		class = defaultJavaClass
+	}
+	fmt.Fprintf(w, "public final class %s {\n    private %s() {}\n\n", class, class)
+	for _, line := range strings.Split(body, "\n") {
+		if line != "" {
+			line = "    " + line
+		}
+		fmt.Fprintln(w, line)
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+// class declares the class name with the fields of st, documented by note
+// if it is not empty.
+func (j *jvmWriter) class(name string, st *StructType, note string) {
+	if note != "" {
+		fmt.Fprintf(&j.buf, "/** %s */\n", note)
+	}
+	keys := st.keys(j.gen)
+	switch {
+	case !j.kotlin:
+		fmt.Fprintf(&j.buf, "public static class %s {\n", name)
+	case len(keys) == 0:
+		// A data class needs at least one property.
+		fmt.Fprintf(&j.buf, "class %s\n\n", name)
+	default:
+		fmt.Fprintf(&j.buf, "data class %s(\n", name)
+	}
+	used := map[string]bool{}
+	for _, k := range keys {
+		f := st.Fields[k]
+		nullable := !j.gen.InferOptional || !st.required(k) || f.Nulls > 0
+		typ := j.fieldType(f.Type, name+j.gen.makeFieldName(k), !nullable)
+		var annotations []string
+		if k == "_id" {
+			annotations = append(annotations, j.annotation("org.bson.codecs.pojo.annotations.BsonId", ""))
+		} else {
+			annotations = append(annotations, j.annotation("org.bson.codecs.pojo.annotations.BsonProperty", k))
+		}
+		annotations = append(annotations, j.annotation("com.fasterxml.jackson.annotation.JsonProperty", k))
+		if j.gen.PresenceComments && !st.synthetic {
+			fmt.Fprintf(&j.buf, "    // present in %s\n", st.presence(k))
+		}
+		fieldName := j.fieldName(k, used)
+		if j.kotlin {
+			def := ""
+			if nullable {
+				typ, def = typ+"?", " = null"
+			}
+			fmt.Fprintf(&j.buf, "    %s val %s: %s%s,\n", strings.Join(annotations, " "), fieldName, typ, def)
+			continue
+		}
+		for _, a := range annotations {
+			fmt.Fprintf(&j.buf, "    %s\n", a)
+		}
+		fmt.Fprintf(&j.buf, "    public %s %s;\n", typ, fieldName)
+	}
+	switch {
+	case !j.kotlin:
+		fmt.Fprint(&j.buf, "}\n\n")
+	case len(keys) > 0:
+		fmt.Fprint(&j.buf, ")\n\n")
+	}
+}
+
+// annotation returns the annotation of the class imp, with the string value
+// if it is not empty.
+func (j *jvmWriter) annotation(imp, value string) string {
+	j.imports[imp] = true
+	a := "@" + imp[strings.LastIndex(imp, ".")+1:]
+	if value != "" {
+		a += fmt.Sprintf("(%q)", value)
+	}
+	return a
+}
+
+// fieldType returns the type of t, naming the class of a nested struct
+// name. A Java field that is never null has a primitive type if t has one.
+func (j *jvmWriter) fieldType(t Type, name string, notNull bool) string {
+	switch v := t.(type) {
+	case *StructType:
+		return j.nested(name, v)
+	case SliceType:
+		return j.list(v.Type, name)
+	case TupleType:
+		if e, ok := v.uniform(j.gen); ok {
+			return j.list(e, name)
+		}
+		return j.list(nil, name)
+	case MapType:
+		if !j.kotlin {
+			j.imports["java.util.Map"] = true
+		}
+		return "Map<String, " + j.fieldType(v.Value, name, false) + ">"
+	case NamedType:
+		switch v.Type.(type) {
+		case *StructType:
+			return v.Name
+		case EnumType:
+			return "String"
+		}
+		return j.fieldType(v.Type, name, notNull)
+	case OverrideType:
+		return j.fieldType(v.Inferred, name, notNull)
+	case GeoJSONType:
+		return j.fieldType(v.Struct(), name, notNull)
+	case LegacyPointType:
+		return j.list(PrimitiveDouble, name)
+	case EnumType:
+		return "String"
+	case PrimitiveType:
+		if p, ok := jvmPrimitives[v]; ok {
+			if p.imp != "" {
+				j.imports[p.imp] = true
+			}
+			switch {
+			case j.kotlin:
+				return p.kotlin
+			case notNull:
+				return p.java
+			}
+			return p.boxed
+		}
+	}
+	return j.any()
+}
+
+// list returns a list of elem, or of anything if elem is unknown.
+func (j *jvmWriter) list(elem Type, name string) string {
+	if !j.kotlin {
+		j.imports["java.util.List"] = true
+	}
+	if isNil(elem) {
+		return "List<" + j.any() + ">"
+	}
+	return "List<" + j.fieldType(elem, name, false) + ">"
+}
+
+// any returns the type of a value of any type.
+func (j *jvmWriter) any() string {
+	if j.kotlin {
+		return "Any"
+	}
+	return "Object"
+}
+
+// fieldName returns the camel-cased name of the key k, escaping keywords,
+// and records it in used.
+func (j *jvmWriter) fieldName(k string, used map[string]bool) string {
+	name := convertCase(k, CaseCamel)
+	if k == "_id" {
+		name = "id"
+	}
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "f" + name
+	}
+	for base, i := name, 2; used[name]; i++ {
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+	used[name] = true
+	switch {
+	case j.kotlin && kotlinKeywords[name]:
+		return "`" + name + "`"
+	case !j.kotlin && javaKeywords[name]:
+		return name + "_"
+	}
+	return name
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+
+	"gopkg.in/mgo.v2"
+)
+
+const (
+	// LegacyCoordsArray types legacy coordinate pairs as [2]float64.
+	LegacyCoordsArray = "array"
+	// LegacyCoordsPoint types legacy coordinate pairs as a generated
+	// LegacyPoint struct, which is still stored as a pair.
+	LegacyCoordsPoint = "point"
+)
+
+// legacyNameRe matches the names of the fields that usually hold legacy
+// coordinate pairs, which identify them when the indexes are not known.
+var legacyNameRe = regexp.MustCompile(`(?i)^(loc|location|coords?|coordinates|position|pos|point|geo|lnglat|lonlat)$`)
+
+// LegacyPointType is a legacy coordinate pair, an array of a longitude and a
+// latitude as used by 2d and 2dsphere indexes. Formats other than Go render
+// it as a tuple of two doubles.
+type LegacyPointType struct{}
+
+func (t LegacyPointType) GoType(gen *Generator) string {
+	return "[2]float64"
+}
+
+func (t LegacyPointType) Merge(o Type, gen *Generator) Type {
+	if isNil(o) || o == t {
+		return t
+	}
+	return t.Tuple().Merge(o, gen)
+}
+
+// Tuple returns the tuple type of the pair.
+func (t LegacyPointType) Tuple() TupleType {
+	return TupleType{Types: []Type{PrimitiveDouble, PrimitiveDouble}}
+}
+
+// legacyPointDecl is the type of the generated LegacyPoint struct.
+type legacyPointDecl struct{}
+
+func (t legacyPointDecl) GoType(gen *Generator) string {
+	return "struct {\nLon float64\nLat float64\n}"
+}
+
+func (t legacyPointDecl) Merge(o Type, gen *Generator) Type {
+	return t
+}
+
+// isLegacyPoint reports whether t is a legacy coordinate pair, as either
+// type.
+func isLegacyPoint(t Type) bool {
+	if n, ok := t.(NamedType); ok {
+		t = n.Type
+	}
+	switch t.(type) {
+	case LegacyPointType, legacyPointDecl:
+		return true
+	}
+	return false
+}
+
+// legacyPoints replaces the fields of root that hold legacy coordinate pairs
+// with legacy point types. A field holds them if it always held two numbers
+// and its path has a 2d or 2dsphere index or, if the indexes of the
+// collection are not known, its name is like loc or coordinates.
+func (s *Generator) legacyPoints(root *StructType, c Collection) {
+	indexes, known := s.indexes[c.Name]
+	s.legacyFields(root, "", geoPaths(indexes), known)
+}
+
+func (s *Generator) legacyFields(st *StructType, prefix string, indexed map[string]bool, known bool) {
+	for k, f := range st.Fields {
+		path := prefix + k
+		if isLegacyPair(f) && (known && indexed[path] || !known && legacyNameRe.MatchString(k)) {
+			f.Type = LegacyPointType{}
+			continue
+		}
+		if nested := nestedStruct(f.Type); nested != nil {
+			s.legacyFields(nested, path+".", indexed, known)
+		}
+	}
+}
+
+// isLegacyPair reports whether f always held arrays of two numbers.
+func isLegacyPair(f *Field) bool {
+	if _, ok := f.Type.(SliceType); !ok || len(f.Tuple) != 2 {
+		return false
+	}
+	for _, t := range f.Tuple {
+		switch t {
+		case PrimitiveDouble, PrimitiveInt32, PrimitiveInt64:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// geoPaths returns the paths with a 2d or 2dsphere index among indexes.
+func geoPaths(indexes []mgo.Index) map[string]bool {
+	paths := map[string]bool{}
+	for _, index := range indexes {
+		for _, key := range index.Key {
+			if field, kind := indexKey(key); kind == "2d" || kind == "2dsphere" {
+				paths[field] = true
+			}
+		}
+	}
+	return paths
+}
+
+// legacyPoint replaces the legacy coordinate pairs of schema with the
+// generated LegacyPoint struct, and returns its declaration if it was not
+// declared for an earlier schema.
+func (h *hoister) legacyPoint(schema Schema) []NamedType {
+	h.decls = nil
+	seen := map[*StructType]bool{}
+	h.legacyFields(schema.Root, seen)
+	for _, n := range schema.Decls {
+		if st, ok := n.Type.(*StructType); ok {
+			h.legacyFields(st, seen)
+		}
+	}
+	return h.decls
+}
+
+func (h *hoister) legacyFields(s *StructType, seen map[*StructType]bool) {
+	if seen[s] {
+		return
+	}
+	seen[s] = true
+	for _, f := range s.Fields {
+		f.Type = h.legacyType(f.Type, seen)
+	}
+}
+
+func (h *hoister) legacyType(t Type, seen map[*StructType]bool) Type {
+	switch v := t.(type) {
+	case LegacyPointType:
+		if h.legacyName == "" {
+			h.legacyName = h.unique("LegacyPoint")
+			h.decls = append(h.decls, NamedType{Name: h.legacyName, Type: legacyPointDecl{}})
+		}
+		return NamedType{Name: h.legacyName, Type: legacyPointDecl{}}
+	case *StructType:
+		h.legacyFields(v, seen)
+	case NamedType:
+		if st, ok := v.Type.(*StructType); ok {
+			h.legacyFields(st, seen)
+		}
+	case SliceType:
+		return SliceType{Type: h.legacyType(v.Type, seen)}
+	case MapType:
+		return MapType{Value: h.legacyType(v.Value, seen)}
+	case MixedType:
+		for i, e := range v {
+			v[i] = h.legacyType(e, seen)
+		}
+	}
+	return t
+}
+
+// writeLegacyPointMethods writes the methods that store the generated struct
+// as a coordinate pair.
+func writeLegacyPointMethods(w io.Writer, name string) {
+	fmt.Fprintf(w, "// GetBSON stores p as a legacy coordinate pair.\n")
+	fmt.Fprintf(w, "func (p %s) GetBSON() (interface{}, error) {\n", name)
+	fmt.Fprintf(w, "\treturn [2]float64{p.Lon, p.Lat}, nil\n}\n\n")
+	fmt.Fprintf(w, "// SetBSON loads p from a legacy coordinate pair.\n")
+	fmt.Fprintf(w, "func (p *%s) SetBSON(raw bson.Raw) error {\n", name)
+	fmt.Fprintf(w, "\tvar pair [2]float64\n")
+	fmt.Fprintf(w, "\tif err := raw.Unmarshal(&pair); err != nil {\n\t\treturn err\n\t}\n")
+	fmt.Fprintf(w, "\tp.Lon, p.Lat = pair[0], pair[1]\n\treturn nil\n}\n\n")
+}
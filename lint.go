@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The smells found by the lint pass.
+const (
+	SmellManyTypes      = "many_types"
+	SmellMixedArray     = "mixed_array"
+	SmellScalarOrArray  = "scalar_or_array"
+	SmellEpochUnits     = "epoch_units"
+	SmellNumericStrings = "numeric_strings"
+)
+
+// lintFinding is a smell of a field, found by the lint pass to guide the
+// cleanup of the data.
+type lintFinding struct {
+	Field  string `json:"field"`
+	Smell  string `json:"smell"`
+	Detail string `json:"detail"`
+}
+
+// lint finds the smells of the fields of root, the raw type of c, logs them
+// as warnings and returns them for the run report.
+func (s *Generator) lint(root *StructType, c Collection) []lintFinding {
+	findings := lintFields(root, "", s)
+	for _, f := range findings {
+		s.logger().Warn("schema smell", "collection", c.Name, "field", f.Field, "smell", f.Smell, "detail", f.Detail)
+	}
+	return findings
+}
+
+func lintFields(st *StructType, prefix string, gen *Generator) []lintFinding {
+	var findings []lintFinding
+	var keys []string
+	for k := range st.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		f := st.Fields[k]
+		path := prefix + k
+		add := func(smell, format string, args ...interface{}) {
+			findings = append(findings, lintFinding{Field: path, Smell: smell, Detail: fmt.Sprintf(format, args...)})
+		}
+		members := typeMembers(f.Type)
+		if len(members) > 2 {
+			add(SmellManyTypes, "holds %d types: %s", len(members), typeNames(members, gen))
+		}
+		if slice, ok := f.Type.(SliceType); ok {
+			if elems := typeMembers(slice.Type); len(elems) > 1 {
+				add(SmellMixedArray, "array of %s", typeNames(elems, gen))
+			}
+		}
+		if scalar, array := scalarOrArray(members); scalar != nil && array != nil {
+			add(SmellScalarOrArray, "holds both %s and %s", shortType(scalar, gen), shortType(array, gen))
+		}
+		if lo, hi, ok := epochUnitMix(f); ok {
+			add(SmellEpochUnits, "ranges from Unix seconds (%s) to Unix milliseconds (%s)", lo, hi)
+		}
+		if detail := numericStrings(f, members); detail != "" {
+			add(SmellNumericStrings, "%s", detail)
+		}
+		if nested := nestedStruct(f.Type); nested != nil {
+			findings = append(findings, lintFields(nested, path+".", gen)...)
+		}
+	}
+	return findings
+}
+
+// typeMembers returns the types t is made of, leaving out null.
+func typeMembers(t Type) []Type {
+	var members []Type
+	m, ok := t.(MixedType)
+	if !ok {
+		m = MixedType{t}
+	}
+	for _, e := range m {
+		if !isNil(e) {
+			members = append(members, e)
+		}
+	}
+	return members
+}
+
+func typeNames(types []Type, gen *Generator) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = shortType(t, gen)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// scalarOrArray returns a member of members that is an array and one that
+// is not, if there are both.
+func scalarOrArray(members []Type) (scalar, array Type) {
+	for _, t := range members {
+		if _, ok := t.(SliceType); ok {
+			array = t
+		} else if scalar == nil {
+			scalar = t
+		}
+	}
+	return scalar, array
+}
+
+// epochUnitMix reports whether the numbers of f range from Unix times in
+// seconds, 10 digits long, to Unix times in milliseconds, 13 digits long,
+// and returns the dates they range between.
+func epochUnitMix(f *Field) (lo, hi string, ok bool) {
+	if f.Min == nil || f.Max == nil {
+		return "", "", false
+	}
+	min, max := float64(epochMin.Unix()), float64(epochMax.Unix())
+	if *f.Min < min || *f.Min >= max || *f.Max < min*1000 || *f.Max >= max*1000 {
+		return "", "", false
+	}
+	date := func(t time.Time) string { return t.UTC().Format("2006-01-02") }
+	return date(time.Unix(int64(*f.Min), 0)), date(time.Unix(0, int64(*f.Max)*int64(time.Millisecond))), true
+}
+
+// numericStrings describes the numbers f holds as strings, if any: when all
+// its strings are numbers, or when it also holds numbers.
+func numericStrings(f *Field, members []Type) string {
+	if f.NumericStrings == 0 {
+		return ""
+	}
+	var str, num bool
+	for _, t := range members {
+		switch t {
+		case PrimitiveString:
+			str = true
+		case PrimitiveInt32, PrimitiveInt64, PrimitiveDouble, PrimitiveDecimal128:
+			num = true
+		}
+	}
+	switch {
+	case str && num:
+		return fmt.Sprintf("holds numbers, and numbers as strings %d times", f.NumericStrings)
+	case str && len(members) == 1 && f.NumericStrings == f.Count-f.Nulls:
+		return fmt.Sprintf("holds numbers as strings in all %d documents", f.NumericStrings)
+	}
+	return ""
+}
+
+// isNumeric reports whether the string v is a decimal number.
+func isNumeric(v string) bool {
+	if v == "" || strings.IndexAny(v[:1], "+-.0123456789") < 0 {
+		return false
+	}
+	_, err := strconv.ParseFloat(v, 64)
+	return err == nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	MongooseJS = "js"
+	MongooseTS = "ts"
+)
+
+// mongooseWriter converts Type trees to Mongoose schema definitions. Named
+// structs become schemas of their own and named enums arrays of their
+// values, which the fields using them refer to by name.
+type mongooseWriter struct {
+	gen   *Generator
+	decls map[string]Type
+}
+
+// renderMongoose writes a Mongoose schema and model for every collection, as
+// a CommonJS module or, with mongoose_lang set to ts, a TypeScript module.
+// The declarations of all the schemas come first, as those of one collection
+// may be used by another.
+func (s *Generator) renderMongoose(w io.Writer, schemas []Schema) error {
+	m := &mongooseWriter{gen: s, decls: map[string]Type{}}
+	ts := s.MongooseLang == MongooseTS
+	export := ""
+	if ts {
+		export = "export "
+		fmt.Fprintln(w, "import { Schema, model } from 'mongoose';")
+	} else {
+		fmt.Fprintln(w, "const mongoose = require('mongoose');")
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "const { Schema } = mongoose;")
+	}
+	fmt.Fprintln(w)
+	var names []string
+	for _, schema := range schemas {
+		for _, n := range schema.Decls {
+			m.decls[n.Name] = n.Type
+		}
+	}
+	for _, schema := range schemas {
+		for _, n := range schema.Decls {
+			if t, ok := n.Type.(EnumType); ok {
+				fmt.Fprintf(w, "%sconst %sValues = %s;\n\n", export, n.Name, jsStrings(t.Values))
+				names = append(names, n.Name+"Values")
+			}
+		}
+	}
+	// A schema must be defined before the schemas embedding it.
+	defined := map[string]bool{}
+	var define func(name string)
+	define = func(name string) {
+		t, ok := m.decls[name].(*StructType)
+		if !ok || defined[name] {
+			return
+		}
+		defined[name] = true
+		s.walk(t, func(e Type) {
+			if n, ok := e.(NamedType); ok {
+				define(n.Name)
+			}
+		})
+		fmt.Fprintf(w, "%sconst %sSchema = new Schema(%s, %s);\n\n", export, name, m.object(t, 0), subdocOptions(t))
+		names = append(names, name+"Schema")
+	}
+	for _, schema := range schemas {
+		for _, n := range schema.Decls {
+			define(n.Name)
+		}
+	}
+	for _, schema := range schemas {
+		name := schema.Collection.Struct
+		fmt.Fprintf(w, "%sconst %sSchema = new Schema(%s, { collection: %s });\n\n",
+			export, name, m.object(schema.Root, 0), jsString(schema.Collection.Name))
+		if ts {
+			fmt.Fprintf(w, "export const %s = model(%s, %sSchema);\n\n", name, jsString(name), name)
+		} else {
+			fmt.Fprintf(w, "const %s = mongoose.model(%s, %sSchema);\n\n", name, jsString(name), name)
+		}
+		names = append(names, name+"Schema", name)
+	}
+	if !ts {
+		fmt.Fprintf(w, "module.exports = {\n")
+		for _, n := range names {
+			fmt.Fprintf(w, "  %s,\n", n)
+		}
+		fmt.Fprintln(w, "};")
+	}
+	return nil
+}
+
+// subdocOptions returns the options of the schema of an embedded document,
+// which has no _id unless one was seen.
+func subdocOptions(st *StructType) string {
+	if _, ok := st.Fields["_id"]; ok {
+		return "{}"
+	}
+	return "{ _id: false }"
+}
+
+// object returns the schema definition of the fields of st, indenting it to
+// the given depth.
+func (m *mongooseWriter) object(st *StructType, depth int) string {
+	var b strings.Builder
+	indent := strings.Repeat("  ", depth+1)
+	fmt.Fprintln(&b, "{")
+	for _, k := range st.keys(m.gen) {
+		name := k
+		if !tsIdentRe.MatchString(k) {
+			name = jsString(k)
+		}
+		fmt.Fprintf(&b, "%s%s: %s,\n", indent, name, m.field(st, k, depth+1))
+	}
+	fmt.Fprintf(&b, "%s}", strings.Repeat("  ", depth))
+	return b.String()
+}
+
+// field returns the schema type of the field named k, with its options if it
+// is required or an enum.
+func (m *mongooseWriter) field(st *StructType, k string, depth int) string {
+	typ, enum := m.schemaType(st.Fields[k].Type, depth)
+	required := m.gen.InferOptional && st.required(k)
+	if enum == "" && !required {
+		return typ
+	}
+	opts := []string{"type: " + typ}
+	if required {
+		opts = append(opts, "required: true")
+	}
+	if enum != "" {
+		opts = append(opts, "enum: "+enum)
+	}
+	return "{ " + strings.Join(opts, ", ") + " }"
+}
+
+// schemaType returns the Mongoose schema type of t and, if t is an enum, the
+// expression of its values.
+func (m *mongooseWriter) schemaType(t Type, depth int) (typ, enum string) {
+	switch v := t.(type) {
+	case *StructType:
+		return m.object(v, depth), ""
+	case NamedType:
+		switch m.decls[v.Name].(type) {
+		case *StructType:
+			return v.Name + "Schema", ""
+		case EnumType:
+			return "String", v.Name + "Values"
+		}
+		return m.schemaType(v.Type, depth)
+	case EnumType:
+		return "String", jsStrings(v.Values)
+	case SliceType:
+		if isNil(v.Type) {
+			return "[]", ""
+		}
+		return "[" + m.inline(v.Type, depth) + "]", ""
+	case TupleType:
+		if e, ok := v.uniform(m.gen); ok {
+			return "[" + m.inline(e, depth) + "]", ""
+		}
+		return "[Schema.Types.Mixed]", ""
+	case MapType:
+		of := m.inline(v.Value, depth)
+		if st, ok := v.Value.(*StructType); ok {
+			of = "new Schema(" + of + ", " + subdocOptions(st) + ")"
+		}
+		return "{ type: Map, of: " + of + " }", ""
+	case OverrideType:
+		return m.schemaType(v.Inferred, depth)
+	case GeoJSONType:
+		return m.object(v.Struct(), depth), ""
+	case LegacyPointType:
+		return m.schemaType(v.Tuple(), depth)
+	case PrimitiveType:
+		return mongoosePrimitive(v), ""
+	}
+	return "Schema.Types.Mixed", ""
+}
+
+// inline returns the schema type of t where no options can follow it, such as
+// the elements of arrays, wrapping enums in their options.
+func (m *mongooseWriter) inline(t Type, depth int) string {
+	typ, enum := m.schemaType(t, depth)
+	if enum != "" {
+		return "{ type: " + typ + ", enum: " + enum + " }"
+	}
+	return typ
+}
+
+func mongoosePrimitive(p PrimitiveType) string {
+	switch p {
+	case PrimitiveBool:
+		return "Boolean"
+	case PrimitiveDouble, PrimitiveInt32, PrimitiveInt64:
+		return "Number"
+	case PrimitiveString, PrimitiveRegEx, PrimitiveJavaScript, PrimitiveSymbol:
+		return "String"
+	case PrimitiveTimestamp:
+		return "Date"
+	case PrimitiveBinary, PrimitiveBytes:
+		return "Buffer"
+	case PrimitiveObjectId:
+		return "Schema.Types.ObjectId"
+	case PrimitiveDecimal128:
+		return "Schema.Types.Decimal128"
+	case PrimitiveUUID:
+		return "Schema.Types.UUID"
+	}
+	return "Schema.Types.Mixed"
+}
+
+var jsReplacer = strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`, "\r", `\r`)
+
+// jsString returns s as a single quoted JavaScript string.
+func jsString(s string) string {
+	return "'" + jsReplacer.Replace(s) + "'"
+}
+
+func jsStrings(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = jsString(v)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
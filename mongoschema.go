@@ -2,14 +2,22 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"log/slog"
+	"math"
 	"os"
+	"os/signal"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 	"unicode"
 
@@ -21,88 +29,1050 @@ import (
 var errEmptyURL = errors.New("mongoschema: no URL specified")
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("mongoschema [config.yaml]")
+	noFormat := flag.Bool("noformat", false, "print generated code without formatting it")
+	verbose := flag.Bool("verbose", false, "log per-collection progress to stderr")
+	flag.BoolVar(verbose, "progress", false, "alias for -verbose")
+	quiet := flag.Bool("quiet", false, "log nothing but fatal errors")
+	watch := flag.Bool("watch", false, "keep following change streams and regenerate when the schema changes")
+	addr := flag.String("addr", ":8080", "address to serve on")
+	dryRun := flag.Bool("dry-run", false, "list the collections and how they would be sampled, without scanning them")
+	bench := flag.Bool("bench", false, "report the documents scanned per second and the peak memory use to stderr")
+	snapshot := flag.String("snapshot", "", "write the inferred types as JSON to the file, to compare with diff")
+	exportSchema := flag.String("export-schema", "", "write the inferred types, with their statistics, as JSON to the file")
+	importSchema := flag.String("import-schema", "", "generate from the types in the file, written by -export-schema, instead of scanning")
+	report := flag.String("report", "", "write a JSON report of the run to the file, or to stdout if -")
+	profile := flag.String("profile", "", "override the settings of the config with those of the named profile")
+	update := flag.Bool("update", false, "write the golden file of golden instead of comparing the output to it")
+	check := flag.Bool("check", false, "fail if the schema hash of an output file differs from that of the schema inferred now")
+	flag.Parse()
+	if flag.NArg() < 1 {
+		fmt.Println("mongoschema [flags] [config.yaml]")
+		fmt.Println("mongoschema [flags] check [config.yaml] [models.go]")
+		fmt.Println("mongoschema [flags] serve [config.yaml]")
+		fmt.Println("mongoschema [flags] explore [config.yaml]")
+		fmt.Println("mongoschema [flags] resolve [config.yaml]")
+		fmt.Println("mongoschema diff [old.json] [new.json]")
+		fmt.Println("mongoschema [flags] golden [config.yaml] [fixtures.json] [golden file]")
+		flag.PrintDefaults()
 		return
 	}
+	// An interrupt stops the run, flushing the partial output; a second one
+	// kills it.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
 
-	f, err := os.Open(os.Args[1])
+	if flag.Arg(0) == "check" {
+		if flag.NArg() != 3 {
+			log.Fatal("mongoschema: check needs a config file and a Go file")
+		}
+		g, err := loadConfig(flag.Arg(1), *profile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		g.Verbose = g.Verbose || *verbose
+		g.Quiet = g.Quiet || *quiet
+		drift, err := g.Check(ctx, flag.Arg(2), os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if drift {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "diff" {
+		if flag.NArg() != 3 {
+			log.Fatal("mongoschema: diff needs two snapshot files")
+		}
+		changed, err := Diff(flag.Arg(1), flag.Arg(2), os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if changed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "golden" {
+		if flag.NArg() != 4 {
+			log.Fatal("mongoschema: golden needs a config file, a fixtures file and a golden file")
+		}
+		g, err := loadConfig(flag.Arg(1), *profile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		g.Quiet = true
+		fixtures, err := LoadFixtures(flag.Arg(2))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := g.Golden(fixtures, flag.Arg(3), *update); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "serve" {
+		if flag.NArg() != 2 {
+			log.Fatal("mongoschema: serve needs a config file")
+		}
+		g, err := loadConfig(flag.Arg(1), *profile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		g.Verbose = g.Verbose || *verbose
+		g.Quiet = g.Quiet || *quiet
+		log.Fatal(g.Serve(*addr))
+	}
+
+	if flag.Arg(0) == "explore" {
+		if flag.NArg() != 2 {
+			log.Fatal("mongoschema: explore needs a config file")
+		}
+		g, err := loadConfig(flag.Arg(1), *profile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		g.NoFormat = g.NoFormat || *noFormat
+		g.Verbose = g.Verbose || *verbose
+		g.Quiet = g.Quiet || *quiet
+		if err := g.Explore(ctx, os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "resolve" {
+		if flag.NArg() != 2 {
+			log.Fatal("mongoschema: resolve needs a config file")
+		}
+		g, err := loadConfig(flag.Arg(1), *profile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		g.NoFormat = g.NoFormat || *noFormat
+		g.Verbose = g.Verbose || *verbose
+		g.Quiet = g.Quiet || *quiet
+		if err := g.Resolve(ctx, os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	g, err := loadConfig(flag.Arg(0), *profile)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if *noFormat {
+		g.NoFormat = true
+	}
+	if *snapshot != "" {
+		g.Snapshot = *snapshot
+	}
+	if *exportSchema != "" {
+		g.ExportSchema = *exportSchema
+	}
+	if *importSchema != "" {
+		g.ImportSchema = *importSchema
+	}
+	if *report != "" {
+		g.Report = *report
+	}
+	g.Verbose = g.Verbose || *verbose
+	g.Quiet = g.Quiet || *quiet
+	// A watch runs until stopped, so its conflicts only show in its output.
+	if !*watch {
+		g.runReport = newRunReport()
+	}
+	var checkSink *CheckSink
+	if *check {
+		if g.commentPrefix() == "" {
+			log.Fatalf("mongoschema: -check is not supported by the %s format", g.Format)
+		}
+		checkSink = &CheckSink{}
+		g.Sink = checkSink
+		g.HeaderHash = true
+	}
+	start := time.Now()
+	switch {
+	case *dryRun:
+		err = g.DryRun(ctx, os.Stdout)
+	case *watch:
+		err = g.Watch(ctx, os.Stdout)
+	default:
+		err = g.Generate(ctx)
+	}
+	if err == nil && checkSink != nil && len(checkSink.Stale) > 0 {
+		for _, stale := range checkSink.Stale {
+			fmt.Println(stale)
+		}
+		err = errStale
+	}
+	if g.Report != "" && g.runReport != nil && !*dryRun {
+		if err := g.runReport.write(g.Report, err); err != nil {
+			log.Print(err)
+		}
+	}
+	if err != nil {
+		log.Print(err)
+	}
+	if *bench {
+		g.report(os.Stderr, time.Since(start))
+	}
+	if code := g.runReport.exitCode(err); code != ExitOK {
+		os.Exit(code)
+	}
+}
+
+// loadConfig reads the config file name, expanding the environment variables
+// it refers to, and applies the profile if it is not empty.
+func loadConfig(name, profile string) (*Generator, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
 	defer f.Close()
 	buf, err := ioutil.ReadAll(f)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	if buf, err = expandEnv(buf); err != nil {
+		return nil, fmt.Errorf("mongoschema: %s: %s", name, err)
 	}
 	var g Generator
-	if err := yaml.Unmarshal(buf, &g); err != nil {
-		log.Fatal(err)
+	if err := yaml.UnmarshalStrict(buf, &g); err != nil {
+		return nil, fmt.Errorf("mongoschema: %s: %s", name, err)
 	}
-	if err := g.Generate(); err != nil {
-		log.Fatal(err)
+	if profile != "" {
+		if err := g.applyProfile(profile); err != nil {
+			return nil, fmt.Errorf("mongoschema: %s: %s", name, err)
+		}
+	}
+	if err := g.Validate(); err != nil {
+		if errs, ok := err.(configErrors); ok {
+			return nil, errs.located(buf, profile)
+		}
+		return nil, err
 	}
+	return &g, nil
 }
 
 type Generator struct {
-	URL           string       `yaml:"url"`
-	DB            string       `yaml:"db"`
-	Limit         uint         `yaml:"limit"`
-	Comments      bool         `yaml:"comments"`
-	IgnoredFields []string     `yaml:"ignored_fields"`
-	Collections   []Collection `yaml:"collections"`
+	Source   string            `yaml:"source"`
+	URL      string            `yaml:"url"`
+	Clusters map[string]string `yaml:"clusters"`
+	// ReplicaSet is the name of the replica set to discover from the hosts
+	// of the URL, overriding its replicaSet option. Direct connects to those
+	// hosts only, without discovering the other members.
+	ReplicaSet      string           `yaml:"replica_set"`
+	Direct          bool             `yaml:"direct"`
+	Auth            AuthConfig       `yaml:"auth"`
+	TLS             TLSConfig        `yaml:"tls"`
+	Connection      ConnectionConfig `yaml:"connection"`
+	ReadPreference  ReadPreference   `yaml:"read_preference"`
+	MaxTimeMS       int              `yaml:"max_time_ms"`
+	RateLimit       int              `yaml:"rate_limit"`
+	BatchSize       int              `yaml:"batch_size"`
+	BatchDelay      string           `yaml:"batch_delay"`
+	SecondaryOnly   bool             `yaml:"secondary_only"`
+	PerShard        bool             `yaml:"per_shard"`
+	Retries         int              `yaml:"retries"`
+	Timeout         string           `yaml:"timeout"`
+	NoCursorTimeout bool             `yaml:"no_cursor_timeout"`
+	DB              string           `yaml:"db"`
+	Dump            string           `yaml:"dump"`
+	Export          string           `yaml:"export"`
+	Limit           uint             `yaml:"limit"`
+	Sampling        string           `yaml:"sampling"`
+	Concurrency     int              `yaml:"concurrency"`
+	// DecodeWorkers, if more than one, decode the documents of each scan
+	// while its cursor is read and the types are merged.
+	DecodeWorkers    int     `yaml:"decode_workers"`
+	LowMemory        bool    `yaml:"low_memory"`
+	Partitions       int     `yaml:"partitions"`
+	Analysis         string  `yaml:"analysis"`
+	Comments         bool    `yaml:"comments"`
+	PresenceComments bool    `yaml:"presence_comments"`
+	Examples         int     `yaml:"examples"`
+	InferOptional    bool    `yaml:"infer_optional"`
+	OptionalStyle    string  `yaml:"optional_style"`
+	UUIDType         string  `yaml:"uuid_type"`
+	NullFields       bool    `yaml:"null_fields"`
+	GeoJSON          string  `yaml:"geojson"`
+	LegacyCoords     string  `yaml:"legacy_coordinates"`
+	IntPolicy        string  `yaml:"int_policy"`
+	Tuples           bool    `yaml:"tuples"`
+	NoFixedArrays    bool    `yaml:"no_fixed_arrays"`
+	FixedArrayMin    int     `yaml:"fixed_array_min"`
+	ElementComments  bool    `yaml:"element_comments"`
+	ConflictIDs      bool    `yaml:"conflict_ids"`
+	MapThreshold     int     `yaml:"map_threshold"`
+	MapKeyPattern    string  `yaml:"map_key_pattern"`
+	EnumThreshold    int     `yaml:"enum_threshold"`
+	TypedRefs        bool    `yaml:"typed_refs"`
+	RefDepth         int     `yaml:"ref_depth"`
+	RefLimit         uint    `yaml:"ref_limit"`
+	NamedStructs     bool    `yaml:"named_structs"`
+	SharedStructs    bool    `yaml:"shared_structs"`
+	SharedThreshold  float64 `yaml:"shared_threshold"`
+	ValidateMethods  bool    `yaml:"validate_methods"`
+	DocComments      bool    `yaml:"doc_comments"`
+	// Header starts each file with a comment marking it as generated, and
+	// HeaderHash, which implies it, adds the hash of its schemas for -check.
+	Header           bool             `yaml:"header"`
+	HeaderHash       bool             `yaml:"header_hash"`
+	IndexComments    bool             `yaml:"index_comments"`
+	IndexMethods     bool             `yaml:"index_methods"`
+	Helpers          bool             `yaml:"helpers"`
+	StructNaming     string           `yaml:"struct_naming"`
+	FieldOrder       string           `yaml:"field_order"`
+	Format           string           `yaml:"format"`
+	Package          string           `yaml:"package"`
+	TSDateType       string           `yaml:"ts_date_type"`
+	ProtoPackage     string           `yaml:"proto_package"`
+	ProtoNumbering   string           `yaml:"proto_numbering"`
+	AvroNamespace    string           `yaml:"avro_namespace"`
+	SQLDialect       string           `yaml:"sql_dialect"`
+	SQLNested        string           `yaml:"sql_nested"`
+	MongooseLang     string           `yaml:"mongoose_lang"`
+	JVMPackage       string           `yaml:"jvm_package"`
+	JavaClass        string           `yaml:"java_class"`
+	PythonStyle      string           `yaml:"python_style"`
+	FlatStyle        string           `yaml:"flat_style"`
+	Renderers        []RendererConfig `yaml:"renderers"`
+	ApplyValidator   bool             `yaml:"apply_validator"`
+	ValidationLevel  string           `yaml:"validation_level"`
+	ValidationAction string           `yaml:"validation_action"`
+	Registry         RegistryConfig   `yaml:"registry"`
+	Output           Output           `yaml:"output"`
+	State            string           `yaml:"state"`
+	Snapshot         string           `yaml:"snapshot"`
+	// ExportSchema is the file the inferred types of a run are written to,
+	// and ImportSchema a file generated from instead of scanning.
+	ExportSchema string `yaml:"export_schema"`
+	ImportSchema string `yaml:"import_schema"`
+	// Report is the file the JSON report of a run is written to, or - for
+	// stdout.
+	Report          string            `yaml:"report"`
+	NoFormat        bool              `yaml:"no_format"`
+	Goimports       bool              `yaml:"goimports"`
+	Verbose         bool              `yaml:"verbose"`
+	Quiet           bool              `yaml:"quiet"`
+	Tags            []TagConfig       `yaml:"tags"`
+	OmitEmpty       string            `yaml:"omitempty"`
+	OmitEmptyFields map[string]bool   `yaml:"omitempty_fields"`
+	ExtraTags       []string          `yaml:"extra_tags"`
+	Acronyms        []string          `yaml:"acronyms"`
+	FieldNames      map[string]string `yaml:"field_names"`
+	Overrides       map[string]string `yaml:"overrides"`
+	// OverridesFile holds more overrides, like those chosen by the resolve
+	// command, which records them there.
+	OverridesFile string   `yaml:"overrides_file"`
+	IgnoredFields []string `yaml:"ignored_fields"`
+	// OpaqueFields, patterns like IgnoredFields, are typed as raw
+	// documents, such as free-form metadata never worth typing.
+	OpaqueFields []string `yaml:"opaque_fields"`
+	MinPresence  string   `yaml:"min_presence"`
+	// Lint warns of the smells of the inferred types, like fields that are
+	// sometimes arrays, and adds them to the run report.
+	Lint bool `yaml:"lint"`
+	// MaxDepth, if set, limits the levels of nested documents typed as
+	// structs; deeper documents are typed as maps.
+	MaxDepth     int               `yaml:"max_depth"`
+	UnknownTypes string            `yaml:"unknown_types"`
+	EpochTimes   string            `yaml:"epoch_times"`
+	RareComments bool              `yaml:"rare_comments"`
+	BaseFields   []string          `yaml:"base_fields"`
+	BaseStruct   string            `yaml:"base_struct"`
+	Include      []string          `yaml:"include"`
+	Exclude      []string          `yaml:"exclude"`
+	Singulars    map[string]string `yaml:"singulars"`
+	Packages     Packages          `yaml:"packages"`
+	Collections  Collections       `yaml:"collections"`
+	Databases    []Database        `yaml:"databases"`
+	Profiles     Profiles          `yaml:"profiles"`
+	// Sink, if set, receives the output instead of the sink configured by
+	// output.sink, such as a MemorySink when used as a library.
+	Sink OutputSink `yaml:"-"`
+
+	state *schemaState
+	// scanned counts the documents scanned, for -bench.
+	scanned uint64
+	// runReport collects the report of the run, if any.
+	runReport *runReport
+	// indexes holds the indexes of each collection, if they are used and
+	// the source has them.
+	indexes map[string][]mgo.Index
+	// genTime is the time the output was generated at, for doc comments.
+	genTime time.Time
+	// genVersion, if set, is the version written in headers instead of
+	// version.
+	genVersion string
+	// infos holds the metadata of each collection, if the source has it.
+	infos map[string]collectionInfo
 }
 
 type Collection struct {
-	Name   string `yaml:"name"`
-	Struct string `yaml:"struct"`
+	Name     string `yaml:"name"`
+	Struct   string `yaml:"struct"`
+	Sampling string `yaml:"sampling"`
+	// Filter is a query document, either as YAML or as a string of MongoDB
+	// extended JSON.
+	Filter     interface{} `yaml:"filter"`
+	Partitions int         `yaml:"partitions"`
+	Limit      uint        `yaml:"limit"`
+	// Sort orders a natural scan by the fields listed, in descending order
+	// for those prefixed with -, such as -_id for the newest documents first.
+	Sort []string `yaml:"sort"`
+	// Projection selects the fields fetched, like the projection of a find,
+	// to leave out large fields on the server. It is a document like Filter.
+	Projection interface{} `yaml:"projection"`
+	// Timeout limits the time spent scanning the collection, like 10m,
+	// after which the documents scanned so far make up its type.
+	Timeout string `yaml:"timeout"`
+	// IgnoredFields are added to the ignored fields of the config for this
+	// collection only.
+	IgnoredFields []string `yaml:"ignored_fields"`
+	// OpaqueFields are added to the opaque fields of the config for this
+	// collection only.
+	OpaqueFields []string `yaml:"opaque_fields"`
+	// Pipeline is an aggregation pipeline, a list of stages as YAML or a
+	// string of MongoDB extended JSON, whose output documents are scanned
+	// in place of those of the collection, to infer projected or joined
+	// shapes. It runs on From, or on the collection named Name.
+	Pipeline interface{} `yaml:"pipeline"`
+	From     string      `yaml:"from"`
+	// Clusters, if set, are the clusters the collection is scanned on, by
+	// name in the clusters of the config or by URL, to merge the schemas of
+	// the same collection in several regions and report how they differ.
+	Clusters []string `yaml:"clusters"`
+	// Reference, if set, is a query document like Filter selecting the
+	// document whose key order, nested documents included, orders the
+	// fields with the document field order, in place of the first document
+	// scanned.
+	Reference interface{} `yaml:"reference"`
+	// Discriminator, if set, is the field whose string value tells the
+	// variant of a polymorphic document, such as type. Each variant gets a
+	// struct of its own fields embedding one of the fields they share.
+	Discriminator string `yaml:"discriminator"`
+
+	// limit overrides Limit for a partition of the collection, and skip
+	// skips the documents of a sorted scan already seen when resuming it.
+	limit uint
+	skip  uint
 }
 
-func (s *Generator) connect() (*mgo.Session, error) {
+const (
+	SamplingNatural = "natural"
+	SamplingRandom  = "random"
+	// SamplingSmart samples the oldest and newest documents and a random
+	// selection of the rest.
+	SamplingSmart = "smart"
+)
+
+// defaultSampleSize is the $sample size used when no limit is configured.
+const defaultSampleSize = 1000
+
+func (s *Generator) connect(ctx context.Context) (*mgo.Session, error) {
 	if s.URL == "" {
 		return nil, errEmptyURL
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
-	session, err := mgo.Dial(s.URL)
+	info, err := s.dialInfo()
 	if err != nil {
 		return nil, err
 	}
+	return s.dial(ctx, info)
+}
+
+// dial connects to the servers of info and sets up the session for
+// scanning.
+func (s *Generator) dial(ctx context.Context, info *mgo.DialInfo) (*mgo.Session, error) {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < info.Timeout {
+		info.Timeout = time.Until(deadline)
+	}
+	session, err := mgo.DialWithInfo(info)
+	if err != nil {
+		s.runReport.connectionFailed()
+		return nil, err
+	}
 	session.EnsureSafe(&mgo.Safe{})
-	session.SetBatch(1000)
-	session.SetMode(mgo.Eventual, true)
+	session.SetBatch(s.batchSize())
+	s.Connection.apply(session)
+	pref := s.ReadPreference
+	if s.SecondaryOnly {
+		pref.Mode = "secondary"
+	}
+	pref.apply(session)
+	if s.SecondaryOnly {
+		if err := checkSecondary(session); err != nil {
+			session.Close()
+			return nil, err
+		}
+	}
+	if s.NoCursorTimeout {
+		session.SetCursorTimeout(0)
+	}
 	return session, nil
 }
 
-func (s *Generator) Generate() error {
-	session, err := s.connect()
+// Generate scans the collections and writes the code generated from their
+// schemas. When ctx is done or the timeout of the run passes, the scans in
+// progress stop at the next document, the collections not started are left
+// out, and the output is generated from what was scanned; only the former is
+// an error.
+func (s *Generator) Generate(ctx context.Context) error {
+	run, cancel := s.withTimeout(ctx)
+	defer cancel()
+	var err error
+	if len(s.Databases) > 0 {
+		err = s.generateDatabases(run)
+	} else {
+		err = s.generateDB(run)
+	}
+	if err == nil && ctx.Err() != nil {
+		return interrupted(ctx)
+	}
+	return err
+}
+
+// generateDB generates the code of a single database.
+func (s *Generator) generateDB(ctx context.Context) error {
+	var src Source
+	var roots []*StructType
+	var err error
+	if s.ImportSchema != "" {
+		roots, err = s.importTypes()
+	} else if src, err = s.source(ctx); err == nil {
+		defer src.Close()
+		roots, err = s.scanRoots(ctx, src)
+	}
 	if err != nil {
 		return err
 	}
-	defer session.Close()
-	for _, c := range s.Collections {
-		collection := session.DB(s.DB).C(c.Name)
-
-		root := StructType{}
-		iter := collection.Find(nil).Iter()
-		m := bson.M{}
-		var seen uint
-		for iter.Next(m) {
-			if s.Limit != 0 && seen == s.Limit {
-				break
-			}
-			root.Merge(NewType(m, s), s)
-			m = bson.M{}
-			seen++
+	if s.Snapshot != "" {
+		if err := s.writeSnapshot(roots); err != nil {
+			return err
 		}
-		if err := iter.Close(); err != nil {
+	}
+	if s.ExportSchema != "" {
+		if err := s.exportTypes(roots); err != nil {
+			return err
+		}
+	}
+	for _, grp := range s.splitPackages(roots) {
+		if err := grp.gen.generatePackage(ctx, src, grp.roots); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generatePackage transforms the raw types roots of the collections of s,
+// read from src, and writes their output.
+func (s *Generator) generatePackage(ctx context.Context, src Source, roots []*StructType) error {
+	schemas, err := s.transform(roots)
+	if err != nil {
+		return err
+	}
+	if s.ApplyValidator && ctx.Err() != nil {
+		s.logger().Warn("run stopped, validators not applied from partial schemas")
+	} else if s.ApplyValidator {
+		mongo, ok := src.(*mongoSource)
+		if !ok {
+			return errors.New("mongoschema: apply_validator requires a mongo source")
+		}
+		if err := s.applyValidators(mongo.session, schemas); err != nil {
 			return err
 		}
-		fmt.Println(c.Struct, root.GoType(s))
-		fmt.Println()
+	}
+	if err := s.write(schemas); err != nil {
+		return err
+	}
+	if s.Registry.URL != "" && ctx.Err() != nil {
+		s.logger().Warn("run stopped, partial schemas not published")
+	} else if s.Registry.URL != "" {
+		return s.publish(ctx, schemas)
 	}
 	return nil
 }
 
+// infer scans every collection in src and returns their schemas.
+func (s *Generator) infer(ctx context.Context, src Source) ([]Schema, error) {
+	roots, err := s.scanRoots(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	return s.transform(roots)
+}
+
+// scanRoots scans every collection in src and returns their raw types, in
+// the same order as s.Collections. The collections left unscanned when ctx
+// is done are removed from s.Collections.
+func (s *Generator) scanRoots(ctx context.Context, src Source) ([]*StructType, error) {
+	if err := s.resolveCollections(src); err != nil {
+		return nil, err
+	}
+	if s.State != "" {
+		state, err := loadState(s.State)
+		if err != nil {
+			return nil, err
+		}
+		s.state = state
+	}
+	if err := s.loadInfos(src); err != nil {
+		return nil, err
+	}
+	if err := s.loadIndexes(src); err != nil {
+		return nil, err
+	}
+	roots, err := s.scanAll(ctx, src, s.Collections)
+	if err != nil {
+		return nil, err
+	}
+	s.Collections, roots = s.keepScanned(s.Collections, roots)
+	if err := s.applyReferences(src, roots); err != nil {
+		return nil, err
+	}
+	if roots, err = s.followRefs(ctx, src, roots); err != nil {
+		return nil, err
+	}
+	if s.state != nil {
+		if err := s.state.save(s.State); err != nil {
+			return nil, err
+		}
+	}
+	return roots, nil
+}
+
+// transform detects maps, named structs and enums in the raw types of the
+// collections, modifying them, and returns the resulting schemas.
+func (s *Generator) transform(roots []*StructType) ([]Schema, error) {
+	if s.OverridesFile != "" {
+		if err := s.loadOverrides(); err != nil {
+			return nil, err
+		}
+	}
+	h := newHoister(s)
+	var schemas []Schema
+	for i, c := range s.Collections {
+		root := roots[i]
+		var full *StructType
+		if s.buildsValidators() {
+			// The validator checks the documents of every variant, so it
+			// is built from root before their fields are split off.
+			full = root.clone()
+			full.variants = nil
+		}
+		if !s.NullFields {
+			dropNulls(root)
+		}
+		variants := s.splitVariants(root, c, h)
+		var r rootReport
+		if err := s.transformRoot(root, c, &r); err != nil {
+			return nil, err
+		}
+		for _, v := range variants {
+			if err := s.transformRoot(v.st, c, &r); err != nil {
+				return nil, err
+			}
+		}
+		if s.Lint {
+			s.runReport.lint(s.DB, c.Name, r.lint)
+		}
+		s.runReport.mixed(s.DB, c.Name, r.mixed)
+		if full != nil {
+			// What transforming it finds was already reported for root.
+			quiet := *s
+			quiet.Quiet = true
+			if err := quiet.transformRoot(full, c, &rootReport{}); err != nil {
+				return nil, err
+			}
+		}
+		schema := Schema{Collection: c, Root: root, full: full}
+		if s.NamedStructs {
+			schema.Decls = h.hoist(root, c.Struct)
+		}
+		for _, v := range variants {
+			schema.Variants = append(schema.Variants, v.Variant)
+			schema.Decls = append(schema.Decls, NamedType{Name: v.Name, Type: v.st})
+			if s.NamedStructs {
+				schema.Decls = append(schema.Decls, h.hoist(v.st, v.Name)...)
+			}
+		}
+		if s.EnumThreshold > 0 {
+			schema.Decls = append(schema.Decls, h.enums(schema)...)
+		}
+		if s.TypedRefs {
+			schema.Decls = append(schema.Decls, h.refs(schema)...)
+		}
+		if s.GeoJSON != "" {
+			schema.Decls = append(schema.Decls, h.geoJSON(schema)...)
+		}
+		if s.LegacyCoords == LegacyCoordsPoint && (s.Format == "" || s.Format == FormatGo) {
+			schema.Decls = append(schema.Decls, h.legacyPoint(schema)...)
+		}
+		if s.EpochTimes == EpochTimesTime {
+			schema.Decls = append(schema.Decls, h.epochs(schema)...)
+		}
+		schemas = append(schemas, schema)
+	}
+	if s.SharedStructs {
+		s.shareStructs(schemas)
+	}
+	if len(s.BaseFields) > 0 {
+		s.embedBase(schemas, h)
+	}
+	return schemas, nil
+}
+
+// rootReport collects what transformRoot reports of the roots of a
+// collection, its own and those of its variants.
+type rootReport struct {
+	mixed int
+	lint  []lintFinding
+}
+
+// transformRoot applies the transformations of the fields of c to root, the
+// type of its documents or of one of its variants, adding to r.
+func (s *Generator) transformRoot(root *StructType, c Collection, r *rootReport) error {
+	s.prune(root, c)
+	s.opaque(root, c)
+	if s.MinPresence != "" {
+		s.dropRare(root)
+	}
+	if s.Lint {
+		r.lint = append(r.lint, s.lint(root, c)...)
+	}
+	s.override(root, c)
+	if s.MaxDepth > 0 {
+		s.collapseDeep(root)
+	}
+	r.mixed += countFields(root, func(f *Field) bool {
+		_, ok := f.Type.(MixedType)
+		return ok
+	})
+	if len(s.OmitEmptyFields) > 0 {
+		s.markOmitEmpty(root, c)
+	}
+	if len(s.FieldNames) > 0 {
+		s.renameFields(root, c)
+	}
+	if s.EpochTimes != "" {
+		s.markEpochs(root)
+	}
+	if s.IndexComments {
+		s.annotateIndexes(root, c)
+	}
+	if s.LegacyCoords != "" {
+		s.legacyPoints(root, c)
+	}
+	if s.Tuples {
+		s.tuples(root)
+	}
+	if !s.NoFixedArrays {
+		s.fixedArrays(root)
+	}
+	return s.detectMaps(root)
+}
+
+// scanAll scans the collections cs, up to Concurrency of them in parallel.
+// The results are in the same order as cs, with nil for the collections not
+// started before ctx was done.
+func (s *Generator) scanAll(ctx context.Context, src Source, cs []Collection) ([]*StructType, error) {
+	workers := s.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	roots := make([]*StructType, len(cs))
+	errs := make([]error, len(cs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if ctx.Err() == nil {
+					roots[i], errs[i] = s.scanTimed(ctx, src, cs[i])
+				}
+			}
+		}()
+	}
+	for i := range cs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return roots, nil
+}
+
+// scan scans the documents of c, stopping early with those scanned so far if
+// ctx is done.
+func (s *Generator) scan(ctx context.Context, src Source, c Collection) (*StructType, error) {
+	root := newStructType()
+	logger := s.logger().With("collection", c.Name)
+	p := newScanProgress(logger)
+	if s.collectsDocStats() {
+		p.stats = &docStats{}
+	}
+	limit := s.limit(c)
+	part := c
+	for retry := 0; ; retry++ {
+		iter, err := src.Open(part)
+		if err != nil {
+			return nil, err
+		}
+		if s.DecodeWorkers > 1 {
+			err = s.scanPipelined(ctx, src, iter, c, root, limit, p)
+		} else {
+			err = s.scanCursor(ctx, src, iter, c, root, limit, p)
+		}
+		if err != nil {
+			iter.Close()
+			return nil, err
+		}
+		// Closing the iterator kills its cursor; once ctx is done, its
+		// error is likely caused by the interruption.
+		err = iter.Close()
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+		if !s.retries(src, retry) {
+			return nil, err
+		}
+		d := backoff(retry)
+		logger.Warn("scan failed, retrying", "error", err, "documents", p.seen, "backoff", d)
+		if !sleep(ctx, d) {
+			break
+		}
+		if r, ok := src.(redialer); ok {
+			r.redial()
+		}
+		if part, err = s.resume(c, p.lastID, p.seen); err != nil {
+			return nil, err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		logger.Warn("scan stopped early", append([]interface{}{"reason", err}, progress(p.seen, time.Since(p.start))...)...)
+	} else {
+		logger.Info("scanned", progress(p.seen, time.Since(p.start))...)
+	}
+	atomic.AddUint64(&s.scanned, uint64(p.seen))
+	s.runReport.addDocs(s.DB, c.Name, p.seen)
+	s.runReport.docStats(s.DB, c.Name, p.stats)
+	return root, nil
+}
+
+// scanCursor reads the documents of iter into root until limit, decoding
+// and merging each in turn.
+func (s *Generator) scanCursor(ctx context.Context, src Source, iter Iter, c Collection, root *StructType, limit uint, p *scanProgress) error {
+	for (limit == 0 || p.seen < limit) && ctx.Err() == nil {
+		t, id, size, ok, err := s.next(iter)
+		if err != nil {
+			return fmt.Errorf("mongoschema: decoding a document of %s: %s", c.Name, err)
+		}
+		if !ok {
+			break
+		}
+		if st, ok := t.(*StructType); ok && s.ConflictIDs && id != nil {
+			recordIDs(st, formatID(id), s)
+		}
+		s.mergeDoc(root, c, t)
+		p.merged(id, t, size)
+		if pc, ok := src.(pacer); ok && !pc.pace(ctx, p.seen) {
+			break
+		}
+	}
+	return nil
+}
+
+// next decodes the next document of iter and returns its type, _id and
+// size, which is only known when it is read as raw BSON. In low memory
+// mode, and to keep the order of the fields, the document is walked as raw
+// BSON rather than decoded into maps. To collect its size, it is decoded
+// from raw BSON, like mgo does, except for the json source.
+func (s *Generator) next(iter Iter) (Type, interface{}, int, bool, error) {
+	walk := s.LowMemory || s.FieldOrder == FieldOrderDocument
+	if walk || s.collectsDocStats() && s.Source != SourceJSON {
+		var raw bson.Raw
+		if !iter.Next(&raw) {
+			return nil, nil, 0, false, nil
+		}
+		if !walk {
+			m := bson.M{}
+			err := raw.Unmarshal(&m)
+			return NewType(m, s), m["_id"], len(raw.Data), err == nil, err
+		}
+		t, id, err := s.rawType(raw)
+		return t, id, len(raw.Data), err == nil, err
+	}
+	m := bson.M{}
+	if !iter.Next(m) {
+		return nil, nil, 0, false, nil
+	}
+	return NewType(m, s), m["_id"], 0, true, nil
+}
+
+// rawType walks the raw document raw and returns its type and _id.
+func (s *Generator) rawType(raw bson.Raw) (Type, interface{}, error) {
+	t, _, err := rawDocument(raw, s)
+	if err != nil {
+		return nil, nil, err
+	}
+	var id struct {
+		ID interface{} `bson:"_id"`
+	}
+	if err := raw.Unmarshal(&id); err != nil {
+		return nil, nil, err
+	}
+	return t, id.ID, nil
+}
+
+// progressInterval is how often scan logs its progress in verbose mode.
+const progressInterval = 2 * time.Second
+
+// logger returns a structured logger writing to stderr. Only warnings are
+// logged by default, progress too in verbose mode and nothing in quiet mode.
+func (s *Generator) logger() *slog.Logger {
+	level := slog.LevelWarn
+	switch {
+	case s.Quiet:
+		level = slog.LevelError + 1
+	case s.Verbose:
+		level = slog.LevelInfo
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+}
+
+func progress(docs uint, elapsed time.Duration) []interface{} {
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(docs) / elapsed.Seconds()
+	}
+	return []interface{}{
+		"docs", docs,
+		"elapsed", elapsed.Round(time.Millisecond),
+		"rate", fmt.Sprintf("%.0f docs/s", rate),
+	}
+}
+
+// sampling returns the sampling strategy for c.
+func (s *Generator) sampling(c Collection) string {
+	if c.Sampling != "" {
+		return c.Sampling
+	}
+	if s.Sampling != "" {
+		return s.Sampling
+	}
+	return SamplingNatural
+}
+
+// maxTime returns the time limit of each query, zero for none. The server
+// counts it over every getMore of a cursor, so it also bounds each of them.
+func (s *Generator) maxTime() time.Duration {
+	return time.Duration(s.MaxTimeMS) * time.Millisecond
+}
+
+// pipe opens a cursor over the output of pipeline on collection. mgo's Pipe
+// cannot set maxTimeMS, so with MaxTimeMS the aggregate command is run
+// directly and its cursor iterated from the first batch.
+func (s *Generator) pipe(collection *mgo.Collection, pipeline []bson.M, allowDiskUse bool) *mgo.Iter {
+	if s.MaxTimeMS == 0 {
+		p := collection.Pipe(pipeline)
+		if allowDiskUse {
+			p = p.AllowDiskUse()
+		}
+		return p.Iter()
+	}
+	cmd := bson.D{
+		{Name: "aggregate", Value: collection.Name},
+		{Name: "pipeline", Value: pipeline},
+		{Name: "cursor", Value: bson.M{}},
+		{Name: "maxTimeMS", Value: s.MaxTimeMS},
+	}
+	if allowDiskUse {
+		cmd = append(cmd, bson.DocElem{Name: "allowDiskUse", Value: true})
+	}
+	var result struct {
+		Cursor struct {
+			FirstBatch []bson.Raw `bson:"firstBatch"`
+			ID         int64      `bson:"id"`
+		}
+	}
+	err := collection.Database.Run(cmd, &result)
+	return collection.NewIter(nil, result.Cursor.FirstBatch, result.Cursor.ID, err)
+}
+
+// query returns an iterator over the documents to sample from collection.
+func (s *Generator) query(collection *mgo.Collection, c Collection) (Iter, error) {
+	filter, err := toBSON(c.Filter)
+	if err != nil {
+		return nil, fmt.Errorf("mongoschema: invalid filter for collection %s: %s", c.Name, err)
+	}
+	projection, err := toBSON(c.Projection)
+	if err != nil {
+		return nil, fmt.Errorf("mongoschema: invalid projection for collection %s: %s", c.Name, err)
+	}
+	sampling := s.sampling(c)
+	if len(c.Sort) > 0 && sampling != SamplingNatural {
+		return nil, fmt.Errorf("mongoschema: sort requires natural sampling for collection %s", c.Name)
+	}
+	if c.Pipeline != nil {
+		return s.aggregate(collection, c)
+	}
+	switch sampling {
+	case SamplingNatural:
+		q := collection.Find(filter).SetMaxTime(s.maxTime())
+		if projection != nil {
+			q = q.Select(projection)
+		}
+		switch {
+		case len(c.Sort) > 0:
+			q = q.Sort(c.Sort...).Skip(int(c.skip))
+		case s.Retries > 0:
+			// A scan in _id order can resume after the last _id seen.
+			q = q.Sort("_id")
+		}
+		return q.Iter(), nil
+	case SamplingRandom:
+		size := s.limit(c)
+		if size == 0 {
+			size = defaultSampleSize
+		}
+		var pipeline []bson.M
+		if filter != nil {
+			pipeline = append(pipeline, bson.M{"$match": filter})
+		}
+		pipeline = append(pipeline, bson.M{"$sample": bson.M{"size": size}})
+		if projection != nil {
+			pipeline = append(pipeline, bson.M{"$project": projection})
+		}
+		return s.pipe(collection, pipeline, false), nil
+	case SamplingSmart:
+		size := s.limit(c)
+		if size == 0 {
+			size = defaultSampleSize
+		}
+		return s.smartSample(collection, filter, projection, size), nil
+	}
+	return nil, fmt.Errorf("mongoschema: unknown sampling %q for collection %s", sampling, c.Name)
+}
+
 type Type interface {
 	GoType(gen *Generator) string
 	Merge(t Type, gen *Generator) Type
@@ -126,13 +1096,72 @@ func (l LiteralType) Merge(t Type, gen *Generator) Type {
 	if l.GoType(gen) == t.GoType(gen) {
 		return l
 	}
-	return MixedType{l, t}
+	return newMixedType(gen, l, t)
 }
 
 var NilType = LiteralType{Literal: "nil"}
 
 type MixedType []Type
 
+// newMixedType returns the canonical mix of ts. Mixed types are flattened,
+// members that can be merged are, and the rest are sorted by Go type, so that
+// the result does not depend on the order in which documents were seen.
+func newMixedType(gen *Generator, ts ...Type) Type {
+	var m MixedType
+	for _, t := range ts {
+		if o, ok := t.(MixedType); ok {
+			for _, e := range o {
+				m = m.add(e, gen)
+			}
+		} else {
+			m = m.add(t, gen)
+		}
+	}
+	if len(m) == 1 {
+		return m[0]
+	}
+	sort.SliceStable(m, func(i, j int) bool {
+		return m[i].GoType(gen) < m[j].GoType(gen)
+	})
+	return m
+}
+
+func (m MixedType) add(t Type, gen *Generator) MixedType {
+	if isNil(t) {
+		return m
+	}
+	for i, e := range m {
+		if mergeable(e, t, gen) {
+			m[i] = e.Merge(t, gen)
+			return m
+		}
+	}
+	return append(m, t)
+}
+
+// mergeable reports whether a and b merge into a single type rather than a
+// MixedType.
+func mergeable(a, b Type, gen *Generator) bool {
+	switch a := a.(type) {
+	case *StructType:
+		_, ok := b.(*StructType)
+		return ok
+	case SliceType:
+		_, ok := b.(SliceType)
+		return ok
+	case MapType:
+		_, ok := b.(MapType)
+		return ok
+	case PrimitiveType:
+		if b, ok := b.(PrimitiveType); ok {
+			_, ok := a.widen(b, gen)
+			return ok
+		}
+		return false
+	}
+	return a.GoType(gen) == b.GoType(gen)
+}
+
 func (m MixedType) GoType(gen *Generator) string {
 	if !gen.Comments {
 		return "interface{}"
@@ -142,21 +1171,15 @@ func (m MixedType) GoType(gen *Generator) string {
 	for i, v := range m {
 		fmt.Fprint(&b, v.GoType(gen))
 		if i != len(m)-1 {
-			fmt.Fprint(&b, ",")
+			fmt.Fprint(&b, ", ")
 		}
-		fmt.Fprint(&b, " ")
 	}
 	fmt.Fprint(&b, " */")
 	return b.String()
 }
 
 func (m MixedType) Merge(t Type, gen *Generator) Type {
-	for _, e := range m {
-		if e.GoType(gen) == t.GoType(gen) {
-			return m
-		}
-	}
-	return append(m, t)
+	return newMixedType(gen, m, t)
 }
 
 type PrimitiveType uint
@@ -171,6 +1194,16 @@ const (
 	PrimitiveString
 	PrimitiveTimestamp
 	PrimitiveDBRef
+	PrimitiveBytes
+	PrimitiveDecimal128
+	PrimitiveRegEx
+	PrimitiveJavaScript
+	PrimitiveDBPointer
+	PrimitiveSymbol
+	PrimitiveMongoTimestamp
+	PrimitiveMinKey
+	PrimitiveMaxKey
+	PrimitiveUUID
 )
 
 func (p PrimitiveType) GoType(gen *Generator) string {
@@ -193,6 +1226,29 @@ func (p PrimitiveType) GoType(gen *Generator) string {
 		return "bson.ObjectId"
 	case PrimitiveDBRef:
 		return "mgo.DBRef"
+	case PrimitiveBytes:
+		return "[]byte"
+	case PrimitiveDecimal128:
+		return "bson.Decimal128"
+	case PrimitiveRegEx:
+		return "bson.RegEx"
+	case PrimitiveJavaScript:
+		return "bson.JavaScript"
+	case PrimitiveDBPointer:
+		return "bson.DBPointer"
+	case PrimitiveSymbol:
+		return "bson.Symbol"
+	case PrimitiveMongoTimestamp:
+		return "bson.MongoTimestamp"
+	case PrimitiveMinKey, PrimitiveMaxKey:
+		// MinKey and MaxKey decode to an unexported bson type.
+		return "interface{}"
+	case PrimitiveUUID:
+		if gen.UUIDType == "" {
+			return "bson.Binary"
+		}
+		t, _ := qualifiedType(gen.UUIDType)
+		return t
 	}
 	panic(fmt.Sprintf("unknown primitive: %d", uint(p)))
 }
@@ -204,23 +1260,60 @@ func (p PrimitiveType) Merge(t Type, gen *Generator) Type {
 	if isNil(t) {
 		return p
 	}
-	switch p {
-	case PrimitiveInt32, PrimitiveInt64:
-		if t == PrimitiveDouble {
-			return PrimitiveDouble
+	if o, ok := t.(PrimitiveType); ok {
+		if w, ok := p.widen(o, gen); ok {
+			return w
 		}
 	}
-	switch t {
-	case PrimitiveInt32, PrimitiveInt64:
-		if p == PrimitiveDouble {
-			return PrimitiveDouble
+	return newMixedType(gen, p, t)
+}
+
+const (
+	IntPolicyInt64    = "int64"
+	IntPolicyPreserve = "preserve"
+	IntPolicyObserved = "observed"
+)
+
+// intType returns the type of the integer v, which was stored as the BSON
+// type width. By default every integer is an int64; IntPolicy can instead
+// preserve the BSON width, or pick the narrowest width that holds the
+// observed values. Merging an int32 with an int64 widens it either way.
+func (gen *Generator) intType(v int64, width PrimitiveType) PrimitiveType {
+	switch gen.IntPolicy {
+	case IntPolicyPreserve:
+		return width
+	case IntPolicyObserved:
+		if v >= math.MinInt32 && v <= math.MaxInt32 {
+			return PrimitiveInt32
 		}
 	}
+	return PrimitiveInt64
+}
 
-	if p.GoType(gen) == t.GoType(gen) {
-		return p
+// widen returns the primitive that can hold values of both p and o.
+func (p PrimitiveType) widen(o PrimitiveType, gen *Generator) (PrimitiveType, bool) {
+	switch p {
+	case PrimitiveInt32, PrimitiveInt64:
+		if o == PrimitiveDouble {
+			return PrimitiveDouble, true
+		}
+		if p != o && (o == PrimitiveInt32 || o == PrimitiveInt64) {
+			return PrimitiveInt64, true
+		}
+	case PrimitiveDouble:
+		if o == PrimitiveInt32 || o == PrimitiveInt64 {
+			return PrimitiveDouble, true
+		}
+	case PrimitiveUUID, PrimitiveBinary:
+		// Binary fields that are only sometimes UUIDs stay raw binary.
+		if p != o && (o == PrimitiveUUID || o == PrimitiveBinary) {
+			return PrimitiveBinary, true
+		}
+	}
+	if p.GoType(gen) == o.GoType(gen) {
+		return p, true
 	}
-	return MixedType{p, t}
+	return p, false
 }
 
 type SliceType struct {
@@ -238,97 +1331,524 @@ func (s SliceType) Merge(t Type, gen *Generator) Type {
 	if isNil(t) {
 		return s
 	}
-	if s.GoType(gen) == t.GoType(gen) {
-		return s
+
+	// Slices merge element-wise, so a slice of structs merges into the struct
+	// (or the struct member of the mixed type) in our own slice type.
+	if o, ok := t.(SliceType); ok {
+		return SliceType{Type: s.Type.Merge(o.Type, gen)}
 	}
+	return newMixedType(gen, s, t)
+}
 
-	// If the target type is a slice of structs, we merge into the first struct
-	// type in our own slice type.
-	if targetSliceType, ok := t.(SliceType); ok {
-		if targetSliceStructType, ok := targetSliceType.Type.(StructType); ok {
-			// We're a slice of structs.
-			if ownSliceStructType, ok := s.Type.(StructType); ok {
-				s.Type = ownSliceStructType.Merge(targetSliceStructType, gen)
-				return s
-			}
+// NamedType refers to a struct type that is declared separately under Name.
+type NamedType struct {
+	Name string
+	Type Type
+}
 
-			// We're a slice of mixed types, one of which may or may not be a struct.
-			if sliceMixedType, ok := s.Type.(MixedType); ok {
-				for i, v := range sliceMixedType {
-					if vStructType, ok := v.(StructType); ok {
-						sliceMixedType[i] = vStructType.Merge(targetSliceStructType, gen)
-						return s
-					}
-				}
-				return SliceType{Type: append(sliceMixedType, targetSliceStructType)}
-			}
+func (n NamedType) GoType(gen *Generator) string {
+	return n.Name
+}
+
+func (n NamedType) Merge(t Type, gen *Generator) Type {
+	if isNil(t) {
+		return n
+	}
+	if n.GoType(gen) == t.GoType(gen) {
+		return n
+	}
+	return newMixedType(gen, n, t)
+}
+
+// MapType is a document whose keys are data, such as user IDs or dates,
+// rather than field names.
+type MapType struct {
+	Value Type
+}
+
+func (m MapType) GoType(gen *Generator) string {
+	return "map[string]" + m.Value.GoType(gen)
+}
+
+func (m MapType) Merge(t Type, gen *Generator) Type {
+	if isNil(t) {
+		return m
+	}
+	if o, ok := t.(MapType); ok {
+		m.Value = m.Value.Merge(o.Value, gen)
+		return m
+	}
+	return newMixedType(gen, m, t)
+}
+
+// Field is a member of a StructType. Count is the number of documents the
+// field was present in.
+type Field struct {
+	Type  Type
+	Count uint
+	// Values counts the distinct values of a string field, up to
+	// EnumThreshold of them. It is nil if there were more, or if the field
+	// ever held something other than a string.
+	Values map[string]uint
+	// Examples are up to Generator.Examples distinct scalar values of the
+	// field, formatted as Go literals.
+	Examples []string
+	// Refs counts the collections referenced by a DBRef field, or by the
+	// DBRefs in a slice field.
+	Refs map[string]uint
+	// Tuple holds the types at each position of an array field, if it always
+	// held arrays of the same, short length.
+	Tuple []Type
+	// Elems counts the Go types of the elements of an array field.
+	Elems map[string]uint
+	// ArrayLen is the length of the arrays of the field if it was always
+	// the same, zero if it held none and -1 if it varied or was zero.
+	ArrayLen int
+	// Min and Max are the range of the numbers seen in a field, collected
+	// for Validate methods and for detecting Unix times.
+	Min, Max *float64
+	// Nulls counts the documents in which the field was null, which are
+	// included in Count.
+	Nulls uint
+	// NumericStrings counts the documents in which the field was a string
+	// holding a number, for Lint.
+	NumericStrings uint
+	// Order is the position at which the field was first seen, starting at
+	// one, or zero if it is not known.
+	Order uint
+	// Indexes describes the indexes that include the field.
+	Indexes []string
+	// TypeIDs maps the Go types the field held, with nested structs left
+	// out, to the _id of the first document in which it held each, if
+	// ConflictIDs is set.
+	TypeIDs map[string]string
+
+	// omitEmpty, if set, overrides whether the field is tagged omitempty.
+	omitEmpty *bool
+	// name, if set, overrides the Go name of the field.
+	name string
+	// epoch is the unit of the Unix times the field holds, if EpochTimes
+	// is set and it holds them.
+	epoch string
+	// collapsed is set if the documents the field holds were nested too
+	// deep to be typed.
+	collapsed bool
+	// str is the string held by the field of a single document, which
+	// tells its variant when the field is a discriminator.
+	str string
+}
+
+// newField returns the field for a single value v of type t.
+func newField(v interface{}, t Type, gen *Generator) *Field {
+	f := &Field{Type: t, Count: 1}
+	if str, ok := v.(string); ok && gen.EnumThreshold > 0 {
+		f.Values = map[string]uint{str: 1}
+	}
+	if str, ok := v.(string); ok {
+		f.str = str
+	}
+	if str, ok := v.(string); ok && gen.Lint && isNumeric(str) {
+		f.NumericStrings = 1
+	}
+	if gen.Examples > 0 {
+		if e, ok := example(v); ok {
+			f.Examples = []string{e}
 		}
 	}
-	return MixedType{s, t}
+	f.Refs = dbrefTargets(v)
+	f.Tuple, f.Elems = arrayStats(v, gen)
+	f.ArrayLen = arrayLen(v)
+	if n, ok := number(v); ok && (gen.ValidateMethods || gen.EpochTimes != "" || gen.Lint) {
+		f.Min, f.Max = &n, &n
+	}
+	return f
 }
 
-type StructType map[string]Type
+// nullField returns the field for a single null value. Its Values are empty
+// rather than nil, which would discard those of the field it is merged with.
+func nullField(gen *Generator) *Field {
+	f := &Field{Type: NilType, Count: 1, Nulls: 1}
+	if gen.EnumThreshold > 0 {
+		f.Values = map[string]uint{}
+	}
+	return f
+}
 
-func (s StructType) GoType(gen *Generator) string {
-	var buf bytes.Buffer
-	fmt.Fprintln(&buf, "struct {")
-	var keys sort.StringSlice
-	for k := range s {
+// maxExampleLen is the length at which example strings are truncated.
+const maxExampleLen = 40
+
+// example formats a scalar value for an example comment.
+func example(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		if r := []rune(v); len(r) > maxExampleLen {
+			return strconv.Quote(string(r[:maxExampleLen])) + "...", true
+		}
+		return strconv.Quote(v), true
+	case bool, int, int32, int64, float64:
+		return fmt.Sprint(v), true
+	case time.Time:
+		return v.UTC().Format(time.RFC3339), true
+	case bson.ObjectId:
+		return strconv.Quote(v.Hex()), true
+	}
+	return "", false
+}
+
+func (f *Field) merge(o *Field, gen *Generator) {
+	f.Type = f.Type.Merge(o.Type, gen)
+	f.Count += o.Count
+	f.Nulls += o.Nulls
+	f.NumericStrings += o.NumericStrings
+	if f.Values != nil && o.Values != nil {
+		for v, n := range o.Values {
+			f.Values[v] += n
+		}
+		if len(f.Values) > gen.EnumThreshold {
+			f.Values = nil
+		}
+	} else {
+		f.Values = nil
+	}
+	f.Tuple = mergeTuple(f.Tuple, o.Tuple, gen)
+	f.ArrayLen = mergeArrayLen(f.ArrayLen, o.ArrayLen)
+	if o.Min != nil && (f.Min == nil || *o.Min < *f.Min) {
+		f.Min = o.Min
+	}
+	if o.Max != nil && (f.Max == nil || *o.Max > *f.Max) {
+		f.Max = o.Max
+	}
+	for t, n := range o.Elems {
+		if f.Elems == nil {
+			f.Elems = map[string]uint{}
+		}
+		f.Elems[t] += n
+	}
+	for t, id := range o.TypeIDs {
+		if f.TypeIDs == nil {
+			f.TypeIDs = map[string]string{}
+		}
+		if _, ok := f.TypeIDs[t]; !ok {
+			f.TypeIDs[t] = id
+		}
+	}
+	for ref, n := range o.Refs {
+		if f.Refs == nil {
+			f.Refs = map[string]uint{}
+		}
+		f.Refs[ref] += n
+	}
+	for _, e := range o.Examples {
+		if len(f.Examples) >= gen.Examples {
+			break
+		}
+		if !sscontains(f.Examples, e) {
+			f.Examples = append(f.Examples, e)
+		}
+	}
+}
+
+// StructType is the type of a document. Count is the number of documents
+// merged into it, which is the denominator for the presence of its fields.
+type StructType struct {
+	Fields map[string]*Field
+	Count  uint
+
+	// rare lists the fields dropped for being rarer than min_presence,
+	// with their counts.
+	rare []string
+	// embed is the struct of the base fields, embedded in place of them.
+	embed *NamedType
+	// goNames caches the Go names of the fields, by key.
+	goNames map[string]string
+	// variants are the types of the documents of a collection with a
+	// discriminator, by its value.
+	variants map[string]*StructType
+	// synthetic is set on structs made up rather than inferred, like those
+	// of GeoJSON geometries, whose counts are no stats of any documents.
+	synthetic bool
+}
+
+func newStructType() *StructType {
+	return &StructType{Fields: map[string]*Field{}}
+}
+
+// keys returns the sorted names of the fields that are not ignored.
+func (s *StructType) keys(gen *Generator) []string {
+	var keys []string
+	for k := range s.Fields {
 		if sscontains(gen.IgnoredFields, k) {
 			continue
 		}
 		keys = append(keys, k)
 	}
-	sort.Sort(keys)
+	sort.Slice(keys, func(i, j int) bool {
+		return s.less(keys[i], keys[j], gen.FieldOrder)
+	})
+	return keys
+}
+
+const (
+	FieldOrderAlpha    = "alpha"
+	FieldOrderPresence = "presence"
+	FieldOrderDocument = "document"
+	FieldOrderIDFirst  = "id_first"
+	FieldOrderRequired = "required_first"
+)
 
-	for _, k := range keys {
-		v := s[k]
+// less reports whether the field named a comes before b in the field order.
+// Fields that the order does not tell apart are sorted by name.
+func (s *StructType) less(a, b, order string) bool {
+	fa, fb := s.Fields[a], s.Fields[b]
+	switch order {
+	case FieldOrderPresence:
+		if fa.Count != fb.Count {
+			return fa.Count > fb.Count
+		}
+	case FieldOrderDocument:
+		// Fields of unknown position, like those of documents in arrays,
+		// come last.
+		if fa.Order != fb.Order {
+			return fb.Order == 0 || fa.Order != 0 && fa.Order < fb.Order
+		}
+	case FieldOrderIDFirst:
+		if (a == "_id") != (b == "_id") {
+			return a == "_id"
+		}
+	case FieldOrderRequired:
+		if s.required(a) != s.required(b) {
+			return s.required(a)
+		}
+	}
+	return a < b
+}
+
+// required reports whether the field named k was present in every document.
+func (s *StructType) required(k string) bool {
+	return s.Fields[k].Count == s.Count
+}
+
+func (s *StructType) GoType(gen *Generator) string {
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "struct {")
+	if s.embed != nil {
+		fmt.Fprintf(&buf, "%s `bson:\",inline\"`\n", s.embed.Name)
+	}
+	for _, k := range s.keys(gen) {
 		if isValidFieldName(k) {
-			vGoType := v.GoType(gen)
+			if gen.DocComments {
+				gen.writeFieldDoc(&buf, s, k)
+			}
+			vGoType, omitempty := gen.fieldType(s, k)
 			fmt.Fprintf(
 				&buf,
-				"%s %s `bson:\"%s,omitempty\" json:\"%s,omitempty\"`\n",
-				makeFieldName(k),
+				"%s %s %s",
+				gen.goFieldName(s, k),
 				vGoType,
-				k, k,
+				gen.structTag(k, omitempty),
 			)
+			var comments []string
+			if gen.PresenceComments && !s.synthetic {
+				comments = append(comments, s.presence(k))
+			}
+			if f := s.Fields[k]; gen.NullFields && f.Nulls > 0 {
+				comments = append(comments, fmt.Sprintf("null in %d/%d", f.Nulls, s.Count))
+			}
+			if examples := s.Fields[k].Examples; len(examples) > 0 {
+				comments = append(comments, "e.g. "+strings.Join(examples, ", "))
+			}
+			if f := s.Fields[k]; gen.IndexComments && len(f.Indexes) > 0 {
+				comments = append(comments, strings.Join(f.Indexes, ", "))
+			}
+			if isSanitized(k) {
+				comments = append(comments, fmt.Sprintf("key %q", k))
+			}
+			if f := s.Fields[k]; gen.EpochTimes == EpochTimesComment && f.epoch != "" {
+				comments = append(comments, epochRange(f))
+			}
+			if s.Fields[k].collapsed {
+				comments = append(comments, fmt.Sprintf("documents nested beyond max_depth %d", gen.MaxDepth))
+			}
+			if isLegacyPoint(s.Fields[k].Type) {
+				comments = append(comments, "legacy coordinate pair [lon, lat]")
+			}
+			if t, ok := s.Fields[k].Type.(TupleType); ok {
+				if _, uniform := t.uniform(gen); !uniform {
+					comments = append(comments, t.positions(gen))
+				}
+			}
+			if f := s.Fields[k]; gen.ElementComments && len(f.Elems) > 0 {
+				comments = append(comments, elementStats(f))
+			}
+			if f := s.Fields[k]; gen.ConflictIDs && len(f.TypeIDs) > 1 {
+				comments = append(comments, conflictIDs(f))
+			}
+			if len(comments) > 0 {
+				fmt.Fprintf(&buf, " // %s", strings.Join(comments, "; "))
+			}
+			fmt.Fprintln(&buf)
 		} else {
 			if gen.Comments {
 				fmt.Fprintf(&buf, "// skipping invalid field name %s\n", k)
 			}
 		}
 	}
+	if gen.RareComments && len(s.rare) > 0 {
+		fmt.Fprintf(&buf, "// rare fields omitted: %s\n", strings.Join(s.rare, ", "))
+	}
 	fmt.Fprint(&buf, "}")
 	return buf.String()
 }
 
-func (s StructType) Merge(t Type, gen *Generator) Type {
+// order places the fields added from o after the fields of s seen before, in
+// their order in o.
+func (s *StructType) order(added []string, o *StructType) {
+	sort.Slice(added, func(i, j int) bool {
+		return o.less(added[i], added[j], FieldOrderDocument)
+	})
+	var last uint
+	for k, f := range s.Fields {
+		if f.Order > last && !sscontains(added, k) {
+			last = f.Order
+		}
+	}
+	for i, k := range added {
+		if o.Fields[k].Order != 0 {
+			s.Fields[k].Order = last + uint(i) + 1
+		}
+	}
+}
+
+// presence describes how often the field named k was seen.
+func (s *StructType) presence(k string) string {
+	f := s.Fields[k]
+	return fmt.Sprintf("%.1f%% (%d/%d)", 100*float64(f.Count)/float64(s.Count), f.Count, s.Count)
+}
+
+func (s *StructType) Merge(t Type, gen *Generator) Type {
 	if isNil(t) {
 		return s
 	}
-	if isNil(s) {
-		return t
-	}
-	if o, ok := t.(StructType); ok {
-		for k, v := range o {
-			if e, ok := s[k]; ok {
-				s[k] = e.Merge(v, gen)
+	if o, ok := t.(*StructType); ok {
+		var added []string
+		for k, f := range o.Fields {
+			if e, ok := s.Fields[k]; ok {
+				e.merge(f, gen)
 			} else {
-				s[k] = v
+				s.Fields[k] = f
+				added = append(added, k)
 			}
 		}
+		if gen.FieldOrder == FieldOrderDocument {
+			s.order(added, o)
+		}
+		for v, vt := range o.variants {
+			s.mergeVariant(v, vt, gen)
+		}
+		s.Count += o.Count
 		return s
 	}
-	return MixedType{s, t}
+	return newMixedType(gen, s, t)
+}
+
+// fieldType returns the Go type of the field named k of s, and whether it is
+// tagged omitempty, as decided by the omitempty settings.
+func (gen *Generator) fieldType(s *StructType, k string) (string, bool) {
+	goType, omitempty := gen.inferFieldType(s, k)
+	return goType, gen.omitEmpty(s, k, omitempty)
+}
+
+// inferFieldType returns the Go type of the field named k of s, and whether
+// it is tagged omitempty by default. With InferOptional, required fields are
+// not omitempty and optional ones are pointers or sql.Null wrappers,
+// depending on OptionalStyle.
+func (gen *Generator) inferFieldType(s *StructType, k string) (string, bool) {
+	t := s.Fields[k].Type
+	// A field that was only ever null has no type to infer.
+	if t == NilType {
+		return "bson.Raw", true
+	}
+	nullable := gen.NullFields && s.Fields[k].Nulls > 0
+	if !gen.InferOptional && !nullable {
+		return t.GoType(gen), true
+	}
+	if s.required(k) && !nullable {
+		return t.GoType(gen), false
+	}
+	if p, ok := t.(PrimitiveType); ok && gen.OptionalStyle == OptionalNull {
+		if n, ok := nullTypes[p]; ok {
+			return n, true
+		}
+	}
+	if canPoint(t) {
+		return "*" + t.GoType(gen), true
+	}
+	return t.GoType(gen), true
+}
+
+const (
+	OptionalPointer = "pointer"
+	OptionalNull    = "null"
+)
+
+// nullTypes are the database/sql wrappers of the primitives that have one.
+var nullTypes = map[PrimitiveType]string{
+	PrimitiveBool:      "sql.NullBool",
+	PrimitiveDouble:    "sql.NullFloat64",
+	PrimitiveInt32:     "sql.NullInt32",
+	PrimitiveInt64:     "sql.NullInt64",
+	PrimitiveString:    "sql.NullString",
+	PrimitiveTimestamp: "sql.NullTime",
+}
+
+// canPoint reports whether an optional field of type t should be a pointer.
+// Slices and interfaces already have a nil value.
+func canPoint(t Type) bool {
+	switch t.(type) {
+	case SliceType, MixedType:
+		return false
+	}
+	return true
+}
+
+const (
+	UnknownTypesRaw       = "raw"
+	UnknownTypesInterface = "interface"
+)
+
+// warnedTypes holds the Go types of the values that unknownType has already
+// warned about, so that a collection full of them logs one warning.
+var warnedTypes sync.Map
+
+// unknownType returns the type of a value v of a Go type that NewType does
+// not recognize, which is bson.Raw or interface{} depending on UnknownTypes,
+// so that a single odd value does not stop the scan.
+func (gen *Generator) unknownType(v interface{}) Type {
+	t := LiteralType{Literal: "bson.Raw"}
+	if gen.UnknownTypes == UnknownTypesInterface {
+		t = LiteralType{Literal: "interface{}"}
+	}
+	goType := fmt.Sprintf("%T", v)
+	if _, warned := warnedTypes.LoadOrStore(goType, true); !warned {
+		gen.logger().Warn("cannot determine the type of a value", "go_type", goType, "value", fmt.Sprint(v), "fallback", t.Literal)
+	}
+	return t
 }
 
 func NewType(v interface{}, gen *Generator) Type {
 	switch i := v.(type) {
 	default:
+		switch v {
+		case bson.MinKey:
+			return PrimitiveMinKey
+		case bson.MaxKey:
+			return PrimitiveMaxKey
+		}
+		// bson.Undefined
 		if fmt.Sprint(v) == "{}" {
 			return NilType
 		}
-		panic(fmt.Sprintf("cannot determine type for %v with go type %T", v, v))
+		return gen.unknownType(v)
 	case nil:
 		return NilType
 	case bson.ObjectId:
@@ -348,60 +1868,231 @@ func NewType(v interface{}, gen *Generator) Type {
 			if s == nil {
 				s = SliceType{Type: vt}
 			} else {
-				s.Merge(SliceType{Type: vt}, gen)
+				s = s.Merge(SliceType{Type: vt}, gen)
 			}
 		}
 		if s == nil {
 			return SliceType{Type: NilType}
 		}
 		return s
-	case int, int64:
-		return PrimitiveInt64
+	case int:
+		// mgo decodes BSON int32 to int.
+		return gen.intType(int64(i), PrimitiveInt32)
+	case int64:
+		return gen.intType(i, PrimitiveInt64)
 	case int32:
-		return PrimitiveInt32
+		return gen.intType(int64(i), PrimitiveInt32)
 	case bool:
 		return PrimitiveBool
 	case string:
 		return PrimitiveString
-	case time.Time, bson.MongoTimestamp:
+	case time.Time:
 		return PrimitiveTimestamp
+	case bson.MongoTimestamp:
+		return PrimitiveMongoTimestamp
 	case float32, float64:
 		return PrimitiveDouble
 	case bson.Binary:
+		if (i.Kind == 0x03 || i.Kind == 0x04) && len(i.Data) == 16 {
+			return PrimitiveUUID
+		}
 		return PrimitiveBinary
+	case []byte:
+		return PrimitiveBytes
+	case bson.Decimal128:
+		return PrimitiveDecimal128
+	case bson.RegEx:
+		return PrimitiveRegEx
+	case bson.JavaScript:
+		return PrimitiveJavaScript
+	case bson.DBPointer:
+		return PrimitiveDBPointer
+	case bson.Symbol:
+		return PrimitiveSymbol
 	}
 }
 
 func NewStructType(m bson.M, gen *Generator) Type {
-	if m["$db"] != nil && m["$ref"] != nil && m["$id"] != nil {
+	if isDBRef(m) {
 		return PrimitiveDBRef
 	}
-	s := StructType{}
+	if gen.GeoJSON != "" {
+		if shape, ok := geoJSONShape(m); ok {
+			return GeoJSONType{Shape: shape}
+		}
+	}
+	s := newStructType()
+	s.Count = 1
 	for k, v := range m {
 		t := NewType(v, gen)
+		if v == nil && gen.tracksNulls() {
+			s.Fields[k] = nullField(gen)
+			continue
+		}
 		if isNil(t) {
 			continue
 		}
-		s[k] = t
+		s.Fields[k] = newField(v, t, gen)
 	}
 	return s
 }
 
-func isValidFieldName(n string) bool {
-	if n == "" {
-		return false
+// detectMaps replaces the nested structs of root that look like they have
+// dynamic keys with a MapType, whose value is the merge of all their fields.
+// A struct is a map if it has at least MapThreshold fields, or if all its
+// keys match MapKeyPattern.
+func (s *Generator) detectMaps(root *StructType) error {
+	if s.MapThreshold == 0 && s.MapKeyPattern == "" {
+		return nil
 	}
-	if strings.IndexAny(n, "!*") == -1 {
+	var pattern *regexp.Regexp
+	if s.MapKeyPattern != "" {
+		var err error
+		if pattern, err = regexp.Compile(s.MapKeyPattern); err != nil {
+			return fmt.Errorf("mongoschema: invalid map_key_pattern: %s", err)
+		}
+	}
+	isMap := func(st *StructType) bool {
+		if len(st.Fields) == 0 {
+			return false
+		}
+		if s.MapThreshold > 0 && len(st.Fields) >= s.MapThreshold {
+			return true
+		}
+		if pattern == nil {
+			return false
+		}
+		for k := range st.Fields {
+			if !pattern.MatchString(k) {
+				return false
+			}
+		}
 		return true
 	}
-	return false
+	var replace func(t Type) Type
+	replace = func(t Type) Type {
+		switch v := t.(type) {
+		case *StructType:
+			for _, f := range v.Fields {
+				f.Type = replace(f.Type)
+			}
+			if !isMap(v) {
+				return v
+			}
+			var value Type = NilType
+			for _, k := range v.keys(s) {
+				value = value.Merge(v.Fields[k].Type, s)
+			}
+			return MapType{Value: value}
+		case SliceType:
+			return SliceType{Type: replace(v.Type)}
+		case MixedType:
+			for i, e := range v {
+				v[i] = replace(e)
+			}
+			return v
+		}
+		return t
+	}
+	for _, f := range root.Fields {
+		f.Type = replace(f.Type)
+	}
+	return nil
+}
+
+const (
+	StructNamingPath  = "path"
+	StructNamingField = "field"
+)
+
+// hoister replaces nested anonymous structs with NamedTypes, naming them after
+// their field path (UserAddressGeo) or just their field (Geo).
+type hoister struct {
+	gen   *Generator
+	names map[string]bool
+	decls []NamedType
+	// refTypes maps collections to the names of their typed DBRefs.
+	refTypes map[string]string
+	// geoTypes maps GeoJSON shapes to the names of their generated structs.
+	geoTypes map[string]string
+	// legacyName is the name of the generated LegacyPoint struct, once
+	// declared.
+	legacyName string
+	// epochNames maps whether Unix times are in milliseconds to the names
+	// of their generated structs.
+	epochNames map[bool]string
+}
+
+func newHoister(gen *Generator) *hoister {
+	h := &hoister{gen: gen, names: map[string]bool{}, refTypes: map[string]string{}, geoTypes: map[string]string{}, epochNames: map[bool]string{}}
+	for _, c := range gen.Collections {
+		h.names[c.Struct] = true
+	}
+	return h
+}
+
+// hoist names every struct nested in root and returns the new declarations in
+// the order they should be emitted.
+func (h *hoister) hoist(root *StructType, name string) []NamedType {
+	h.decls = nil
+	h.fields(root, name)
+	return h.decls
+}
+
+func (h *hoister) fields(s *StructType, name string) {
+	for _, k := range s.keys(h.gen) {
+		if !isValidFieldName(k) {
+			continue
+		}
+		fieldName := h.gen.goFieldName(s, k)
+		if h.gen.StructNaming != StructNamingField {
+			fieldName = name + fieldName
+		}
+		f := s.Fields[k]
+		f.Type = h.replace(f.Type, fieldName)
+	}
+}
+
+func (h *hoister) replace(t Type, name string) Type {
+	switch v := t.(type) {
+	case *StructType:
+		n := NamedType{Name: h.unique(name), Type: v}
+		h.decls = append(h.decls, n)
+		h.fields(v, n.Name)
+		return n
+	case SliceType:
+		return SliceType{Type: h.replace(v.Type, name)}
+	case MapType:
+		return MapType{Value: h.replace(v.Value, name+"Value")}
+	case MixedType:
+		for i, e := range v {
+			v[i] = h.replace(e, name)
+		}
+		return v
+	}
+	return t
+}
+
+func (h *hoister) unique(name string) string {
+	n := name
+	for i := 2; h.names[n]; i++ {
+		n = fmt.Sprintf("%s%d", name, i)
+	}
+	h.names[n] = true
+	return n
+}
+
+// isValidFieldName reports whether the key n can be written in a struct tag.
+// Other characters that are not valid in Go identifiers are dropped from the
+// name of the field.
+func isValidFieldName(n string) bool {
+	return n != "" && !strings.ContainsAny(n, "`,")
 }
 
 var (
 	dashUnderscoreReplacer = strings.NewReplacer("-", " ", "_", " ")
 	capsRe                 = regexp.MustCompile(`([A-Z])`)
 	spaceRe                = regexp.MustCompile(`(\w+)`)
-	forcedUpperCase        = map[string]bool{"id": true, "url": true, "api": true}
 )
 
 func split(str string) []string {
@@ -410,10 +2101,13 @@ func split(str string) []string {
 	return spaceRe.FindAllString(str, -1)
 }
 
-func makeFieldName(s string) string {
-	parts := split(s)
+// makeFieldName returns the exported Go name of the document key, with the
+// acronyms among its words in upper case.
+func (s *Generator) makeFieldName(key string) string {
+	acronyms := s.acronyms()
+	parts := split(key)
 	for i, part := range parts {
-		if forcedUpperCase[strings.ToLower(part)] {
+		if acronyms[strings.ToLower(part)] {
 			parts[i] = strings.ToUpper(part)
 		} else {
 			parts[i] = strings.Title(part)
@@ -422,17 +2116,30 @@ func makeFieldName(s string) string {
 	camel := strings.Join(parts, "")
 	runes := []rune(camel)
 	for i, c := range runes {
-		ok := unicode.IsLetter(c) || unicode.IsDigit(c)
-		if i == 0 {
-			ok = unicode.IsLetter(c)
-		}
-		if !ok {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) {
 			runes[i] = '_'
 		}
 	}
+	switch {
+	case len(runes) == 0:
+		return "Field"
+	case !unicode.IsUpper(runes[0]):
+		// Keys starting with a digit, or with a letter that has no upper
+		// case, would make an unexported field.
+		return "F" + string(runes)
+	}
 	return string(runes)
 }
 
+// isSanitized reports whether the Go name of the key k drops characters
+// other than the separators of words, such as the $ of $ref, the dot of a.b
+// or non-ASCII letters, which split does not keep.
+func isSanitized(k string) bool {
+	return strings.IndexFunc(k, func(r rune) bool {
+		return (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '_' && r != '-'
+	}) >= 0
+}
+
 func sscontains(l []string, v string) bool {
 	for _, e := range l {
 		if e == v {
@@ -450,7 +2157,7 @@ func isNil(t Type) bool {
 		return isNil(sliceType.Type)
 	}
 	if mixedType, ok := t.(MixedType); ok {
-		return len(mixedType) > 0
+		return len(mixedType) == 0
 	}
 	return t == nil
 }
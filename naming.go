@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// defaultAcronyms are the words written in upper case in Go names unless
+// Acronyms is configured.
+var defaultAcronyms = map[string]bool{"id": true, "url": true, "api": true}
+
+// acronyms returns the lower-case words written in upper case in Go names.
+func (s *Generator) acronyms() map[string]bool {
+	if len(s.Acronyms) == 0 {
+		return defaultAcronyms
+	}
+	acronyms := make(map[string]bool, len(s.Acronyms))
+	for _, a := range s.Acronyms {
+		acronyms[strings.ToLower(a)] = true
+	}
+	return acronyms
+}
+
+// goFieldName returns the Go name of the field k of st, as configured in
+// field_names or else derived from k.
+func (s *Generator) goFieldName(st *StructType, k string) string {
+	if name, ok := st.goNames[k]; ok && len(st.goNames) == len(st.Fields) {
+		return name
+	}
+	st.goNames = s.goFieldNames(st)
+	return st.goNames[k]
+}
+
+// goFieldNames returns the Go names of the fields of st by key. Keys that
+// would get the same name, like a.b and a_b, are told apart by a number
+// appended in the order of the keys.
+func (s *Generator) goFieldNames(st *StructType) map[string]string {
+	names := make(map[string]string, len(st.Fields))
+	used := map[string]bool{}
+	if st.embed != nil {
+		used[st.embed.Name] = true
+	}
+	var keys []string
+	for k, f := range st.Fields {
+		if f.name != "" {
+			names[k] = f.name
+			used[f.name] = true
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		name := s.makeFieldName(k)
+		for base, i := name, 2; used[name]; i++ {
+			name = fmt.Sprintf("%s%d", base, i)
+		}
+		used[name] = true
+		names[k] = name
+	}
+	return names
+}
+
+// renameFields records on the fields of root the names configured in
+// field_names for c, which are keyed like overrides.
+func (s *Generator) renameFields(root *StructType, c Collection) {
+	for key, name := range s.FieldNames {
+		if !strings.HasPrefix(key, c.Name+".") {
+			continue
+		}
+		path := strings.TrimPrefix(key, c.Name+".")
+		f := lookupField(root, strings.Split(path, "."))
+		if f == nil {
+			s.logger().Warn("renamed field not found", "collection", c.Name, "field", path)
+			continue
+		}
+		f.name = name
+	}
+}
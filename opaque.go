@@ -0,0 +1,55 @@
+package main
+
+// opaque types the fields of root matching the opaque fields of the config
+// or of the collection c, which are patterns like those of ignored fields,
+// as raw documents, leaving their contents untyped.
+func (s *Generator) opaque(root *StructType, c Collection) {
+	patterns := append(append([]string(nil), s.OpaqueFields...), c.OpaqueFields...)
+	if len(patterns) > 0 {
+		opaqueFields(root, nil, patterns, LiteralType{Literal: s.opaqueType()})
+	}
+}
+
+func opaqueFields(st *StructType, prefix, patterns []string, raw Type) {
+	for k, f := range st.Fields {
+		p := append(prefix[:len(prefix):len(prefix)], k)
+		if ignored(p, patterns) {
+			f.Type = raw
+			continue
+		}
+		opaqueType(f.Type, p, patterns, raw)
+	}
+}
+
+func opaqueType(t Type, p, patterns []string, raw Type) {
+	switch v := t.(type) {
+	case *StructType:
+		opaqueFields(v, p, patterns, raw)
+	case SliceType:
+		opaqueType(v.Type, p, patterns, raw)
+	case MixedType:
+		for _, e := range v {
+			opaqueType(e, p, patterns, raw)
+		}
+	}
+}
+
+// opaqueType returns the Go type of opaque fields: bson.Raw, or
+// json.RawMessage if the structs are only tagged for encoding/json.
+func (s *Generator) opaqueType() string {
+	if s.bsonTagged() {
+		return "bson.Raw"
+	}
+	return "json.RawMessage"
+}
+
+// bsonTagged reports whether the fields of the structs are tagged for the
+// bson package.
+func (s *Generator) bsonTagged() bool {
+	for _, t := range s.tags() {
+		if t.Key == "bson" {
+			return true
+		}
+	}
+	return false
+}
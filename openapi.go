@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io"
+
+	"gopkg.in/yaml.v2"
+)
+
+// renderOpenAPI writes a YAML OpenAPI 3 document holding only the
+// components.schemas of every collection and named type, to be pasted into an
+// API spec.
+func (s *Generator) renderOpenAPI(w io.Writer, schemas []Schema) error {
+	b := schemaBuilder{gen: s, openapi: true}
+	components := map[string]interface{}{}
+	for _, schema := range schemas {
+		components[schema.Collection.Struct] = b.schema(schema.Root)
+		for _, n := range schema.Decls {
+			components[n.Name] = b.schema(n.Type)
+		}
+	}
+	out, err := yaml.Marshal(map[string]interface{}{
+		"components": map[string]interface{}{"schemas": components},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// nullable marks the OpenAPI schema of a field that was missing from some
+// documents. A $ref cannot have siblings, so it is wrapped in an allOf.
+func nullable(schema map[string]interface{}) map[string]interface{} {
+	if _, ok := schema["$ref"]; ok {
+		return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+	}
+	schema["nullable"] = true
+	return schema
+}
+
+// openAPIPrimitive returns the OpenAPI 3.0 schema of p, which differs from
+// JSON Schema for binary data.
+func openAPIPrimitive(p PrimitiveType) map[string]interface{} {
+	switch p {
+	case PrimitiveBinary, PrimitiveBytes:
+		return map[string]interface{}{"type": "string", "format": "byte"}
+	}
+	return jsonSchemaPrimitive(p)
+}
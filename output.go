@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Schema is the inferred type of a single collection.
+type Schema struct {
+	Collection Collection
+	Root       *StructType
+	Decls      []NamedType
+	// Variants are the structs of the variants of a collection with a
+	// discriminator, declared in Decls.
+	Variants []Variant
+	// full is the root the validator of the collection is built from,
+	// which keeps the fields moved out of Root into embedded structs and
+	// variants.
+	full *StructType
+}
+
+// Output configures where the generated code is written. In the YAML config
+// it is either a mapping or, for a single file, just the file name.
+type Output struct {
+	Dir    string `yaml:"dir"`
+	Layout string `yaml:"layout"`
+	File   string `yaml:"file"`
+	// Filename is a text/template for the file of each collection in the
+	// collection layout. It is executed with the Collection, and .Ext is the
+	// extension of the output format.
+	Filename string `yaml:"filename"`
+	// Sink is where the files go: file, the default, stdout or http.
+	Sink string `yaml:"sink"`
+	// URL is where the http sink POSTs the files.
+	URL string `yaml:"url"`
+	// Token, if set, is sent by the http sink as a bearer token.
+	Token string `yaml:"token"`
+}
+
+const (
+	LayoutSingle     = "single"
+	LayoutCollection = "collection"
+)
+
+const defaultFilename = "{{.Name}}{{.Ext}}"
+
+func (o *Output) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var file string
+	if err := unmarshal(&file); err == nil {
+		*o = Output{File: file}
+		return nil
+	}
+	type output Output
+	return unmarshal((*output)(o))
+}
+
+// layout returns the file layout. Without an explicit layout, a directory
+// without a file name means one file per collection.
+func (o Output) layout() string {
+	if o.Layout != "" {
+		return o.Layout
+	}
+	if o.Dir != "" && o.File == "" {
+		return LayoutCollection
+	}
+	return LayoutSingle
+}
+
+// write renders the schemas to the configured output files, or to stdout,
+// through the configured sink.
+func (s *Generator) write(schemas []Schema) error {
+	sink := s.outputSink()
+	switch s.Output.layout() {
+	case LayoutSingle:
+		name := ""
+		if s.Output.File != "" {
+			name = filepath.Join(s.Output.Dir, s.Output.File)
+		}
+		return s.writeFile(sink, name, schemas)
+	case LayoutCollection:
+		return s.writeCollections(sink, schemas)
+	}
+	return fmt.Errorf("mongoschema: unknown output layout %q", s.Output.Layout)
+}
+
+// writeCollections writes each schema to its own file in the output
+// directory, named by the filename template.
+func (s *Generator) writeCollections(sink OutputSink, schemas []Schema) error {
+	text := s.Output.Filename
+	if text == "" {
+		text = defaultFilename
+	}
+	tmpl, err := template.New("filename").Parse(text)
+	if err != nil {
+		return fmt.Errorf("mongoschema: invalid output filename: %s", err)
+	}
+	seen := map[string]string{}
+	for _, schema := range schemas {
+		var name bytes.Buffer
+		data := struct {
+			Collection
+			Ext string
+		}{schema.Collection, s.ext()}
+		if err := tmpl.Execute(&name, data); err != nil {
+			return fmt.Errorf("mongoschema: invalid output filename: %s", err)
+		}
+		file := filepath.Join(s.Output.Dir, name.String())
+		if c, ok := seen[file]; ok {
+			return fmt.Errorf("mongoschema: collections %s and %s both write to %s",
+				c, schema.Collection.Name, file)
+		}
+		seen[file] = schema.Collection.Name
+		if err := s.writeFile(sink, file, []Schema{schema}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Generator) writeFile(sink OutputSink, name string, schemas []Schema) error {
+	src, err := s.generate(schemas)
+	if err != nil {
+		return err
+	}
+	return sink.WriteFile(name, src)
+}
+
+// generate renders the schemas, and formats them if they are Go code.
+func (s *Generator) generate(schemas []Schema) ([]byte, error) {
+	var buf bytes.Buffer
+	if s.Header || s.HeaderHash {
+		s.writeHeader(&buf, schemas)
+	}
+	if err := s.render(&buf, schemas); err != nil {
+		return nil, err
+	}
+	src := buf.Bytes()
+	if s.Format == "" || s.Format == FormatGo {
+		return s.format(src)
+	}
+	return src, nil
+}
+
+// format runs the generated source through gofmt, and goimports if enabled.
+func (s *Generator) format(src []byte) ([]byte, error) {
+	if s.NoFormat {
+		return src, nil
+	}
+	src, err := format.Source(src)
+	if err != nil {
+		return nil, fmt.Errorf("mongoschema: formatting generated code: %s", err)
+	}
+	if !s.Goimports {
+		return src, nil
+	}
+	cmd := exec.Command("goimports")
+	cmd.Stdin = bytes.NewReader(src)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("mongoschema: running goimports: %s", err)
+	}
+	return out, nil
+}
+
+const (
+	FormatGo         = "go"
+	FormatJSONSchema = "jsonschema"
+	FormatValidator  = "validator"
+	FormatTypeScript = "typescript"
+	FormatProtobuf   = "protobuf"
+	FormatAvro       = "avro"
+	FormatOpenAPI    = "openapi"
+	FormatSQL        = "sql"
+	FormatMongoose   = "mongoose"
+	FormatGraphQL    = "graphql"
+	FormatKotlin     = "kotlin"
+	FormatJava       = "java"
+	FormatPython     = "python"
+	FormatRust       = "rust"
+	FormatFlat       = "flat"
+)
+
+// ext returns the file extension of the output format.
+func (s *Generator) ext() string {
+	switch s.Format {
+	case FormatJSONSchema, FormatValidator:
+		return ".json"
+	case FormatTypeScript:
+		return ".ts"
+	case FormatProtobuf:
+		return ".proto"
+	case FormatAvro:
+		return ".avsc"
+	case FormatOpenAPI:
+		return ".yaml"
+	case FormatSQL:
+		return ".sql"
+	case FormatMongoose:
+		if s.MongooseLang == MongooseTS {
+			return ".ts"
+		}
+		return ".js"
+	case FormatGraphQL:
+		return ".graphql"
+	case FormatKotlin:
+		return ".kt"
+	case FormatJava:
+		return ".java"
+	case FormatPython:
+		return ".py"
+	case FormatRust:
+		return ".rs"
+	case FormatFlat:
+		if s.FlatStyle == FlatJSON {
+			return ".json"
+		}
+		return ".csv"
+	}
+	if r, ok := s.renderer(); ok {
+		return r.ext
+	}
+	return ".go"
+}
+
+func (s *Generator) render(w io.Writer, schemas []Schema) error {
+	switch s.Format {
+	case "", FormatGo:
+		return s.renderGo(w, schemas)
+	case FormatJSONSchema:
+		return s.renderJSONSchema(w, schemas)
+	case FormatValidator:
+		return s.renderValidator(w, schemas)
+	case FormatTypeScript:
+		return s.renderTypeScript(w, schemas)
+	case FormatProtobuf:
+		return s.renderProtobuf(w, schemas)
+	case FormatAvro:
+		return s.renderAvro(w, schemas)
+	case FormatOpenAPI:
+		return s.renderOpenAPI(w, schemas)
+	case FormatSQL:
+		return s.renderSQL(w, schemas)
+	case FormatMongoose:
+		return s.renderMongoose(w, schemas)
+	case FormatGraphQL:
+		return s.renderGraphQL(w, schemas)
+	case FormatKotlin:
+		return s.renderKotlin(w, schemas)
+	case FormatJava:
+		return s.renderJava(w, schemas)
+	case FormatPython:
+		return s.renderPython(w, schemas)
+	case FormatRust:
+		return s.renderRust(w, schemas)
+	case FormatFlat:
+		return s.renderFlat(w, schemas)
+	}
+	if r, ok := s.renderer(); ok {
+		for _, schema := range schemas {
+			if err := r.Render(schema, w); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("mongoschema: unknown format %q", s.Format)
+}
+
+func (s *Generator) renderGo(w io.Writer, schemas []Schema) error {
+	// Without a package clause the output is a list of declarations, which
+	// gofmt still accepts.
+	if s.Package != "" {
+		fmt.Fprintf(w, "package %s\n\n", s.Package)
+		if imports := s.imports(schemas); len(imports) > 0 {
+			fmt.Fprintln(w, "import (")
+			for _, i := range imports {
+				fmt.Fprintf(w, "\t%q\n", i)
+			}
+			fmt.Fprintln(w, ")")
+			fmt.Fprintln(w)
+		}
+	}
+	return s.renderTypes(w, schemas)
+}
+
+// renderTypes writes the Go type declarations of the schemas.
+func (s *Generator) renderTypes(w io.Writer, schemas []Schema) error {
+	for _, schema := range schemas {
+		if s.DocComments {
+			s.writeStructDoc(w, schema)
+		} else if note := s.collectionNote(schema.Collection); note != "" {
+			fmt.Fprintf(w, "// %s\n", note)
+		}
+		fmt.Fprintf(w, "type %s %s\n\n", schema.Collection.Struct, schema.Root.GoType(s))
+		if s.ValidateMethods {
+			s.writeValidate(w, schema.Collection.Struct, schema.Root)
+		}
+		if s.IndexMethods && s.indexes != nil {
+			s.writeIndexes(w, schema.Collection.Struct, schema.Collection)
+		}
+		if s.Helpers {
+			s.writeConstructor(w, schema.Collection.Struct, schema.Root)
+			writeIsZero(w, schema.Collection.Struct)
+		}
+		if len(schema.Variants) > 0 {
+			s.writeUnmarshal(w, schema)
+		}
+		for _, n := range schema.Decls {
+			fmt.Fprintf(w, "type %s %s\n\n", n.Name, n.Type.GoType(s))
+			switch t := n.Type.(type) {
+			case EnumType:
+				s.writeEnumConsts(w, n.Name, t)
+			case *StructType:
+				if s.ValidateMethods {
+					s.writeValidate(w, n.Name, t)
+				}
+				if s.Helpers {
+					writeIsZero(w, n.Name)
+				}
+			case legacyPointDecl:
+				writeLegacyPointMethods(w, n.Name)
+			case epochDecl:
+				writeEpochMethods(w, n.Name, t)
+			}
+		}
+	}
+	return nil
+}
+
+// imports returns the sorted import paths referenced by the rendered schemas.
+func (s *Generator) imports(schemas []Schema) []string {
+	set := map[string]bool{}
+	add := func(t Type) {
+		if p := s.importPath(t); p != "" {
+			set[p] = true
+		}
+	}
+	for _, schema := range schemas {
+		s.walk(schema.Root, add)
+		for _, n := range schema.Decls {
+			s.walk(n.Type, add)
+		}
+	}
+	if s.ValidateMethods && s.validates(schemas) {
+		set["fmt"] = true
+	}
+	if s.IndexMethods && s.indexes != nil {
+		set["gopkg.in/mgo.v2"] = true
+		if s.expires(schemas) {
+			set["time"] = true
+		}
+	}
+	if s.Helpers {
+		for _, p := range s.helperImports(schemas) {
+			set[p] = true
+		}
+	}
+	for _, schema := range schemas {
+		if s.unmarshalsVariants(schema) {
+			if s.bsonTagged() {
+				set["gopkg.in/mgo.v2/bson"] = true
+			} else {
+				set["encoding/json"] = true
+			}
+		}
+	}
+	var paths []string
+	for p := range set {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (s *Generator) importPath(t Type) string {
+	if l, ok := t.(LiteralType); ok && strings.HasPrefix(l.Literal, "sql.") {
+		return "database/sql"
+	}
+	if l, ok := t.(LiteralType); ok && strings.HasPrefix(l.Literal, "bson.") {
+		return "gopkg.in/mgo.v2/bson"
+	}
+	if l, ok := t.(LiteralType); ok && strings.HasPrefix(l.Literal, "json.") {
+		return "encoding/json"
+	}
+	if o, ok := t.(OverrideType); ok {
+		return o.importPath()
+	}
+	if _, ok := t.(legacyPointDecl); ok {
+		return "gopkg.in/mgo.v2/bson"
+	}
+	if _, ok := t.(epochDecl); ok {
+		return "gopkg.in/mgo.v2/bson"
+	}
+	if g, ok := t.(GeoJSONType); ok {
+		_, path := qualifiedType(s.GeoJSON + "." + g.Shape)
+		return path
+	}
+	switch t {
+	case PrimitiveUUID:
+		if s.UUIDType == "" {
+			return "gopkg.in/mgo.v2/bson"
+		}
+		_, path := qualifiedType(s.UUIDType)
+		return path
+	case PrimitiveTimestamp:
+		return "time"
+	case PrimitiveDBRef:
+		return "gopkg.in/mgo.v2"
+	case PrimitiveBinary, PrimitiveObjectId, PrimitiveDecimal128, PrimitiveRegEx,
+		PrimitiveJavaScript, PrimitiveDBPointer, PrimitiveSymbol, PrimitiveMongoTimestamp:
+		return "gopkg.in/mgo.v2/bson"
+	}
+	return ""
+}
+
+// qualifiedType splits a type qualified by its full import path, such as
+// github.com/google/uuid.UUID, into the Go type, uuid.UUID, and the import
+// path. A type without an import path, such as [16]byte, is returned as is.
+func qualifiedType(name string) (goType, path string) {
+	slash := strings.LastIndex(name, "/")
+	dot := strings.LastIndex(name, ".")
+	if slash < 0 || dot < slash {
+		return name, ""
+	}
+	return name[slash+1:], name[:dot]
+}
+
+// walk calls fn for t and every type that appears in its rendered Go type.
+// Mixed types render as interface{}, so their members are not visited.
+func (s *Generator) walk(t Type, fn func(Type)) {
+	fn(t)
+	switch v := t.(type) {
+	case *StructType:
+		for _, k := range v.keys(s) {
+			if !isValidFieldName(k) {
+				continue
+			}
+			// An sql.Null wrapper replaces the type of an optional field,
+			// and bson.Raw the type of a field that was always null.
+			if t, _ := s.fieldType(v, k); strings.HasPrefix(t, "sql.") || t == "bson.Raw" {
+				fn(LiteralType{Literal: t})
+				continue
+			}
+			s.walk(v.Fields[k].Type, fn)
+		}
+	case SliceType:
+		s.walk(v.Type, fn)
+	case TupleType:
+		if e, ok := v.uniform(s); ok {
+			s.walk(e, fn)
+		}
+	case MapType:
+		s.walk(v.Value, fn)
+	case epochDecl:
+		// The struct embeds a time.Time.
+		fn(PrimitiveTimestamp)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+)
+
+// OverrideType is a field type chosen in the config rather than inferred.
+// Name is the Go type, qualified by its full import path unless it is in a
+// standard package like time, such as github.com/shopspring/decimal.Decimal.
+// Formats other than Go render the inferred type.
+type OverrideType struct {
+	Name     string
+	Inferred Type
+}
+
+func (o OverrideType) GoType(gen *Generator) string {
+	prefix, name := o.split()
+	goType, _ := qualifiedType(name)
+	return prefix + goType
+}
+
+func (o OverrideType) Merge(t Type, gen *Generator) Type {
+	return OverrideType{Name: o.Name, Inferred: o.Inferred.Merge(t, gen)}
+}
+
+// split splits the slice and pointer prefix, like []*, off the type name.
+func (o OverrideType) split() (prefix, name string) {
+	name = strings.TrimLeft(o.Name, "[]*")
+	return o.Name[:len(o.Name)-len(name)], name
+}
+
+// importPath returns the package of the Go type, which for a type without an
+// import path, like time.Time, is its qualifier.
+func (o OverrideType) importPath() string {
+	_, name := o.split()
+	if _, path := qualifiedType(name); path != "" {
+		return path
+	}
+	if i := strings.Index(name, "."); i > 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// override replaces the types of the fields of c overridden by the config,
+// which are keyed by the collection name and the dotted path of the field.
+func (s *Generator) override(root *StructType, c Collection) {
+	for key, name := range s.Overrides {
+		if !strings.HasPrefix(key, c.Name+".") {
+			continue
+		}
+		path := strings.TrimPrefix(key, c.Name+".")
+		if !overrideField(root, strings.Split(path, "."), name) {
+			s.logger().Warn("overridden field not found", "collection", c.Name, "field", path)
+		}
+	}
+}
+
+// overrideField overrides the field at path in st, looking through slices of
+// nested documents, and reports whether it was found.
+func overrideField(st *StructType, path []string, name string) bool {
+	f, ok := st.Fields[path[0]]
+	if !ok {
+		return false
+	}
+	if len(path) == 1 {
+		f.Type = OverrideType{Name: name, Inferred: f.Type}
+		return true
+	}
+	t := f.Type
+	for {
+		s, ok := t.(SliceType)
+		if !ok {
+			break
+		}
+		t = s.Type
+	}
+	nested, ok := t.(*StructType)
+	return ok && overrideField(nested, path[1:], name)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// Packages maps the name of a Go package to the patterns of the names of the
+// collections generated into it, in a subdirectory of the output named after
+// the package. The other collections stay in the configured package and
+// output.
+type Packages map[string][]string
+
+// packageGroup is the collections generated into one package, with the
+// generator configured for it and their raw types.
+type packageGroup struct {
+	gen   *Generator
+	roots []*StructType
+}
+
+// packageOf returns the package of the collection name, the first in order
+// of name whose patterns match it, or "" for the default package.
+func (s *Generator) packageOf(name string) string {
+	var pkgs []string
+	for pkg := range s.Packages {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+	for _, pkg := range pkgs {
+		for _, p := range s.Packages[pkg] {
+			if ok, _ := path.Match(p, name); ok {
+				return pkg
+			}
+		}
+	}
+	return ""
+}
+
+// splitPackages groups the collections and their raw types roots by
+// package: first those of the default package, if any, then those of each
+// configured package, written to the subdirectory of the output named after
+// it. Each group is transformed on its own, so types are only shared within
+// a package and typed refs only point to collections of the same package.
+func (s *Generator) splitPackages(roots []*StructType) []packageGroup {
+	if len(s.Packages) == 0 {
+		return []packageGroup{{gen: s, roots: roots}}
+	}
+	byPkg := map[string]*packageGroup{}
+	var order []string
+	for i, c := range s.Collections {
+		pkg := s.packageOf(c.Name)
+		grp, ok := byPkg[pkg]
+		if !ok {
+			g := *s
+			g.Collections = nil
+			g.Packages = nil
+			if pkg != "" {
+				g.Package = pkg
+				g.Output.Dir = filepath.Join(s.Output.Dir, pkg)
+			}
+			grp = &packageGroup{gen: &g}
+			byPkg[pkg] = grp
+			order = append(order, pkg)
+		}
+		grp.gen.Collections = append(grp.gen.Collections, c)
+		grp.roots = append(grp.roots, roots[i])
+	}
+	sort.Strings(order)
+	groups := make([]packageGroup, len(order))
+	for i, pkg := range order {
+		groups[i] = *byPkg[pkg]
+	}
+	return groups
+}
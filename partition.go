@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// partitioner is implemented by sources that can split the scan of a
+// collection into several cursors.
+type partitioner interface {
+	// partition returns up to n collections, each with a filter selecting a
+	// disjoint part of the documents of c.
+	partition(c Collection, n int) ([]Collection, error)
+}
+
+// partitions returns the number of cursors to scan c with. A sorted scan is
+// not partitioned, as each partition would be sorted on its own, nor is the
+// output of a pipeline, which has no _id ranges to split.
+func (s *Generator) partitions(c Collection) int {
+	if len(c.Sort) > 0 || c.Pipeline != nil {
+		return 1
+	}
+	if c.Partitions != 0 {
+		return c.Partitions
+	}
+	return s.Partitions
+}
+
+// limit returns the maximum number of documents to sample from c.
+func (s *Generator) limit(c Collection) uint {
+	if c.limit != 0 {
+		return c.limit
+	}
+	if c.Limit != 0 {
+		return c.Limit
+	}
+	return s.Limit
+}
+
+// scanCollection scans c, on each shard with per_shard, splitting it into
+// concurrently scanned partitions if the source supports it, and merges the
+// results. With server analysis,
+// the server infers the type instead.
+func (s *Generator) scanCollection(ctx context.Context, src Source, c Collection) (*StructType, error) {
+	if m, ok := src.(*mongoSource); ok && len(c.Clusters) > 0 {
+		return s.scanClusters(ctx, m, c)
+	}
+	if s.Analysis == AnalysisServer {
+		m, ok := src.(*mongoSource)
+		if !ok {
+			return nil, fmt.Errorf("mongoschema: server analysis requires a mongo source")
+		}
+		return m.analyze(c)
+	}
+	// A pipeline, which may join other collections, runs through mongos.
+	if m, ok := src.(*mongoSource); ok && len(m.shards) > 0 && c.Pipeline == nil {
+		return s.scanShards(ctx, m, c)
+	}
+	n := s.partitions(c)
+	if n <= 1 {
+		return s.scan(ctx, src, c)
+	}
+	p, ok := src.(partitioner)
+	if !ok {
+		s.logger().Warn("source does not support partitions, scanning with one cursor", "collection", c.Name)
+		return s.scan(ctx, src, c)
+	}
+	parts, err := p.partition(c, n)
+	if err != nil {
+		return nil, err
+	}
+	parts = s.shareLimit(parts)
+	roots := make([]*StructType, len(parts))
+	errs := make([]error, len(parts))
+	var wg sync.WaitGroup
+	for i := range parts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			roots[i], errs[i] = s.scan(ctx, src, parts[i])
+		}(i)
+	}
+	wg.Wait()
+	root := newStructType()
+	for i := range parts {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		root.Merge(roots[i], s)
+	}
+	return root, nil
+}
+
+// shareLimit divides the limit of the collection evenly among its parts,
+// dropping the parts left with nothing to scan.
+func (s *Generator) shareLimit(parts []Collection) []Collection {
+	limit := s.limit(parts[0])
+	if limit == 0 && s.sampling(parts[0]) != SamplingNatural {
+		limit = defaultSampleSize
+	}
+	if limit == 0 {
+		return parts
+	}
+	n := uint(len(parts))
+	var shared []Collection
+	for i := range parts {
+		share := limit / n
+		if uint(i) < limit%n {
+			share++
+		}
+		if share == 0 {
+			break
+		}
+		parts[i].limit = share
+		shared = append(shared, parts[i])
+	}
+	return shared
+}
+
+// partition splits a naturally sampled collection into ranges of _id of
+// equal time span between the oldest and newest ObjectId. Random samples are
+// simply taken by n smaller $sample stages, and smart samples are not split.
+func (m *mongoSource) partition(c Collection, n int) ([]Collection, error) {
+	parts := make([]Collection, n)
+	for i := range parts {
+		parts[i] = c
+	}
+	switch m.gen.sampling(c) {
+	case SamplingRandom:
+		return parts, nil
+	case SamplingSmart:
+		// Partitions would each sample the same oldest and newest
+		// documents.
+		return parts[:1], nil
+	}
+	filter, err := toBSON(c.Filter)
+	if err != nil {
+		return nil, fmt.Errorf("mongoschema: invalid filter for collection %s: %s", c.Name, err)
+	}
+	session := m.session.Copy()
+	defer session.Close()
+	collection := session.DB(m.gen.DB).C(c.Name)
+	var first, last struct {
+		ID interface{} `bson:"_id"`
+	}
+	err = collection.Find(filter).SetMaxTime(m.gen.maxTime()).Sort("_id").Select(bson.M{"_id": 1}).One(&first)
+	if err == mgo.ErrNotFound {
+		return parts[:1], nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("mongoschema: partitioning collection %s: %s", c.Name, err)
+	}
+	if err := collection.Find(filter).SetMaxTime(m.gen.maxTime()).Sort("-_id").Select(bson.M{"_id": 1}).One(&last); err != nil {
+		return nil, fmt.Errorf("mongoschema: partitioning collection %s: %s", c.Name, err)
+	}
+	min, ok1 := first.ID.(bson.ObjectId)
+	max, ok2 := last.ID.(bson.ObjectId)
+	if !ok1 || !ok2 {
+		m.gen.logger().Warn("_id is not an ObjectId, scanning with one cursor", "collection", c.Name)
+		return parts[:1], nil
+	}
+	start, span := min.Time(), max.Time().Sub(min.Time())
+	var lower interface{}
+	for i := range parts {
+		r := bson.M{}
+		if lower != nil {
+			r["$gte"] = lower
+		}
+		if i < n-1 {
+			upper := bson.NewObjectIdWithTime(start.Add(span * time.Duration(i+1) / time.Duration(n)))
+			r["$lt"] = upper
+			lower = upper
+		}
+		rng := bson.M{"_id": r}
+		if filter != nil {
+			parts[i].Filter = bson.M{"$and": []interface{}{filter, rng}}
+		} else {
+			parts[i].Filter = rng
+		}
+	}
+	return parts, nil
+}
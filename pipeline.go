@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// decodeBatchSize is the number of documents fetched into each batch of the
+// decode pipeline.
+const decodeBatchSize = 64
+
+// scanProgress is the progress of the scan of a collection, across the
+// cursors it opens.
+type scanProgress struct {
+	logger *slog.Logger
+	start  time.Time
+	last   time.Time
+	seen   uint
+	// lastID is the _id of the last document merged, to resume from.
+	lastID interface{}
+	// stats, if collected, are those of the documents merged.
+	stats *docStats
+}
+
+func newScanProgress(logger *slog.Logger) *scanProgress {
+	now := time.Now()
+	return &scanProgress{logger: logger, start: now, last: now}
+}
+
+// merged counts a document of type t and size bytes merged into the type of
+// the collection, logging the progress every progressInterval.
+func (p *scanProgress) merged(id interface{}, t Type, size int) {
+	p.seen++
+	p.lastID = id
+	p.stats.add(t, size)
+	if now := time.Now(); now.Sub(p.last) >= progressInterval {
+		p.last = now
+		p.logger.Info("scanning", progress(p.seen, now.Sub(p.start))...)
+	}
+}
+
+// docBatch is a batch of documents of the decode pipeline, with their types,
+// _ids and sizes once decoded, which closes done.
+type docBatch struct {
+	docs  []interface{}
+	types []Type
+	ids   []interface{}
+	sizes []int
+	err   error
+	done  chan struct{}
+}
+
+// scanPipelined reads the documents of iter into root until limit, like
+// scanCursor, but on separate goroutines: one fetches batches of undecoded
+// documents from the cursor, DecodeWorkers build their types and the caller
+// merges them, in the order they were fetched. The cursor is thus read
+// while documents are decoded and merged.
+func (s *Generator) scanPipelined(ctx context.Context, src Source, iter Iter, c Collection, root *StructType, limit uint, p *scanProgress) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	jobs := make(chan *docBatch)
+	ordered := make(chan *docBatch, s.DecodeWorkers)
+	var wg sync.WaitGroup
+	for w := 0; w < s.DecodeWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range jobs {
+				s.decodeBatch(b)
+				close(b.done)
+			}
+		}()
+	}
+	go func() {
+		defer close(ordered)
+		defer close(jobs)
+		n := p.seen
+		more := true
+		for more && (limit == 0 || n < limit) && ctx.Err() == nil {
+			b := &docBatch{done: make(chan struct{})}
+			for len(b.docs) < decodeBatchSize && (limit == 0 || n < limit) && ctx.Err() == nil {
+				doc, ok := s.fetch(iter)
+				if !ok {
+					more = false
+					break
+				}
+				b.docs = append(b.docs, doc)
+				n++
+				if pc, ok := src.(pacer); ok && !pc.pace(ctx, n) {
+					more = false
+					break
+				}
+			}
+			if len(b.docs) == 0 {
+				return
+			}
+			// Both are drained until closed, so neither send blocks for
+			// good.
+			ordered <- b
+			jobs <- b
+		}
+	}()
+	var err error
+	for b := range ordered {
+		<-b.done
+		if err != nil {
+			continue
+		}
+		if b.err != nil {
+			err = fmt.Errorf("mongoschema: decoding a document of %s: %s", c.Name, b.err)
+			cancel()
+			continue
+		}
+		for i, t := range b.types {
+			s.mergeDoc(root, c, t)
+			p.merged(b.ids[i], t, b.sizes[i])
+		}
+	}
+	wg.Wait()
+	return err
+}
+
+// fetch reads the next document of iter undecoded, as raw BSON, or as a map
+// from the json source, whose iterators only decode into maps.
+func (s *Generator) fetch(iter Iter) (interface{}, bool) {
+	if s.Source == SourceJSON {
+		m := bson.M{}
+		return m, iter.Next(m)
+	}
+	var raw bson.Raw
+	if !iter.Next(&raw) {
+		return nil, false
+	}
+	// The iterator may reuse the buffer raw points to.
+	raw.Data = append([]byte(nil), raw.Data...)
+	return raw, true
+}
+
+// decodeBatch builds the types of the documents of b, stopping at the first
+// that fails to decode.
+func (s *Generator) decodeBatch(b *docBatch) {
+	for _, doc := range b.docs {
+		var t Type
+		var id interface{}
+		size := 0
+		switch d := doc.(type) {
+		case bson.M:
+			t, id = NewType(d, s), d["_id"]
+		case bson.Raw:
+			size = len(d.Data)
+			if s.LowMemory || s.FieldOrder == FieldOrderDocument {
+				t, id, b.err = s.rawType(d)
+				break
+			}
+			m := bson.M{}
+			if b.err = d.Unmarshal(&m); b.err == nil {
+				t, id = NewType(m, s), m["_id"]
+			}
+		}
+		if b.err != nil {
+			return
+		}
+		if st, ok := t.(*StructType); ok && s.ConflictIDs && id != nil {
+			recordIDs(st, formatID(id), s)
+		}
+		b.types = append(b.types, t)
+		b.ids = append(b.ids, id)
+		b.sizes = append(b.sizes, size)
+	}
+}
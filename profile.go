@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// envRe matches the references to environment variables in a config, like
+// ${MONGO_PASSWORD}. A reference prefixed with another $ is escaped.
+var envRe = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces the references to environment variables in the config
+// buf with their values, before it is parsed, so that they can be used for
+// any setting. Values are inserted as they are, so a reference whose value
+// may contain YAML syntax should be quoted. Referring to a variable that is
+// not set is an error, rather than silently connecting with an empty
+// password.
+func expandEnv(buf []byte) ([]byte, error) {
+	var missing []string
+	buf = envRe.ReplaceAllFunc(buf, func(ref []byte) []byte {
+		if ref[1] == '$' {
+			return ref[1:]
+		}
+		name := string(envRe.FindSubmatch(ref)[1])
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			if !sscontains(missing, name) {
+				missing = append(missing, name)
+			}
+			return ref
+		}
+		return []byte(value)
+	})
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("environment variables not set: %s", strings.Join(missing, ", "))
+	}
+	return buf, nil
+}
+
+// Profiles are named sets of settings, such as the connection settings of
+// each environment, that override those of the config when selected with
+// -profile.
+type Profiles map[string]yaml.MapSlice
+
+// applyProfile overrides the settings of s with those of the profile name.
+// Like the config, a profile is decoded strictly, and settings it leaves out
+// keep their values, even within nested settings such as auth.
+func (s *Generator) applyProfile(name string) error {
+	profile, ok := s.Profiles[name]
+	if !ok {
+		var names []string
+		for n := range s.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return fmt.Errorf("profile %s: no profiles defined", name)
+		}
+		return fmt.Errorf("profile %s: not one of %s", name, strings.Join(names, ", "))
+	}
+	for _, item := range profile {
+		if item.Key == "profiles" {
+			return fmt.Errorf("profile %s: profiles cannot be nested", name)
+		}
+	}
+	b, err := yaml.Marshal(profile)
+	if err != nil {
+		return err
+	}
+	if err := yaml.UnmarshalStrict(b, s); err != nil {
+		return fmt.Errorf("profile %s: %s", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	ProtoNumberingSequential = "sequential"
+	ProtoNumberingHash       = "hash"
+)
+
+const (
+	protoMaxField      = 1<<29 - 1
+	protoReservedFirst = 19000
+	protoReservedLast  = 19999
+)
+
+// protoWriter renders proto3 messages and records the well-known types they
+// import.
+type protoWriter struct {
+	gen     *Generator
+	buf     bytes.Buffer
+	imports map[string]bool
+}
+
+// renderProtobuf writes a .proto file with a message per collection and
+// named struct. Nested structs become nested messages.
+func (s *Generator) renderProtobuf(w io.Writer, schemas []Schema) error {
+	p := &protoWriter{gen: s, imports: map[string]bool{}}
+	for _, schema := range schemas {
+		p.message(schema.Collection.Struct, schema.Root, 0)
+		for _, n := range schema.Decls {
+			if st, ok := n.Type.(*StructType); ok {
+				p.message(n.Name, st, 0)
+			}
+		}
+	}
+
+	fmt.Fprintln(w, `syntax = "proto3";`)
+	fmt.Fprintln(w)
+	pkg := s.ProtoPackage
+	if pkg == "" {
+		pkg = s.Package
+	}
+	if pkg != "" {
+		fmt.Fprintf(w, "package %s;\n\n", pkg)
+	}
+	var imports []string
+	for i := range p.imports {
+		imports = append(imports, i)
+	}
+	sort.Strings(imports)
+	for _, i := range imports {
+		fmt.Fprintf(w, "import %q;\n", i)
+	}
+	if len(imports) > 0 {
+		fmt.Fprintln(w)
+	}
+	_, err := w.Write(p.buf.Bytes())
+	return err
+}
+
+func (p *protoWriter) message(name string, s *StructType, depth int) {
+	indent := strings.Repeat("  ", depth)
+	fmt.Fprintf(&p.buf, "%smessage %s {\n", indent, name)
+	keys := s.keys(p.gen)
+	numbers := p.numbers(keys)
+	names := map[string]bool{}
+	for _, k := range keys {
+		fieldName := convertCase(k, CaseSnake)
+		if fieldName == "" || fieldName[0] >= '0' && fieldName[0] <= '9' {
+			fieldName = "f_" + fieldName
+		}
+		for base, i := fieldName, 2; names[fieldName]; i++ {
+			fieldName = fmt.Sprintf("%s_%d", base, i)
+		}
+		names[fieldName] = true
+
+		typ := p.fieldType(s.Fields[k].Type, p.gen.makeFieldName(k), depth+1)
+		fmt.Fprintf(&p.buf, "%s  %s %s = %d", indent, typ, fieldName, numbers[k])
+		if fieldName != k {
+			fmt.Fprintf(&p.buf, " [json_name = %q]", k)
+		}
+		fmt.Fprintln(&p.buf, ";")
+	}
+	fmt.Fprintf(&p.buf, "%s}\n", indent)
+	if depth == 0 {
+		fmt.Fprintln(&p.buf)
+	}
+}
+
+// fieldType returns the type of a field, declaring a nested message named
+// after the field if it is a struct.
+func (p *protoWriter) fieldType(t Type, name string, depth int) string {
+	switch v := t.(type) {
+	case *StructType:
+		p.message(name, v, depth)
+		return name
+	case SliceType:
+		switch v.Type.(type) {
+		case SliceType, MapType, MixedType:
+			p.imports["google/protobuf/struct.proto"] = true
+			return "repeated google.protobuf.Value"
+		}
+		if isNil(v.Type) {
+			p.imports["google/protobuf/struct.proto"] = true
+			return "repeated google.protobuf.Value"
+		}
+		return "repeated " + p.fieldType(v.Type, name, depth)
+	case TupleType:
+		if e, ok := v.uniform(p.gen); ok {
+			return "repeated " + p.fieldType(e, name, depth)
+		}
+		p.imports["google/protobuf/struct.proto"] = true
+		return "repeated google.protobuf.Value"
+	case MapType:
+		switch v.Value.(type) {
+		case SliceType, MapType:
+			p.imports["google/protobuf/struct.proto"] = true
+			return "map<string, google.protobuf.Value>"
+		}
+		return "map<string, " + p.fieldType(v.Value, name+"Value", depth) + ">"
+	case NamedType:
+		switch v.Type.(type) {
+		case EnumType:
+			return "string"
+		case *StructType:
+			return v.Name
+		}
+		return p.fieldType(v.Type, name, depth)
+	case OverrideType:
+		return p.fieldType(v.Inferred, name, depth)
+	case GeoJSONType:
+		return p.fieldType(v.Struct(), name, depth)
+	case LegacyPointType:
+		return p.fieldType(v.Tuple(), name, depth)
+	case PrimitiveType:
+		return p.primitive(v)
+	}
+	p.imports["google/protobuf/struct.proto"] = true
+	return "google.protobuf.Value"
+}
+
+func (p *protoWriter) primitive(t PrimitiveType) string {
+	switch t {
+	case PrimitiveBool:
+		return "bool"
+	case PrimitiveDouble:
+		return "double"
+	case PrimitiveInt32:
+		return "int32"
+	case PrimitiveInt64, PrimitiveMongoTimestamp:
+		return "int64"
+	case PrimitiveObjectId, PrimitiveBinary, PrimitiveBytes, PrimitiveUUID:
+		return "bytes"
+	case PrimitiveString, PrimitiveDecimal128, PrimitiveRegEx, PrimitiveJavaScript, PrimitiveSymbol:
+		return "string"
+	case PrimitiveTimestamp:
+		p.imports["google/protobuf/timestamp.proto"] = true
+		return "google.protobuf.Timestamp"
+	case PrimitiveDBRef:
+		p.imports["google/protobuf/struct.proto"] = true
+		return "google.protobuf.Struct"
+	}
+	p.imports["google/protobuf/struct.proto"] = true
+	return "google.protobuf.Value"
+}
+
+// numbers assigns field numbers to keys. Sequential numbering follows the
+// sorted keys, so adding a field can renumber others; hash numbering derives
+// each number from the key, so numbers stay stable as the schema evolves.
+func (p *protoWriter) numbers(keys []string) map[string]int {
+	numbers := map[string]int{}
+	if p.gen.ProtoNumbering != ProtoNumberingHash {
+		for i, k := range keys {
+			numbers[k] = i + 1
+		}
+		return numbers
+	}
+	used := map[int]bool{}
+	for _, k := range keys {
+		h := fnv.New32a()
+		h.Write([]byte(k))
+		n := int(h.Sum32()%protoMaxField) + 1
+		for used[n] || n >= protoReservedFirst && n <= protoReservedLast {
+			n = n%protoMaxField + 1
+		}
+		used[n] = true
+		numbers[k] = n
+	}
+	return numbers
+}
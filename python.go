@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// PythonPydantic declares Pydantic models, which validate documents
+	// as they are loaded.
+	PythonPydantic  = "pydantic"
+	PythonDataclass = "dataclass"
+)
+
+// pythonBase is the Pydantic model the models of the pydantic style inherit,
+// so that they accept BSON types like ObjectId and are populated by the keys
+// of the documents as well as by the names of the fields.
+const pythonBase = "Model"
+
+// pythonPrimitives are the Python types of primitives, with the module to
+// import them from, if any.
+var pythonPrimitives = map[PrimitiveType][2]string{
+	PrimitiveBool:           {"bool", ""},
+	PrimitiveDouble:         {"float", ""},
+	PrimitiveInt32:          {"int", ""},
+	PrimitiveInt64:          {"int", ""},
+	PrimitiveObjectId:       {"ObjectId", "bson"},
+	PrimitiveString:         {"str", ""},
+	PrimitiveJavaScript:     {"str", ""},
+	PrimitiveSymbol:         {"str", ""},
+	PrimitiveTimestamp:      {"datetime", "datetime"},
+	PrimitiveDecimal128:     {"Decimal128", "bson.decimal128"},
+	PrimitiveBinary:         {"bytes", ""},
+	PrimitiveBytes:          {"bytes", ""},
+	PrimitiveUUID:           {"UUID", "uuid"},
+	PrimitiveRegEx:          {"Regex", "bson.regex"},
+	PrimitiveMongoTimestamp: {"Timestamp", "bson.timestamp"},
+	PrimitiveDBRef:          {"DBRef", "bson.dbref"},
+}
+
+var pythonKeywords = map[string]bool{
+	"False": true, "None": true, "True": true, "and": true, "as": true, "assert": true,
+	"async": true, "await": true, "break": true, "class": true, "continue": true, "def": true,
+	"del": true, "elif": true, "else": true, "except": true, "finally": true, "for": true,
+	"from": true, "global": true, "if": true, "import": true, "in": true, "is": true,
+	"lambda": true, "nonlocal": true, "not": true, "or": true, "pass": true, "raise": true,
+	"return": true, "try": true, "while": true, "with": true, "yield": true,
+}
+
+// pythonWriter renders Python classes. Nested structs become classes of
+// their own named after the class and field embedding them, declared after
+// it, which postponed evaluation of annotations allows.
+type pythonWriter struct {
+	declWalker
+	gen *Generator
+	buf bytes.Buffer
+	// imports are the names to import, by module.
+	imports map[string]map[string]bool
+}
+
+// renderPython writes a Pydantic model or a dataclass for every collection
+// and named struct, and a Literal alias for every named enum. A field is
+// Optional unless it was present and not null in every document.
+func (s *Generator) renderPython(w io.Writer, schemas []Schema) error {
+	p := &pythonWriter{declWalker: newDeclWalker(schemas, pythonBase), gen: s, imports: map[string]map[string]bool{}}
+	var enums bytes.Buffer
+	p.walk(schemas, s.collectionNote, p.class, func(n NamedType) {
+		if t, ok := n.Type.(EnumType); ok {
+			fmt.Fprintf(&enums, "%s = %s\n", n.Name, p.literal(t))
+		}
+	})
+
+	var base string
+	if s.PythonStyle == PythonDataclass {
+		p.use("dataclasses", "dataclass")
+	} else {
+		p.use("pydantic", "BaseModel")
+		p.use("pydantic", "ConfigDict")
+		base = fmt.Sprintf("class %s(BaseModel):\n    model_config = ConfigDict(arbitrary_types_allowed=True, populate_by_name=True)\n\n\n", pythonBase)
+	}
+	fmt.Fprint(w, "from __future__ import annotations\n\n")
+	var modules []string
+	for m := range p.imports {
+		modules = append(modules, m)
+	}
+	sort.Strings(modules)
+	for _, m := range modules {
+		var names []string
+		for n := range p.imports[m] {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(w, "from %s import %s\n", m, strings.Join(names, ", "))
+	}
+	fmt.Fprint(w, "\n\n")
+	if enums.Len() > 0 {
+		fmt.Fprintf(w, "%s\n\n", enums.Bytes())
+	}
+	fmt.Fprint(w, base)
+	_, err := fmt.Fprintln(w, strings.TrimRight(p.buf.String(), "\n"))
+	return err
+}
+
+// class declares the class name with the fields of st, documented by note
+// if it is not empty.
+func (p *pythonWriter) class(name string, st *StructType, note string) {
+	dataclass := p.gen.PythonStyle == PythonDataclass
+	if dataclass {
+		// Keyword-only fields may have defaults in any order.
+		fmt.Fprintf(&p.buf, "@dataclass(kw_only=True)\nclass %s:\n", name)
+	} else {
+		fmt.Fprintf(&p.buf, "class %s(%s):\n", name, pythonBase)
+	}
+	if note != "" {
+		fmt.Fprintf(&p.buf, "    %s\n\n", pythonString(note))
+	}
+	keys := st.keys(p.gen)
+	if len(keys) == 0 {
+		fmt.Fprint(&p.buf, "    pass\n")
+	}
+	used := map[string]bool{}
+	for _, k := range keys {
+		f := st.Fields[k]
+		fieldName := pythonFieldName(k, used)
+		typ := p.fieldType(f.Type, name+p.gen.makeFieldName(k))
+		optional := !st.required(k) || f.Nulls > 0
+		if optional {
+			typ = "Optional[" + typ + "]"
+			p.use("typing", "Optional")
+		}
+		if p.gen.PresenceComments && !st.synthetic {
+			fmt.Fprintf(&p.buf, "    # present in %s\n", st.presence(k))
+		}
+		var args []string
+		if optional {
+			args = append(args, "default=None")
+		}
+		if fieldName != k {
+			if dataclass {
+				args = append(args, fmt.Sprintf("metadata={\"alias\": %s}", pythonString(k)))
+			} else {
+				args = append(args, "alias="+pythonString(k))
+			}
+		}
+		switch {
+		case fieldName != k && dataclass:
+			p.use("dataclasses", "field")
+			fmt.Fprintf(&p.buf, "    %s: %s = field(%s)\n", fieldName, typ, strings.Join(args, ", "))
+		case fieldName != k:
+			p.use("pydantic", "Field")
+			fmt.Fprintf(&p.buf, "    %s: %s = Field(%s)\n", fieldName, typ, strings.Join(args, ", "))
+		case optional:
+			fmt.Fprintf(&p.buf, "    %s: %s = None\n", fieldName, typ)
+		default:
+			fmt.Fprintf(&p.buf, "    %s: %s\n", fieldName, typ)
+		}
+	}
+	fmt.Fprint(&p.buf, "\n\n")
+}
+
+// fieldType returns the type of t, naming the class of a nested struct
+// name.
+func (p *pythonWriter) fieldType(t Type, name string) string {
+	switch v := t.(type) {
+	case *StructType:
+		return p.nested(name, v)
+	case SliceType:
+		return p.list(v.Type, name)
+	case TupleType:
+		if e, ok := v.uniform(p.gen); ok {
+			return p.list(e, name)
+		}
+		return p.list(nil, name)
+	case MapType:
+		p.use("typing", "Dict")
+		return "Dict[str, " + p.fieldType(v.Value, name) + "]"
+	case NamedType:
+		switch v.Type.(type) {
+		case *StructType, EnumType:
+			return v.Name
+		}
+		return p.fieldType(v.Type, name)
+	case OverrideType:
+		return p.fieldType(v.Inferred, name)
+	case GeoJSONType:
+		return p.fieldType(v.Struct(), name)
+	case LegacyPointType:
+		return p.list(PrimitiveDouble, name)
+	case EnumType:
+		return p.literal(v)
+	case PrimitiveType:
+		if t, ok := pythonPrimitives[v]; ok {
+			if t[1] != "" {
+				p.use(t[1], t[0])
+			}
+			return t[0]
+		}
+	}
+	p.use("typing", "Any")
+	return "Any"
+}
+
+// list returns a list of elem, or of anything if elem is unknown.
+func (p *pythonWriter) list(elem Type, name string) string {
+	p.use("typing", "List")
+	if isNil(elem) {
+		p.use("typing", "Any")
+		return "List[Any]"
+	}
+	return "List[" + p.fieldType(elem, name) + "]"
+}
+
+// literal returns the Literal type of the values of e.
+func (p *pythonWriter) literal(e EnumType) string {
+	p.use("typing", "Literal")
+	values := make([]string, len(e.Values))
+	for i, v := range e.Values {
+		values[i] = pythonString(v)
+	}
+	return "Literal[" + strings.Join(values, ", ") + "]"
+}
+
+// use records that name is imported from module.
+func (p *pythonWriter) use(module, name string) {
+	if p.imports[module] == nil {
+		p.imports[module] = map[string]bool{}
+	}
+	p.imports[module][name] = true
+}
+
+// pythonFieldName returns the snake-cased name of the key k, which must not
+// start with an underscore as Pydantic keeps those private, escaping
+// keywords, and records it in used.
+func pythonFieldName(k string, used map[string]bool) string {
+	name := strings.TrimLeft(convertCase(k, CaseSnake), "_")
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "f" + name
+	}
+	if pythonKeywords[name] {
+		name += "_"
+	}
+	for base, i := name, 2; used[name]; i++ {
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+	used[name] = true
+	return name
+}
+
+// pythonString returns s as a Python string literal, whose escapes are
+// those of Go for the characters strconv.Quote escapes.
+func pythonString(s string) string {
+	return strconv.Quote(s)
+}
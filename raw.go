@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// BSON kinds that the raw walk tells apart.
+const (
+	bsonDocument = 0x03
+	bsonArray    = 0x04
+	bsonNull     = 0x0A
+)
+
+// rawDocument returns the type of the raw document raw. It walks the
+// elements of the document instead of decoding it into maps, and decodes
+// only scalars and the values whose statistics need them whole. The decoded
+// document is returned if it is a DBRef, whose references newField counts.
+func rawDocument(raw bson.Raw, gen *Generator) (Type, interface{}, error) {
+	var d bson.RawD
+	if err := raw.Unmarshal(&d); err != nil {
+		return nil, nil, err
+	}
+	if rawWhole(d, gen) {
+		var m bson.M
+		if err := raw.Unmarshal(&m); err != nil {
+			return nil, nil, err
+		}
+		if t := NewStructType(m, gen); t != PrimitiveDBRef {
+			return t, nil, nil
+		}
+		return PrimitiveDBRef, m, nil
+	}
+	s := newStructType()
+	s.Count = 1
+	for i, e := range d {
+		t, v, err := rawType(e.Value, gen)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %s", e.Name, err)
+		}
+		if e.Value.Kind == bsonNull && gen.tracksNulls() {
+			f := nullField(gen)
+			f.Order = uint(i) + 1
+			s.Fields[e.Name] = f
+			continue
+		}
+		if isNil(t) {
+			continue
+		}
+		f := newField(v, t, gen)
+		f.Order = uint(i) + 1
+		s.Fields[e.Name] = f
+	}
+	return s, nil, nil
+}
+
+// rawWhole reports whether the document d must be decoded whole, because it
+// may be a DBRef or a GeoJSON geometry.
+func rawWhole(d bson.RawD, gen *Generator) bool {
+	for _, e := range d {
+		switch e.Name {
+		case "$ref":
+			return true
+		case "coordinates":
+			if gen.GeoJSON != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rawType returns the type of the raw value, and the decoded value if
+// newField needs it. Arrays are decoded whole when their elements are
+// counted or followed as references.
+func rawType(raw bson.Raw, gen *Generator) (Type, interface{}, error) {
+	switch raw.Kind {
+	case bsonDocument:
+		return rawDocument(raw, gen)
+	case bsonArray:
+		if gen.Tuples || gen.LegacyCoords != "" || gen.ElementComments || gen.TypedRefs {
+			var a []interface{}
+			if err := raw.Unmarshal(&a); err != nil {
+				return nil, nil, err
+			}
+			return NewType(a, gen), a, nil
+		}
+		var a []bson.Raw
+		if err := raw.Unmarshal(&a); err != nil {
+			return nil, nil, err
+		}
+		// newField only needs the length of the array.
+		v := make([]interface{}, len(a))
+		var s Type
+		for _, e := range a {
+			t, _, err := rawType(e, gen)
+			if err != nil {
+				return nil, nil, err
+			}
+			if isNil(t) {
+				continue
+			}
+			if s == nil {
+				s = SliceType{Type: t}
+			} else {
+				s = s.Merge(SliceType{Type: t}, gen)
+			}
+		}
+		if s == nil {
+			return SliceType{Type: NilType}, v, nil
+		}
+		return s, v, nil
+	}
+	var v interface{}
+	if err := raw.Unmarshal(&v); err != nil {
+		return nil, nil, err
+	}
+	return NewType(v, gen), v, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// reference returns the document of c selected by its reference query,
+// keeping the order of its keys, nested documents included.
+func (m *mongoSource) reference(c Collection) (bson.D, error) {
+	filter, err := toBSON(c.Reference)
+	if err != nil {
+		return nil, fmt.Errorf("mongoschema: invalid reference for collection %s: %s", c.Name, err)
+	}
+	session := m.session.Copy()
+	defer session.Close()
+	var doc bson.D
+	err = session.DB(m.gen.DB).C(c.Name).Find(filter).SetMaxTime(m.gen.maxTime()).One(&doc)
+	if err == mgo.ErrNotFound {
+		return nil, fmt.Errorf("mongoschema: no document matches the reference of collection %s", c.Name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("mongoschema: reading the reference of collection %s: %s", c.Name, err)
+	}
+	return doc, nil
+}
+
+// applyReferences orders the fields of the roots of the collections that
+// have a reference document like the keys of that document, in place of
+// the first document scanned.
+func (s *Generator) applyReferences(src Source, roots []*StructType) error {
+	for i, c := range s.Collections {
+		if c.Reference == nil {
+			continue
+		}
+		m, ok := src.(*mongoSource)
+		if !ok {
+			return fmt.Errorf("mongoschema: the reference of collection %s requires the mongo source", c.Name)
+		}
+		doc, err := m.reference(c)
+		if err != nil {
+			return err
+		}
+		orderByReference(roots[i], doc)
+	}
+	return nil
+}
+
+// orderByReference numbers the fields of st in the order of the keys of
+// doc, and of its nested documents for the nested structs. The fields doc
+// lacks follow, in their order so far.
+func orderByReference(st *StructType, doc bson.D) {
+	pos := map[string]uint{}
+	for i, e := range doc {
+		pos[e.Name] = uint(i) + 1
+	}
+	var rest []string
+	for k := range st.Fields {
+		if pos[k] == 0 {
+			rest = append(rest, k)
+		}
+	}
+	sort.Slice(rest, func(i, j int) bool {
+		return st.less(rest[i], rest[j], FieldOrderDocument)
+	})
+	for _, e := range doc {
+		f, ok := st.Fields[e.Name]
+		if !ok {
+			continue
+		}
+		f.Order = pos[e.Name]
+		if nested, d := nestedStruct(f.Type), referenceDoc(e.Value); nested != nil && d != nil {
+			orderByReference(nested, d)
+		}
+	}
+	for i, k := range rest {
+		if st.Fields[k].Order != 0 {
+			st.Fields[k].Order = uint(len(doc) + i + 1)
+		}
+	}
+}
+
+// referenceDoc returns the document v holds, or the first of the array v,
+// if any.
+func referenceDoc(v interface{}) bson.D {
+	switch v := v.(type) {
+	case bson.D:
+		return v
+	case []interface{}:
+		for _, e := range v {
+			if d := referenceDoc(e); d != nil {
+				return d
+			}
+		}
+	}
+	return nil
+}
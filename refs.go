@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// isDBRef reports whether m is a DBRef, which has a $ref and an $id and may
+// have a $db.
+func isDBRef(m bson.M) bool {
+	return m["$ref"] != nil && m["$id"] != nil
+}
+
+// dbrefTargets counts the collections referenced by v, if it is a DBRef or
+// a slice of them.
+func dbrefTargets(v interface{}) map[string]uint {
+	var refs map[string]uint
+	add := func(e interface{}) {
+		m, ok := e.(bson.M)
+		if !ok || !isDBRef(m) {
+			return
+		}
+		// References to other databases cannot be followed.
+		if db, ok := m["$db"].(string); ok && db != "" {
+			return
+		}
+		if ref, ok := m["$ref"].(string); ok {
+			if refs == nil {
+				refs = map[string]uint{}
+			}
+			refs[ref]++
+		}
+	}
+	if a, ok := v.([]interface{}); ok {
+		for _, e := range a {
+			add(e)
+		}
+	} else {
+		add(v)
+	}
+	return refs
+}
+
+// collectRefs adds the collections referenced from t to refs.
+func collectRefs(t Type, refs map[string]bool) {
+	switch v := t.(type) {
+	case *StructType:
+		for _, f := range v.Fields {
+			for ref := range f.Refs {
+				refs[ref] = true
+			}
+			collectRefs(f.Type, refs)
+		}
+	case SliceType:
+		collectRefs(v.Type, refs)
+	case MixedType:
+		for _, e := range v {
+			collectRefs(e, refs)
+		}
+	}
+}
+
+// followRefs scans the collections referenced by DBRefs that are not
+// configured, sampling up to RefLimit documents of each, and appends them to
+// s.Collections and their types to roots. The collections they reference in
+// turn are followed up to RefDepth levels deep.
+func (s *Generator) followRefs(ctx context.Context, src Source, roots []*StructType) ([]*StructType, error) {
+	scanned := map[string]bool{}
+	for _, c := range s.Collections {
+		scanned[c.Name] = true
+	}
+	from := roots
+	for depth := 0; depth < s.RefDepth; depth++ {
+		refs := map[string]bool{}
+		for _, root := range from {
+			collectRefs(root, refs)
+		}
+		var cs []Collection
+		for ref := range refs {
+			if !scanned[ref] {
+				scanned[ref] = true
+				limit := s.RefLimit
+				if limit == 0 {
+					limit = defaultSampleSize
+				}
+				cs = append(cs, Collection{Name: ref, Struct: s.structName(ref), limit: limit})
+			}
+		}
+		if len(cs) == 0 {
+			break
+		}
+		sort.Slice(cs, func(i, j int) bool { return cs[i].Name < cs[j].Name })
+		s.logger().Info("following DBRefs", "depth", depth+1, "collections", len(cs))
+		var err error
+		if from, err = s.scanAll(ctx, src, cs); err != nil {
+			return nil, err
+		}
+		cs, from = s.keepScanned(cs, from)
+		s.Collections = append(s.Collections, cs...)
+		roots = append(roots, from...)
+	}
+	return roots, nil
+}
+
+// refs replaces the DBRef fields of schema that only reference a single
+// scanned collection with a named DBRef type for that collection, like
+// UserRef for users, and returns the declarations of the types that are new.
+func (h *hoister) refs(schema Schema) []NamedType {
+	h.decls = nil
+	structs := map[string]string{}
+	for _, c := range h.gen.Collections {
+		structs[c.Name] = c.Struct
+	}
+	seen := map[*StructType]bool{}
+	h.refFields(schema.Root, structs, seen)
+	for _, n := range schema.Decls {
+		if st, ok := n.Type.(*StructType); ok {
+			h.refFields(st, structs, seen)
+		}
+	}
+	return h.decls
+}
+
+func (h *hoister) refFields(s *StructType, structs map[string]string, seen map[*StructType]bool) {
+	if seen[s] {
+		return
+	}
+	seen[s] = true
+	for _, k := range s.keys(h.gen) {
+		f := s.Fields[k]
+		if len(f.Refs) == 1 {
+			for ref := range f.Refs {
+				if name, ok := structs[ref]; ok {
+					f.Type = h.refType(f.Type, ref, name)
+				}
+			}
+		}
+		h.refTypesIn(f.Type, structs, seen)
+	}
+}
+
+func (h *hoister) refTypesIn(t Type, structs map[string]string, seen map[*StructType]bool) {
+	switch v := t.(type) {
+	case *StructType:
+		h.refFields(v, structs, seen)
+	case NamedType:
+		if st, ok := v.Type.(*StructType); ok {
+			h.refFields(st, structs, seen)
+		}
+	case SliceType:
+		h.refTypesIn(v.Type, structs, seen)
+	case MapType:
+		h.refTypesIn(v.Value, structs, seen)
+	case MixedType:
+		for _, e := range v {
+			h.refTypesIn(e, structs, seen)
+		}
+	}
+}
+
+// refType replaces PrimitiveDBRef in t, which is a DBRef or a slice of them,
+// with the named DBRef type of the collection ref.
+func (h *hoister) refType(t Type, ref, structName string) Type {
+	if s, ok := t.(SliceType); ok {
+		return SliceType{Type: h.refType(s.Type, ref, structName)}
+	}
+	if t != PrimitiveDBRef {
+		return t
+	}
+	name, ok := h.refTypes[ref]
+	if !ok {
+		name = h.unique(fmt.Sprintf("%sRef", structName))
+		h.refTypes[ref] = name
+		h.decls = append(h.decls, NamedType{Name: name, Type: PrimitiveDBRef})
+	}
+	return NamedType{Name: name, Type: PrimitiveDBRef}
+}
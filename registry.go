@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RegistryConfig configures publishing the schema of each collection to a
+// Confluent-compatible schema registry.
+type RegistryConfig struct {
+	URL string `yaml:"url"`
+	// Format is that of the schemas registered, avro or jsonschema, and
+	// the output format by default.
+	Format   string `yaml:"format"`
+	Subject  string `yaml:"subject"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// The subject naming strategies of Confluent serializers.
+const (
+	// SubjectTopic names the subject of a collection after the collection,
+	// as the topic its changes are published to: orders-value.
+	SubjectTopic = "topic"
+	// SubjectRecord names it after the record: com.example.Order.
+	SubjectRecord = "record"
+	// SubjectTopicRecord names it after both: orders-com.example.Order.
+	SubjectTopicRecord = "topic_record"
+)
+
+const (
+	registryContentType = "application/vnd.schemaregistry.v1+json"
+	registryTimeout     = 30 * time.Second
+	// registryNotFound is the error code of the registry for a subject
+	// without versions, which has nothing to be incompatible with.
+	registryNotFound = 40401
+)
+
+// registryFormat returns the format of the schemas registered.
+func (s *Generator) registryFormat() string {
+	if s.Registry.Format != "" {
+		return s.Registry.Format
+	}
+	return s.Format
+}
+
+// subject returns the subject the schema of c is registered under.
+func (s *Generator) subject(c Collection) string {
+	record := c.Struct
+	namespace := s.AvroNamespace
+	if namespace == "" {
+		namespace = s.Package
+	}
+	if namespace != "" {
+		record = namespace + "." + record
+	}
+	switch s.Registry.Subject {
+	case SubjectRecord:
+		return record
+	case SubjectTopicRecord:
+		return c.Name + "-" + record
+	}
+	return c.Name + "-value"
+}
+
+// publish checks the schema of each collection for compatibility with the
+// latest version of its subject, and registers it as a new version if they
+// all are. Registering a schema identical to the latest version is a no-op
+// of the registry.
+func (s *Generator) publish(ctx context.Context, schemas []Schema) error {
+	g := *s
+	g.Format = s.registryFormat()
+	docs := make([]string, len(schemas))
+	for i, schema := range schemas {
+		var buf bytes.Buffer
+		if err := g.render(&buf, []Schema{schema}); err != nil {
+			return err
+		}
+		docs[i] = strings.TrimSpace(buf.String())
+	}
+	for i, schema := range schemas {
+		if err := s.checkCompatibility(ctx, s.subject(schema.Collection), docs[i]); err != nil {
+			return err
+		}
+	}
+	for i, schema := range schemas {
+		subject := s.subject(schema.Collection)
+		var result struct {
+			ID int `json:"id"`
+		}
+		if err := s.registryPost(ctx, "/subjects/"+url.PathEscape(subject)+"/versions", docs[i], &result); err != nil {
+			return fmt.Errorf("mongoschema: registering %s: %s", subject, err)
+		}
+		s.logger().Info("schema registered", "collection", schema.Collection.Name, "subject", subject, "id", result.ID)
+	}
+	return nil
+}
+
+// checkCompatibility returns an error describing why doc is not compatible
+// with the latest version of subject, if it is not.
+func (s *Generator) checkCompatibility(ctx context.Context, subject, doc string) error {
+	var result struct {
+		IsCompatible bool     `json:"is_compatible"`
+		Messages     []string `json:"messages"`
+	}
+	path := "/compatibility/subjects/" + url.PathEscape(subject) + "/versions/latest?verbose=true"
+	err := s.registryPost(ctx, path, doc, &result)
+	if e, ok := err.(*registryError); ok && e.Code == registryNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("mongoschema: checking the compatibility of %s: %s", subject, err)
+	}
+	if !result.IsCompatible {
+		msg := fmt.Sprintf("mongoschema: schema is incompatible with the latest version of %s", subject)
+		if len(result.Messages) > 0 {
+			msg += ": " + strings.Join(result.Messages, "; ")
+		}
+		return fmt.Errorf("%s", msg)
+	}
+	return nil
+}
+
+// registryError is an error returned by the registry.
+type registryError struct {
+	Code    int    `json:"error_code"`
+	Message string `json:"message"`
+}
+
+func (e *registryError) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+// registryPost posts the schema doc to path of the registry and decodes the
+// response into result.
+func (s *Generator) registryPost(ctx context.Context, path, doc string, result interface{}) error {
+	req := map[string]string{"schema": doc}
+	if s.registryFormat() == FormatJSONSchema {
+		req["schemaType"] = "JSON"
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, registryTimeout)
+	defer cancel()
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(s.Registry.URL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", registryContentType)
+	r.Header.Set("Accept", registryContentType)
+	if s.Registry.Username != "" {
+		r.SetBasicAuth(s.Registry.Username, s.Registry.Password)
+	}
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		e := &registryError{}
+		if json.Unmarshal(b, e) != nil || e.Message == "" {
+			e.Code, e.Message = resp.StatusCode, strings.TrimSpace(string(b))
+		}
+		return e
+	}
+	return json.Unmarshal(b, result)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+)
+
+// Renderer writes a schema in an output format of its own. The schemas of a
+// single output file are rendered one after the other.
+type Renderer interface {
+	Render(schema Schema, w io.Writer) error
+}
+
+// registeredRenderer is a renderer and the extension of the files it writes.
+type registeredRenderer struct {
+	Renderer
+	ext string
+}
+
+var renderers = map[string]registeredRenderer{}
+
+var builtinFormats = []string{FormatGo, FormatJSONSchema, FormatValidator, FormatTypeScript,
+	FormatProtobuf, FormatAvro, FormatOpenAPI, FormatSQL, FormatMongoose, FormatGraphQL,
+	FormatKotlin, FormatJava, FormatPython, FormatRust, FormatFlat}
+
+// RegisterRenderer makes the renderer r available as the output format
+// format, writing files with the extension ext. It is meant to be called from
+// the init function of a file added to this package, and panics if the
+// format is already taken.
+func RegisterRenderer(format, ext string, r Renderer) {
+	if sscontains(builtinFormats, format) {
+		panic("mongoschema: RegisterRenderer called for built-in format " + format)
+	}
+	if _, ok := renderers[format]; ok {
+		panic("mongoschema: RegisterRenderer called twice for format " + format)
+	}
+	renderers[format] = registeredRenderer{Renderer: r, ext: ext}
+}
+
+// RendererConfig configures an output format rendered by an external
+// command. For each schema, the command is run with a JSON document on its
+// stdin holding the package, the collection, the struct name, the root type
+// and the named types, encoded like the types in the state file, and writes
+// the rendered schema to its stdout. Primitive types are numbered, and the
+// primitives of the document list their Go types by number.
+type RendererConfig struct {
+	Format  string   `yaml:"format"`
+	Command []string `yaml:"command"`
+	Ext     string   `yaml:"ext"`
+}
+
+// formats returns the names of the built-in, registered and configured
+// output formats.
+func (s *Generator) formats() []string {
+	var custom []string
+	for format := range renderers {
+		custom = append(custom, format)
+	}
+	for _, r := range s.Renderers {
+		custom = append(custom, r.Format)
+	}
+	sort.Strings(custom)
+	return append(append([]string(nil), builtinFormats...), custom...)
+}
+
+// renderer returns the renderer of a format that is not built in.
+func (s *Generator) renderer() (registeredRenderer, bool) {
+	for _, r := range s.Renderers {
+		if r.Format == s.Format {
+			return registeredRenderer{Renderer: &execRenderer{gen: s, command: r.Command}, ext: r.Ext}, true
+		}
+	}
+	r, ok := renderers[s.Format]
+	return r, ok
+}
+
+// execRenderer renders schemas by running a command.
+type execRenderer struct {
+	gen     *Generator
+	command []string
+}
+
+type schemaJSON struct {
+	Package    string      `json:"package,omitempty"`
+	Collection string      `json:"collection"`
+	Struct     string      `json:"struct"`
+	Root       *typeJSON   `json:"root"`
+	Decls      []namedJSON `json:"decls,omitempty"`
+	Primitives []string    `json:"primitives"`
+}
+
+type namedJSON struct {
+	Name string    `json:"name"`
+	Type *typeJSON `json:"type"`
+}
+
+func (r *execRenderer) Render(schema Schema, w io.Writer) error {
+	doc := schemaJSON{
+		Package:    r.gen.Package,
+		Collection: schema.Collection.Name,
+		Struct:     schema.Collection.Struct,
+		Root:       encodeType(schema.Root),
+	}
+	for _, n := range schema.Decls {
+		doc.Decls = append(doc.Decls, namedJSON{Name: n.Name, Type: encodeType(n.Type)})
+	}
+	for p := PrimitiveBinary; p <= PrimitiveUUID; p++ {
+		doc.Primitives = append(doc.Primitives, p.GoType(r.gen))
+	}
+	in, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(r.command[0], r.command[1:]...)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("mongoschema: rendering %s with %s: %s", schema.Collection.Name, r.command[0], err)
+	}
+	return nil
+}
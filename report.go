@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The exit codes of a run, for scripts and CI pipelines.
+const (
+	ExitOK    = 0
+	ExitError = 1
+	// ExitConflicts is returned when the code was generated but fields of
+	// mixed type were found that no override resolves.
+	ExitConflicts = 2
+	// ExitConnection is returned when the server could not be reached.
+	ExitConnection = 3
+)
+
+// runReport is the machine-readable report of a run, written as JSON to the
+// file of Report. It is shared by the copies of the generator made for each
+// database and package, and a nil report records nothing.
+type runReport struct {
+	Status      string              `json:"status"`
+	ExitCode    int                 `json:"exit_code"`
+	Duration    string              `json:"duration"`
+	Docs        uint64              `json:"docs_scanned"`
+	Collections []*collectionReport `json:"collections"`
+	Error       string              `json:"error,omitempty"`
+
+	mu         sync.Mutex
+	start      time.Time
+	connFailed bool
+}
+
+// collectionReport is the part of a report about one collection.
+type collectionReport struct {
+	DB         string        `json:"db,omitempty"`
+	Name       string        `json:"name"`
+	Docs       uint64        `json:"docs_scanned"`
+	Duration   string        `json:"duration"`
+	Fields     int           `json:"fields"`
+	MixedTypes int           `json:"mixed_types"`
+	Lint       []lintFinding `json:"lint,omitempty"`
+	Errors     []string      `json:"errors,omitempty"`
+	// Sizes and FieldCounts are the distributions of the sizes in bytes and
+	// of the numbers of fields of the documents scanned.
+	Sizes       *distribution `json:"doc_sizes,omitempty"`
+	FieldCounts *distribution `json:"doc_fields,omitempty"`
+}
+
+func newRunReport() *runReport {
+	return &runReport{start: time.Now(), Collections: []*collectionReport{}}
+}
+
+// collection returns the report of the collection name of db, adding it the
+// first time. The caller holds r.mu.
+func (r *runReport) collection(db, name string) *collectionReport {
+	for _, c := range r.Collections {
+		if c.DB == db && c.Name == name {
+			return c
+		}
+	}
+	c := &collectionReport{DB: db, Name: name}
+	r.Collections = append(r.Collections, c)
+	return c
+}
+
+// addDocs adds n documents to those scanned of the collection name, which
+// may be scanned in several parts.
+func (r *runReport) addDocs(db, name string, n uint) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collection(db, name).Docs += uint64(n)
+	r.Docs += uint64(n)
+}
+
+// scanned records the scan of the collection name, which took elapsed and
+// found the raw type root, or failed with err.
+func (r *runReport) scanned(db, name string, root *StructType, elapsed time.Duration, err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := r.collection(db, name)
+	c.Duration = elapsed.Round(time.Millisecond).String()
+	if err != nil {
+		c.Errors = append(c.Errors, err.Error())
+	}
+	if root != nil {
+		c.Fields = countFields(root, func(*Field) bool { return true })
+	}
+}
+
+// docStats adds the statistics of documents scanned of the collection name,
+// which may be scanned in several parts, to its report.
+func (r *runReport) docStats(db, name string, stats *docStats) {
+	if r == nil || stats == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := r.collection(db, name)
+	if stats.sizes.count > 0 {
+		if c.Sizes == nil {
+			c.Sizes = &distribution{}
+		}
+		c.Sizes.merge(&stats.sizes)
+	}
+	if stats.fields.count > 0 {
+		if c.FieldCounts == nil {
+			c.FieldCounts = &distribution{}
+		}
+		c.FieldCounts.merge(&stats.fields)
+	}
+}
+
+// mixed records the number of fields of the collection name left of mixed
+// type once overridden.
+func (r *runReport) mixed(db, name string, n int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collection(db, name).MixedTypes = n
+}
+
+// lint records the smells found in the collection name.
+func (r *runReport) lint(db, name string, findings []lintFinding) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collection(db, name).Lint = findings
+}
+
+// connectionFailed records that the server could not be reached.
+func (r *runReport) connectionFailed() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.connFailed = true
+}
+
+// exitCode returns the exit code of the run, which ended with err.
+func (r *runReport) exitCode(err error) int {
+	if r == nil {
+		if err != nil {
+			return ExitError
+		}
+		return ExitOK
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case err != nil && r.connFailed:
+		return ExitConnection
+	case err != nil:
+		return ExitError
+	}
+	for _, c := range r.Collections {
+		if c.MixedTypes > 0 {
+			return ExitConflicts
+		}
+	}
+	return ExitOK
+}
+
+// write completes the report of the run, which ended with err, and writes
+// it to the file name, or to stdout if name is "-".
+func (r *runReport) write(name string, err error) error {
+	code := r.exitCode(err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ExitCode = code
+	r.Status = map[int]string{
+		ExitOK:         "ok",
+		ExitError:      "error",
+		ExitConflicts:  "conflicts",
+		ExitConnection: "connection_error",
+	}[code]
+	r.Duration = time.Since(r.start).Round(time.Millisecond).String()
+	if err != nil {
+		r.Error = err.Error()
+	}
+	sort.SliceStable(r.Collections, func(i, j int) bool {
+		a, b := r.Collections[i], r.Collections[j]
+		if a.DB != b.DB {
+			return a.DB < b.DB
+		}
+		return a.Name < b.Name
+	})
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if name == "-" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	return ioutil.WriteFile(name, b, 0644)
+}
+
+// countFields returns the number of fields of st, nested ones included,
+// for which fn is true.
+func countFields(st *StructType, fn func(*Field) bool) int {
+	n := 0
+	for _, f := range st.Fields {
+		if fn(f) {
+			n++
+		}
+		if nested := nestedStruct(f.Type); nested != nil {
+			n += countFields(nested, fn)
+		}
+	}
+	return n
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// loadOverrides adds the overrides of OverridesFile, if it exists, to those
+// of the config, which take precedence.
+func (s *Generator) loadOverrides() error {
+	b, err := ioutil.ReadFile(s.OverridesFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var file map[string]string
+	if err := yaml.UnmarshalStrict(b, &file); err != nil {
+		return fmt.Errorf("mongoschema: %s: %s", s.OverridesFile, err)
+	}
+	// The map of the config may be shared with the generators of other
+	// databases, so a new one is made.
+	overrides := make(map[string]string, len(s.Overrides)+len(file))
+	for key, name := range file {
+		overrides[key] = name
+	}
+	for key, name := range s.Overrides {
+		overrides[key] = name
+	}
+	s.Overrides = overrides
+	return nil
+}
+
+// saveOverride records that the field key has the Go type name in
+// OverridesFile, keeping the overrides already there.
+func (s *Generator) saveOverride(key, name string) error {
+	file := map[string]string{}
+	b, err := ioutil.ReadFile(s.OverridesFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := yaml.Unmarshal(b, &file); err != nil {
+		return fmt.Errorf("mongoschema: %s: %s", s.OverridesFile, err)
+	}
+	file[key] = name
+	if b, err = yaml.Marshal(file); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.OverridesFile, b, 0644)
+}
+
+// mixedField is a field of mixed type, keyed like overrides.
+type mixedField struct {
+	key   string
+	types MixedType
+}
+
+// mixedFields returns the fields of mixed type of st and its nested structs,
+// looking through slices of nested documents like overrides do.
+func (s *Generator) mixedFields(st *StructType, prefix string) []mixedField {
+	var fields []mixedField
+	for _, k := range st.keys(s) {
+		t := st.Fields[k].Type
+		if m, ok := t.(MixedType); ok {
+			fields = append(fields, mixedField{key: prefix + k, types: m})
+			continue
+		}
+		for {
+			slice, ok := t.(SliceType)
+			if !ok {
+				break
+			}
+			t = slice.Type
+		}
+		if nested, ok := t.(*StructType); ok {
+			fields = append(fields, s.mixedFields(nested, prefix+k+".")...)
+		}
+	}
+	return fields
+}
+
+// overrideChoices returns the Go types a field of type m may be overridden
+// with, qualified by their import paths: those of its members, except
+// nested documents, and interface{}.
+func (s *Generator) overrideChoices(m MixedType) []string {
+	var choices []string
+	for _, t := range m {
+		if isNil(t) || nestedStruct(t) != nil {
+			continue
+		}
+		prefix, elem := "", t
+		for {
+			slice, ok := elem.(SliceType)
+			if !ok {
+				break
+			}
+			prefix, elem = prefix+"[]", slice.Type
+		}
+		name := elem.GoType(s)
+		if i := strings.Index(name, "."); i > 0 && strings.Contains(s.importPath(elem), "/") {
+			name = s.importPath(elem) + name[i:]
+		}
+		if !sscontains(choices, prefix+name) {
+			choices = append(choices, prefix+name)
+		}
+	}
+	return append(choices, "interface{}")
+}
+
+// Resolve scans the collections and asks, reading the answers from in, for
+// the Go type of each field of mixed type not overridden yet. The choices
+// are recorded in OverridesFile as they are made, so that later runs reuse
+// them, and the code is then generated with them.
+func (s *Generator) Resolve(ctx context.Context, in io.Reader, out io.Writer) error {
+	if len(s.Databases) > 0 {
+		return errDatabases
+	}
+	if s.OverridesFile == "" {
+		return errors.New("mongoschema: resolve requires overrides_file")
+	}
+	if err := s.loadOverrides(); err != nil {
+		return err
+	}
+	run, cancel := s.withTimeout(ctx)
+	defer cancel()
+	src, err := s.source(run)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	roots, err := s.scanRoots(run, src)
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return interrupted(ctx)
+	}
+	if err := s.askOverrides(roots, in, out); err != nil {
+		return err
+	}
+	schemas, err := s.transform(roots)
+	if err != nil {
+		return err
+	}
+	return s.write(schemas)
+}
+
+// askOverrides asks for the Go type of each field of mixed type of the raw
+// types roots not overridden yet, until in ends or the user stops, and
+// records the answers.
+func (s *Generator) askOverrides(roots []*StructType, in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	read := func(prompt string) (string, bool) {
+		fmt.Fprint(out, prompt)
+		if !scanner.Scan() {
+			return "", false
+		}
+		return strings.TrimSpace(scanner.Text()), true
+	}
+	if s.Overrides == nil {
+		s.Overrides = map[string]string{}
+	}
+prompts:
+	for i, c := range s.Collections {
+	fields:
+		for _, f := range s.mixedFields(roots[i], "") {
+			key := c.Name + "." + f.key
+			if _, ok := s.Overrides[key]; ok {
+				continue
+			}
+			choices := s.overrideChoices(f.types)
+			fmt.Fprintf(out, "%s holds %s\n", key, shortType(f.types, s))
+			for n, choice := range choices {
+				fmt.Fprintf(out, "  %d) %s\n", n+1, choice)
+			}
+			fmt.Fprintf(out, "  %d) other type\n  s) skip\n  q) stop asking\n", len(choices)+1)
+			name := ""
+			for name == "" {
+				answer, ok := read("choice> ")
+				switch n, err := strconv.Atoi(answer); {
+				case !ok || answer == "q":
+					break prompts
+				case answer == "s":
+					continue fields
+				case err == nil && n >= 1 && n <= len(choices):
+					name = choices[n-1]
+				case err == nil && n == len(choices)+1:
+					if name, ok = read("type> "); !ok {
+						break prompts
+					}
+				default:
+					fmt.Fprintln(out, "enter a number, s or q")
+				}
+			}
+			if err := s.saveOverride(key, name); err != nil {
+				return err
+			}
+			s.Overrides[key] = name
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Bounds of the exponential backoff between retries of a failed scan.
+const (
+	retryBackoff    = time.Second
+	maxRetryBackoff = 30 * time.Second
+)
+
+// backoff returns the delay before the nth retry, counting from zero.
+func backoff(n int) time.Duration {
+	d := retryBackoff
+	for i := 0; i < n && d < maxRetryBackoff; i++ {
+		d *= 2
+	}
+	if d > maxRetryBackoff {
+		return maxRetryBackoff
+	}
+	return d
+}
+
+// retries returns whether a scan of src that failed n times is retried.
+// Only scans of a live server fail transiently.
+func (s *Generator) retries(src Source, n int) bool {
+	_, ok := src.(*mongoSource)
+	return ok && n < s.Retries
+}
+
+// resume returns the collection to scan after seen documents of c, the last
+// of which had the _id last. A natural scan, which is sorted by _id when
+// retries are enabled, continues after last, and a scan with its own sort
+// skips the documents seen. A sample is simply taken again with the size
+// left.
+func (s *Generator) resume(c Collection, last interface{}, seen uint) (Collection, error) {
+	if seen == 0 {
+		return c, nil
+	}
+	limit := s.limit(c)
+	if s.sampling(c) != SamplingNatural {
+		if limit == 0 {
+			limit = defaultSampleSize
+		}
+		c.limit = limit - seen
+		return c, nil
+	}
+	if limit != 0 {
+		c.limit = limit - seen
+	}
+	if len(c.Sort) > 0 {
+		c.skip = seen
+		return c, nil
+	}
+	if last == nil {
+		return c, fmt.Errorf("mongoschema: cannot resume the scan of %s: documents have no _id", c.Name)
+	}
+	filter, err := toBSON(c.Filter)
+	if err != nil {
+		return c, fmt.Errorf("mongoschema: invalid filter for collection %s: %s", c.Name, err)
+	}
+	after := bson.M{"_id": bson.M{"$gt": last}}
+	if filter != nil {
+		c.Filter = bson.M{"$and": []interface{}{filter, after}}
+	} else {
+		c.Filter = after
+	}
+	return c, nil
+}
+
+// sleep waits for d, and reports whether it did so before ctx was done.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,8 @@
+//go:build windows || plan9 || js || wasip1
+
+package main
+
+// peakRSS is not available on this platform.
+func peakRSS() (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// rustPrimitives are the Rust types of primitives, with the path to use them
+// from, if any. Values the bson crate has no plain Rust type for keep its
+// own types.
+var rustPrimitives = map[PrimitiveType][2]string{
+	PrimitiveBool:           {"bool", ""},
+	PrimitiveDouble:         {"f64", ""},
+	PrimitiveInt32:          {"i32", ""},
+	PrimitiveInt64:          {"i64", ""},
+	PrimitiveObjectId:       {"ObjectId", "bson::oid::ObjectId"},
+	PrimitiveString:         {"String", ""},
+	PrimitiveTimestamp:      {"DateTime<Utc>", "chrono::{DateTime, Utc}"},
+	PrimitiveDecimal128:     {"Decimal128", "bson::Decimal128"},
+	PrimitiveBinary:         {"Binary", "bson::Binary"},
+	PrimitiveBytes:          {"Binary", "bson::Binary"},
+	PrimitiveUUID:           {"Uuid", "bson::Uuid"},
+	PrimitiveRegEx:          {"Regex", "bson::Regex"},
+	PrimitiveMongoTimestamp: {"Timestamp", "bson::Timestamp"},
+	PrimitiveDBRef:          {"Document", "bson::Document"},
+}
+
+// rustDateTime is the module of the bson crate's helpers serializing a
+// chrono DateTime as a BSON date rather than a string.
+const rustDateTime = "bson::serde_helpers::chrono_datetime_as_bson_datetime"
+
+var rustKeywords = map[string]bool{
+	"as": true, "async": true, "await": true, "break": true, "const": true, "continue": true,
+	"dyn": true, "else": true, "enum": true, "extern": true, "false": true, "fn": true,
+	"for": true, "if": true, "impl": true, "in": true, "let": true, "loop": true,
+	"match": true, "mod": true, "move": true, "mut": true, "pub": true, "ref": true,
+	"return": true, "static": true, "struct": true, "trait": true, "true": true, "type": true,
+	"unsafe": true, "use": true, "where": true, "while": true, "abstract": true, "become": true,
+	"box": true, "do": true, "final": true, "macro": true, "override": true, "priv": true,
+	"try": true, "typeof": true, "unsized": true, "virtual": true, "yield": true,
+}
+
+// rustWriter renders Rust structs deriving serde's Serialize and Deserialize,
+// for the bson crate. Nested structs become structs of their own named after
+// the struct and field embedding them, declared after it.
+type rustWriter struct {
+	declWalker
+	gen  *Generator
+	buf  bytes.Buffer
+	uses map[string]bool
+}
+
+// renderRust writes a struct for every collection and named struct. A field
+// is an Option unless it was present and not null in every document.
+func (s *Generator) renderRust(w io.Writer, schemas []Schema) error {
+	r := &rustWriter{declWalker: newDeclWalker(schemas), gen: s, uses: map[string]bool{"serde::{Deserialize, Serialize}": true}}
+	r.walk(schemas, s.collectionNote, r.strct, nil)
+
+	var uses []string
+	for u := range r.uses {
+		uses = append(uses, u)
+	}
+	sort.Strings(uses)
+	for _, u := range uses {
+		fmt.Fprintf(w, "use %s;\n", u)
+	}
+	fmt.Fprintln(w)
+	_, err := fmt.Fprintln(w, strings.TrimRight(r.buf.String(), "\n"))
+	return err
+}
+
+// strct declares the struct name with the fields of st, documented by note
+// if it is not empty.
+func (r *rustWriter) strct(name string, st *StructType, note string) {
+	if note != "" {
+		fmt.Fprintf(&r.buf, "/// %s\n", note)
+	}
+	fmt.Fprintf(&r.buf, "#[derive(Debug, Clone, Serialize, Deserialize)]\npub struct %s {\n", name)
+	used := map[string]bool{}
+	for _, k := range st.keys(r.gen) {
+		f := st.Fields[k]
+		fieldName := rustFieldName(k, used)
+		typ := r.fieldType(f.Type, name+r.gen.makeFieldName(k))
+		optional := !st.required(k) || f.Nulls > 0
+		var attrs []string
+		if strings.TrimPrefix(fieldName, "r#") != k {
+			attrs = append(attrs, "rename = "+strconv.Quote(k))
+		}
+		if typ == "DateTime<Utc>" {
+			helper := rustDateTime
+			if optional {
+				helper += "_optional"
+			}
+			attrs = append(attrs, "with = "+strconv.Quote(helper))
+		}
+		if optional {
+			typ = "Option<" + typ + ">"
+			attrs = append(attrs, "default", `skip_serializing_if = "Option::is_none"`)
+		}
+		if r.gen.PresenceComments && !st.synthetic {
+			fmt.Fprintf(&r.buf, "    /// Present in %s.\n", st.presence(k))
+		}
+		if len(attrs) > 0 {
+			fmt.Fprintf(&r.buf, "    #[serde(%s)]\n", strings.Join(attrs, ", "))
+		}
+		fmt.Fprintf(&r.buf, "    pub %s: %s,\n", fieldName, typ)
+	}
+	fmt.Fprint(&r.buf, "}\n\n")
+}
+
+// fieldType returns the type of t, naming the struct of a nested struct
+// name.
+func (r *rustWriter) fieldType(t Type, name string) string {
+	switch v := t.(type) {
+	case *StructType:
+		return r.nested(name, v)
+	case SliceType:
+		return r.vec(v.Type, name)
+	case TupleType:
+		if e, ok := v.uniform(r.gen); ok {
+			return r.vec(e, name)
+		}
+		return r.vec(nil, name)
+	case MapType:
+		r.uses["std::collections::HashMap"] = true
+		return "HashMap<String, " + r.element(v.Value, name) + ">"
+	case NamedType:
+		if _, ok := v.Type.(*StructType); ok {
+			return v.Name
+		}
+		return r.fieldType(v.Type, name)
+	case OverrideType:
+		return r.fieldType(v.Inferred, name)
+	case GeoJSONType:
+		return r.fieldType(v.Struct(), name)
+	case LegacyPointType:
+		return "Vec<f64>"
+	case EnumType:
+		return "String"
+	case PrimitiveType:
+		if p, ok := rustPrimitives[v]; ok {
+			if p[1] != "" {
+				r.uses[p[1]] = true
+			}
+			return p[0]
+		}
+	}
+	r.uses["bson::Bson"] = true
+	return "Bson"
+}
+
+// vec returns a Vec of elem, or of any BSON value if elem is unknown.
+func (r *rustWriter) vec(elem Type, name string) string {
+	if isNil(elem) {
+		r.uses["bson::Bson"] = true
+		return "Vec<Bson>"
+	}
+	return "Vec<" + r.element(elem, name) + ">"
+}
+
+// element returns the type of the elements of a Vec or HashMap, of which
+// dates are kept as bson::DateTime, named in full as chrono's is used: the
+// serde helpers for chrono only apply to fields.
+func (r *rustWriter) element(t Type, name string) string {
+	if t == PrimitiveTimestamp {
+		return "bson::DateTime"
+	}
+	return r.fieldType(t, name)
+}
+
+// rustFieldName returns the snake-cased name of the key k, as a raw
+// identifier if it is a keyword, and records it in used. The keywords that
+// cannot be raw identifiers get a trailing underscore instead.
+func rustFieldName(k string, used map[string]bool) string {
+	name := strings.TrimLeft(convertCase(k, CaseSnake), "_")
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "f" + name
+	}
+	switch name {
+	case "self", "super", "crate":
+		name += "_"
+	}
+	for base, i := name, 2; used[name]; i++ {
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+	used[name] = true
+	if rustKeywords[name] {
+		return "r#" + name
+	}
+	return name
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// smartSample samples the oldest and the newest documents of collection by
+// _id, a third of size each, and a random selection of the rest, so that
+// both the earliest and the current shapes of the documents are seen. The
+// random selection may repeat some of the oldest or newest documents.
+func (s *Generator) smartSample(collection *mgo.Collection, filter, projection interface{}, size uint) Iter {
+	edge := int(size / 3)
+	var pipeline []bson.M
+	if filter != nil {
+		pipeline = append(pipeline, bson.M{"$match": filter})
+	}
+	pipeline = append(pipeline, bson.M{"$sample": bson.M{"size": size - 2*uint(edge)}})
+	if projection != nil {
+		pipeline = append(pipeline, bson.M{"$project": projection})
+	}
+	iters := []Iter{s.pipe(collection, pipeline, false)}
+	if edge > 0 {
+		edges := func(sort string) Iter {
+			q := collection.Find(filter).Sort(sort).Limit(edge).SetMaxTime(s.maxTime())
+			if projection != nil {
+				q = q.Select(projection)
+			}
+			return q.Iter()
+		}
+		iters = append(iters, edges("_id"), edges("-_id"))
+	}
+	return &multiIter{iters: iters}
+}
+
+// multiIter iterates over several iterators in turn.
+type multiIter struct {
+	iters []Iter
+	i     int
+}
+
+func (m *multiIter) Next(result interface{}) bool {
+	for m.i < len(m.iters) {
+		if m.iters[m.i].Next(result) {
+			return true
+		}
+		m.i++
+	}
+	return false
+}
+
+// Close closes all the iterators and returns the first error.
+func (m *multiIter) Close() error {
+	var err error
+	for _, iter := range m.iters {
+		if e := iter.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
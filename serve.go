@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"gopkg.in/mgo.v2"
+)
+
+// schemaRequest is the body of a request to the schema endpoint. The URL
+// must be the url or one of the clusters of the config, and may instead be
+// given by the name of the cluster.
+type schemaRequest struct {
+	URL        string `json:"url"`
+	Cluster    string `json:"cluster"`
+	DB         string `json:"db"`
+	Collection string `json:"collection"`
+	Struct     string `json:"struct"`
+	Limit      uint   `json:"limit"`
+	Format     string `json:"format"`
+}
+
+// server infers schemas on request, with the settings of its config. It
+// keeps a session per cluster, which requests copy from, and paces the
+// scans of all requests with limiter, if rate_limit is set.
+type server struct {
+	gen      *Generator
+	limiter  *rateLimiter
+	mu       sync.Mutex
+	sessions map[string]*mgo.Session
+}
+
+// Serve serves schema inference over HTTP on addr. A POST to /schema with a
+// JSON schemaRequest responds with the generated output for the collection.
+func (s *Generator) Serve(addr string) error {
+	srv := &server{gen: s, sessions: map[string]*mgo.Session{}}
+	if s.RateLimit > 0 {
+		srv.limiter = newRateLimiter(s.RateLimit)
+	}
+	defer srv.close()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/schema", srv.schema)
+	s.logger().Info("serving", "addr", addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+func (srv *server) schema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req schemaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %s", err), http.StatusBadRequest)
+		return
+	}
+	url, err := srv.url(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Collection == "" {
+		http.Error(w, "collection is required", http.StatusBadRequest)
+		return
+	}
+
+	// Each request gets its own copy of the settings.
+	g := *srv.gen
+	g.URL = url
+	if req.DB != "" {
+		g.DB = req.DB
+	}
+	if req.Limit != 0 {
+		g.Limit = req.Limit
+	}
+	if req.Format != "" {
+		g.Format = req.Format
+	}
+	g.Collections = Collections{{Name: req.Collection, Struct: req.Struct}}
+	g.State = ""
+	g.Output = Output{}
+
+	ctx, cancel := g.withTimeout(r.Context())
+	defer cancel()
+	session, err := srv.session(ctx, &g)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	src, err := g.newMongoSource(ctx, session.Copy(), srv.limiter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer src.Close()
+	schemas, err := g.infer(ctx, src)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out, err := g.generate(schemas)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	contentType, ok := contentTypes[g.ext()]
+	if !ok {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(out)
+}
+
+var contentTypes = map[string]string{
+	".go":      "text/x-go; charset=utf-8",
+	".json":    "application/json",
+	".ts":      "application/typescript",
+	".proto":   "text/plain; charset=utf-8",
+	".avsc":    "application/json",
+	".yaml":    "application/yaml",
+	".sql":     "application/sql",
+	".js":      "text/javascript; charset=utf-8",
+	".graphql": "application/graphql; charset=utf-8",
+	".kt":      "text/x-kotlin; charset=utf-8",
+	".java":    "text/x-java; charset=utf-8",
+	".py":      "text/x-python; charset=utf-8",
+	".rs":      "text/x-rust; charset=utf-8",
+	".csv":     "text/csv; charset=utf-8",
+}
+
+// url returns the URL of the cluster requested, which must be configured.
+func (srv *server) url(req schemaRequest) (string, error) {
+	switch {
+	case req.Cluster != "":
+		if url, ok := srv.gen.Clusters[req.Cluster]; ok {
+			return url, nil
+		}
+		return "", fmt.Errorf("unknown cluster %q", req.Cluster)
+	case req.URL == "":
+		if srv.gen.URL == "" {
+			return "", fmt.Errorf("url or cluster is required")
+		}
+		return srv.gen.URL, nil
+	case req.URL == srv.gen.URL:
+		return req.URL, nil
+	}
+	for _, url := range srv.gen.Clusters {
+		if req.URL == url {
+			return url, nil
+		}
+	}
+	return "", fmt.Errorf("url is not a configured cluster")
+}
+
+// session returns the session to g.URL, connecting on first use.
+func (srv *server) session(ctx context.Context, g *Generator) (*mgo.Session, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if session, ok := srv.sessions[g.URL]; ok {
+		return session, nil
+	}
+	session, err := g.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	srv.sessions[g.URL] = session
+	return session, nil
+}
+
+func (srv *server) close() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for _, session := range srv.sessions {
+		session.Close()
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// shard is a shard of a sharded cluster, connected to directly.
+type shard struct {
+	name string
+	src  *mongoSource
+}
+
+// connectShards lists the shards of the cluster behind the mongos m is
+// connected to, and connects to each of them with the same credentials,
+// which must therefore also be valid on the shards.
+func (m *mongoSource) connectShards(ctx context.Context) error {
+	var result struct {
+		Shards []struct {
+			ID   string `bson:"_id"`
+			Host string `bson:"host"`
+		} `bson:"shards"`
+	}
+	if err := m.session.DB("admin").Run("listShards", &result); err != nil {
+		return fmt.Errorf("mongoschema: per_shard: listing the shards, which requires a mongos: %s", err)
+	}
+	for _, sh := range result.Shards {
+		info, err := m.gen.dialInfo()
+		if err != nil {
+			return err
+		}
+		info.ReplicaSetName, info.Addrs = shardHosts(sh.Host)
+		session, err := m.gen.dial(ctx, info)
+		if err != nil {
+			m.closeShards()
+			return fmt.Errorf("mongoschema: connecting to shard %s: %s", sh.ID, err)
+		}
+		src := &mongoSource{gen: m.gen, session: session, limiter: m.limiter}
+		if d := duration(m.gen.Connection.Heartbeat); d > 0 {
+			src.heartbeat(d)
+		}
+		m.shards = append(m.shards, shard{name: sh.ID, src: src})
+	}
+	return nil
+}
+
+func (m *mongoSource) closeShards() {
+	for _, sh := range m.shards {
+		sh.src.Close()
+	}
+	m.shards = nil
+}
+
+// shardHosts splits the host of a shard as listed by listShards, like
+// rs0/a:27018,b:27018, into its replica set name and addresses.
+func shardHosts(host string) (replicaSet string, addrs []string) {
+	if i := strings.Index(host, "/"); i >= 0 {
+		replicaSet, host = host[:i], host[i+1:]
+	}
+	return replicaSet, strings.Split(host, ",")
+}
+
+// scanShards scans c on every shard of m concurrently, dividing the limit
+// among the shards in proportion to the documents they hold, so that a
+// sample is not skewed toward the shards mongos happens to read from. The
+// fields that differ between the shards are reported before the results are
+// merged.
+func (s *Generator) scanShards(ctx context.Context, m *mongoSource, c Collection) (*StructType, error) {
+	counts := make([]int, len(m.shards))
+	total := 0
+	for i, sh := range m.shards {
+		n, err := sh.src.count(c)
+		if err != nil {
+			return nil, fmt.Errorf("mongoschema: counting %s on shard %s: %s", c.Name, sh.name, err)
+		}
+		counts[i] = n
+		total += n
+	}
+	if total == 0 {
+		return s.scan(ctx, m, c)
+	}
+	limit := s.limit(c)
+	if limit == 0 && s.sampling(c) != SamplingNatural {
+		limit = defaultSampleSize
+	}
+	shares := proportionalShares(limit, counts)
+	roots := make([]*StructType, len(m.shards))
+	errs := make([]error, len(m.shards))
+	var wg sync.WaitGroup
+	for i, sh := range m.shards {
+		if counts[i] == 0 || limit > 0 && shares[i] == 0 {
+			continue
+		}
+		part := c
+		part.limit = shares[i]
+		wg.Add(1)
+		go func(i int, sh shard) {
+			defer wg.Done()
+			roots[i], errs[i] = s.scanCollection(ctx, sh.src, part)
+		}(i, sh)
+	}
+	wg.Wait()
+	names := make([]string, len(m.shards))
+	for i, sh := range m.shards {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("mongoschema: shard %s: %s", sh.name, errs[i])
+		}
+		names[i] = sh.name
+	}
+	for _, d := range s.rootDifferences(c.Name, names, roots) {
+		s.logger().Warn("field differs between shards", "collection", c.Name, "difference", d)
+	}
+	root := newStructType()
+	for _, r := range roots {
+		if r != nil {
+			root.Merge(r, s)
+		}
+	}
+	return root, nil
+}
+
+// proportionalShares divides limit among shards holding counts documents,
+// in proportion to them, giving the remainder to the largest shards. A
+// limit of zero, for every document, is left to every shard.
+func proportionalShares(limit uint, counts []int) []uint {
+	shares := make([]uint, len(counts))
+	if limit == 0 {
+		return shares
+	}
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	left := limit
+	for i, n := range counts {
+		shares[i] = uint(uint64(limit) * uint64(n) / uint64(total))
+		left -= shares[i]
+	}
+	order := make([]int, len(counts))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return counts[order[a]] > counts[order[b]] })
+	for _, i := range order {
+		if left == 0 {
+			break
+		}
+		if uint(counts[i]) > shares[i] {
+			shares[i]++
+			left--
+		}
+	}
+	return shares
+}
+
+// rootDifferences describes the fields of the documents at path whose types
+// differ between the shards, or clusters, named names, of which roots are
+// the types, or which are in every document of some but absent from others.
+// Those not scanned have a nil root.
+func (s *Generator) rootDifferences(path string, names []string, roots []*StructType) []string {
+	var keys []string
+	for _, r := range roots {
+		if r == nil {
+			continue
+		}
+		for k := range r.Fields {
+			keys = append(keys, k)
+		}
+	}
+	var diffs []string
+	for _, k := range sortedSet(keys) {
+		var types []string
+		byType := map[string][]string{}
+		required := false
+		nested := make([]*StructType, len(roots))
+		for i, r := range roots {
+			if r == nil || r.Count == 0 {
+				continue
+			}
+			t := "absent"
+			if f, ok := r.Fields[k]; ok {
+				t = shortType(f.Type, s)
+				required = required || r.required(k)
+				nested[i] = nestedStruct(f.Type)
+			}
+			if _, ok := byType[t]; !ok {
+				types = append(types, t)
+			}
+			byType[t] = append(byType[t], names[i])
+		}
+		_, absent := byType["absent"]
+		present := len(types)
+		if absent {
+			present--
+		}
+		if present > 1 || absent && required {
+			desc := make([]string, len(types))
+			for i, t := range types {
+				desc[i] = t + " on " + strings.Join(byType[t], ", ")
+			}
+			diffs = append(diffs, path+"."+k+": "+strings.Join(desc, "; "))
+		}
+		diffs = append(diffs, s.rootDifferences(path+"."+k, names, nested)...)
+	}
+	return diffs
+}
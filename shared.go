@@ -0,0 +1,177 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// shareStructs replaces the named structs of schemas that are similar to one
+// declared earlier, in the same or another collection, with a single shared
+// struct. Merging structs can make their parents similar too, so it repeats
+// until nothing is left to merge.
+func (s *Generator) shareStructs(schemas []Schema) {
+	for s.shareOnce(schemas) {
+	}
+}
+
+// structDecl is a named struct declared for a schema.
+type structDecl struct {
+	schema int
+	name   string
+	st     *StructType
+}
+
+func (s *Generator) shareOnce(schemas []Schema) bool {
+	var groups [][]structDecl
+	names := map[string]bool{}
+	for i, schema := range schemas {
+		names[schema.Collection.Struct] = true
+		for _, n := range schema.Decls {
+			names[n.Name] = true
+			st, ok := n.Type.(*StructType)
+			// Variants, which embed the struct of their collection, are
+			// told apart by name and keep their own.
+			if !ok || st.embed != nil {
+				continue
+			}
+			d := structDecl{schema: i, name: n.Name, st: st}
+			found := false
+			for j, g := range groups {
+				if s.similar(g[0].st, st) {
+					groups[j] = append(g, d)
+					found = true
+					break
+				}
+			}
+			if !found {
+				groups = append(groups, []structDecl{d})
+			}
+		}
+	}
+
+	renamed := map[string]NamedType{}
+	removed := map[string]bool{}
+	for _, g := range groups {
+		if len(g) == 1 {
+			continue
+		}
+		merged := newStructType()
+		for _, d := range g {
+			merged.Merge(d.st, s)
+		}
+		shared := NamedType{Name: sharedName(g, names), Type: merged}
+		names[shared.Name] = true
+		for _, d := range g {
+			renamed[d.name] = shared
+			removed[d.name] = true
+		}
+		// The shared struct is declared where the first of them was.
+		delete(removed, g[0].name)
+	}
+	if len(renamed) == 0 {
+		return false
+	}
+	for i := range schemas {
+		schema := &schemas[i]
+		replaceNamed(schema.Root, renamed)
+		decls := schema.Decls[:0]
+		for _, n := range schema.Decls {
+			if removed[n.Name] {
+				continue
+			}
+			if shared, ok := renamed[n.Name]; ok {
+				n = shared
+			}
+			replaceNamed(n.Type, renamed)
+			decls = append(decls, n)
+		}
+		schema.Decls = decls
+	}
+	return true
+}
+
+// similar reports whether a and b have the same type for every field they
+// share, and share at least SharedThreshold of all their fields, or
+// all of them by default.
+func (s *Generator) similar(a, b *StructType) bool {
+	union := len(a.Fields)
+	common := 0
+	for k, f := range b.Fields {
+		e, ok := a.Fields[k]
+		if !ok {
+			union++
+			continue
+		}
+		if e.Type.GoType(s) != f.Type.GoType(s) {
+			return false
+		}
+		common++
+	}
+	if union == 0 {
+		return true
+	}
+	threshold := s.SharedThreshold
+	if threshold == 0 {
+		threshold = 1
+	}
+	return float64(common)/float64(union) >= threshold
+}
+
+// sharedName names the struct shared by the decls of g after the longest
+// common suffix of their names, such as Address for CompanyAddress and
+// UserAddress, if it is a whole word and not already used.
+func sharedName(g []structDecl, names map[string]bool) string {
+	words := camelWords(g[0].name)
+	n := len(words)
+	for _, d := range g[1:] {
+		other := camelWords(d.name)
+		i := 0
+		for i < n && i < len(other) && words[len(words)-1-i] == other[len(other)-1-i] {
+			i++
+		}
+		n = i
+	}
+	name := strings.Join(words[len(words)-n:], "")
+	if name == "" || names[name] {
+		return g[0].name
+	}
+	return name
+}
+
+// camelWords splits a CamelCase name into its words.
+func camelWords(name string) []string {
+	var words []string
+	start := 0
+	for i, r := range name {
+		if i > 0 && unicode.IsUpper(r) {
+			words = append(words, name[start:i])
+			start = i
+		}
+	}
+	return append(words, name[start:])
+}
+
+// replaceNamed replaces the references in t to the named types in renamed.
+// Named types are not followed, as their declarations are replaced
+// separately.
+func replaceNamed(t Type, renamed map[string]NamedType) Type {
+	switch v := t.(type) {
+	case NamedType:
+		if n, ok := renamed[v.Name]; ok {
+			return n
+		}
+	case *StructType:
+		for _, f := range v.Fields {
+			f.Type = replaceNamed(f.Type, renamed)
+		}
+	case SliceType:
+		return SliceType{Type: replaceNamed(v.Type, renamed)}
+	case MapType:
+		return MapType{Value: replaceNamed(v.Value, renamed)}
+	case MixedType:
+		for i, e := range v {
+			v[i] = replaceNamed(e, renamed)
+		}
+	}
+	return t
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// OutputSink receives the generated files.
+type OutputSink interface {
+	// WriteFile writes the contents of the file name, which is empty for
+	// the single layout without an output file.
+	WriteFile(name string, contents []byte) error
+}
+
+const (
+	// SinkFile writes files, or to stdout in the single layout without an
+	// output file.
+	SinkFile   = "file"
+	SinkStdout = "stdout"
+	// SinkHTTP POSTs each file to output.url, such as that of a schema
+	// registry.
+	SinkHTTP = "http"
+)
+
+// sinkTimeout limits each request of the http sink.
+const sinkTimeout = 30 * time.Second
+
+// outputSink returns the sink configured by Sink or else output.sink.
+func (s *Generator) outputSink() OutputSink {
+	if s.Sink != nil {
+		return s.Sink
+	}
+	switch s.Output.Sink {
+	case SinkStdout:
+		return WriterSink{os.Stdout}
+	case SinkHTTP:
+		return &HTTPSink{URL: s.Output.URL, Token: s.Output.Token, ContentType: contentTypes[s.ext()]}
+	}
+	return FileSink{}
+}
+
+// FileSink writes files, creating their directories, and writes a file
+// without a name to stdout.
+type FileSink struct{}
+
+func (FileSink) WriteFile(name string, contents []byte) error {
+	if name == "" {
+		_, err := os.Stdout.Write(contents)
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, contents, 0644)
+}
+
+// WriterSink writes the contents of every file to W, one after the other.
+type WriterSink struct {
+	W io.Writer
+}
+
+func (w WriterSink) WriteFile(name string, contents []byte) error {
+	_, err := w.W.Write(contents)
+	return err
+}
+
+// MemorySink keeps the files in memory, for use as a library. Files
+// without a name are kept under "".
+type MemorySink struct {
+	mu    sync.Mutex
+	Files map[string][]byte
+}
+
+func (m *MemorySink) WriteFile(name string, contents []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Files == nil {
+		m.Files = map[string][]byte{}
+	}
+	m.Files[name] = append([]byte(nil), contents...)
+	return nil
+}
+
+// HTTPSink POSTs each file to URL, naming it in the X-Mongoschema-File
+// header, with Token, if set, as a bearer token.
+type HTTPSink struct {
+	URL         string
+	Token       string
+	ContentType string
+	// Client sends the requests, http.DefaultClient with a timeout if nil.
+	Client *http.Client
+}
+
+func (h *HTTPSink) WriteFile(name string, contents []byte) error {
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(contents))
+	if err != nil {
+		return fmt.Errorf("mongoschema: output.url: %s", err)
+	}
+	if h.ContentType != "" {
+		req.Header.Set("Content-Type", h.ContentType)
+	}
+	if name != "" {
+		req.Header.Set("X-Mongoschema-File", filepath.ToSlash(name))
+	}
+	if h.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.Token)
+	}
+	client := h.Client
+	if client == nil {
+		client = &http.Client{Timeout: sinkTimeout}
+	}
+	if name == "" {
+		name = "output"
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("mongoschema: posting %s: %s", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("mongoschema: posting %s: %s: %s", name, resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
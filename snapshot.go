@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+)
+
+// snapshot holds the inferred types of the collections of a run, before they
+// are transformed for the output, as written with the snapshot setting and
+// compared by Diff.
+type snapshot struct {
+	DB          string               `json:"db,omitempty"`
+	Time        time.Time            `json:"time"`
+	Collections map[string]*typeJSON `json:"collections"`
+}
+
+// writeSnapshot writes the raw types roots of the collections to the
+// snapshot file.
+func (s *Generator) writeSnapshot(roots []*StructType) error {
+	snap := snapshot{DB: s.DB, Time: s.generated(), Collections: map[string]*typeJSON{}}
+	for i, c := range s.Collections {
+		snap.Collections[c.Name] = encodeType(roots[i])
+	}
+	b, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.Snapshot, append(b, '\n'), 0644)
+}
+
+// loadSnapshot reads the types of the collections from the snapshot file
+// name.
+func loadSnapshot(name string) (map[string]*StructType, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var snap snapshot
+	if err := json.NewDecoder(f).Decode(&snap); err != nil {
+		return nil, fmt.Errorf("mongoschema: %s: %s", name, err)
+	}
+	roots := map[string]*StructType{}
+	for c, j := range snap.Collections {
+		t, err := decodeType(j)
+		if err != nil {
+			return nil, fmt.Errorf("mongoschema: %s: collection %s: %s", name, c, err)
+		}
+		root, ok := t.(*StructType)
+		if !ok {
+			return nil, fmt.Errorf("mongoschema: %s: collection %s is not a document", name, c)
+		}
+		roots[c] = root
+	}
+	return roots, nil
+}
+
+// Diff compares the snapshots in the files oldName and newName, reporting the
+// collections and fields added and removed and the fields whose types changed
+// to w, and returns whether there were any. Fields are named by their paths,
+// starting with their collection.
+func Diff(oldName, newName string, w io.Writer) (changed bool, err error) {
+	old, err := loadSnapshot(oldName)
+	if err != nil {
+		return false, err
+	}
+	cur, err := loadSnapshot(newName)
+	if err != nil {
+		return false, err
+	}
+	d := &snapshotDiff{gen: &Generator{}, w: w}
+	var names []string
+	for c := range old {
+		names = append(names, c)
+	}
+	for c := range cur {
+		names = append(names, c)
+	}
+	for _, c := range sortedSet(names) {
+		o, ook := old[c]
+		n, nok := cur[c]
+		switch {
+		case !ook:
+			d.report("%s: collection added", c)
+		case !nok:
+			d.report("%s: collection removed", c)
+		default:
+			d.compare(c, o, n)
+		}
+	}
+	return d.changed, nil
+}
+
+type snapshotDiff struct {
+	gen     *Generator
+	w       io.Writer
+	changed bool
+}
+
+func (d *snapshotDiff) report(format string, args ...interface{}) {
+	d.changed = true
+	fmt.Fprintf(d.w, format+"\n", args...)
+}
+
+// compare reports the differences between the fields of old and cur, the
+// documents at path. Nested documents are compared field by field.
+func (d *snapshotDiff) compare(path string, old, cur *StructType) {
+	var keys []string
+	for k := range old.Fields {
+		keys = append(keys, k)
+	}
+	for k := range cur.Fields {
+		keys = append(keys, k)
+	}
+	for _, k := range sortedSet(keys) {
+		o, ook := old.Fields[k]
+		n, nok := cur.Fields[k]
+		p := path + "." + k
+		switch {
+		case !ook:
+			d.report("%s: added (%s)", p, shortType(n.Type, d.gen))
+		case !nok:
+			d.report("%s: removed (%s)", p, shortType(o.Type, d.gen))
+		default:
+			if ot, nt := shortType(o.Type, d.gen), shortType(n.Type, d.gen); ot != nt {
+				d.report("%s: type changed from %s to %s", p, ot, nt)
+			}
+			if on, nn := nestedStruct(o.Type), nestedStruct(n.Type); on != nil && nn != nil {
+				d.compare(p, on, nn)
+			}
+		}
+	}
+}
+
+// sortedSet sorts a and removes its duplicates.
+func sortedSet(a []string) []string {
+	sort.Strings(a)
+	var set []string
+	for i, e := range a {
+		if i == 0 || e != a[i-1] {
+			set = append(set, e)
+		}
+	}
+	return set
+}
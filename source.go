@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	SourceMongo = "mongo"
+	SourceDump  = "dump"
+	SourceJSON  = "json"
+)
+
+// Iter is a source of documents, such as an *mgo.Iter.
+type Iter interface {
+	Next(result interface{}) bool
+	Close() error
+}
+
+// Source provides the documents of each collection. Open may be called
+// concurrently.
+type Source interface {
+	Open(c Collection) (Iter, error)
+	Close()
+}
+
+func (s *Generator) source(ctx context.Context) (Source, error) {
+	switch s.Source {
+	case "", SourceMongo:
+		session, err := s.connect(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var limiter *rateLimiter
+		if s.RateLimit > 0 {
+			limiter = newRateLimiter(s.RateLimit)
+		}
+		return s.newMongoSource(ctx, session, limiter)
+	case SourceDump:
+		return newDumpSource(s)
+	case SourceJSON:
+		return newExportSource(s)
+	}
+	return nil, fmt.Errorf("mongoschema: unknown source %q", s.Source)
+}
+
+// newMongoSource returns the source reading through session, which it closes
+// when closed, paced by limiter, if any, with the shard connections of
+// per_shard and the heartbeat of the config.
+func (s *Generator) newMongoSource(ctx context.Context, session *mgo.Session, limiter *rateLimiter) (*mongoSource, error) {
+	m := &mongoSource{gen: s, session: session, limiter: limiter}
+	if s.PerShard {
+		if err := m.connectShards(ctx); err != nil {
+			session.Close()
+			return nil, err
+		}
+	}
+	if d := duration(s.Connection.Heartbeat); d > 0 {
+		m.heartbeat(d)
+	}
+	return m, nil
+}
+
+// mongoSource reads collections from a live server, using a copy of the
+// session for each collection. limiter, if any, is shared by all of them.
+// With per_shard, shards are the shards of the cluster behind the mongos
+// session is connected to. stop, if set, stops the heartbeat, which closes
+// stopped when done.
+type mongoSource struct {
+	gen     *Generator
+	session *mgo.Session
+	limiter *rateLimiter
+	shards  []shard
+	stop    chan struct{}
+	stopped chan struct{}
+	// clusters are the sources of the clusters of collections, connected
+	// to when first scanned, guarded by mu.
+	mu       sync.Mutex
+	clusters map[string]*mongoSource
+}
+
+func (m *mongoSource) Open(c Collection) (Iter, error) {
+	session := m.session.Copy()
+	iter, err := m.gen.query(session.DB(m.gen.DB).C(c.from()), c)
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	return &sessionIter{Iter: iter, session: session}, nil
+}
+
+func (m *mongoSource) Close() {
+	m.stopHeartbeat()
+	m.closeShards()
+	m.closeClusters()
+	m.session.Close()
+}
+
+// sessionIter closes its session along with the iterator.
+type sessionIter struct {
+	Iter
+	session *mgo.Session
+}
+
+func (i *sessionIter) Close() error {
+	defer i.session.Close()
+	return i.Iter.Close()
+}
+
+// toBSON converts a document from the config file to BSON. A string is parsed
+// as MongoDB extended JSON, so that it can hold dates and ObjectIds; anything
+// else is YAML.
+func toBSON(v interface{}) (interface{}, error) {
+	if v, ok := v.(string); ok {
+		var doc bson.M
+		if err := bson.UnmarshalJSON([]byte(v), &doc); err != nil {
+			return nil, err
+		}
+		return doc, nil
+	}
+	return fromYAML(v)
+}
+
+// fromYAML converts the maps decoded by yaml, which have interface{} keys,
+// to bson.M.
+func fromYAML(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		doc := bson.M{}
+		for k, e := range v {
+			ks, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("key %v is not a string", k)
+			}
+			ev, err := fromYAML(e)
+			if err != nil {
+				return nil, err
+			}
+			doc[ks] = ev
+		}
+		return doc, nil
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for i, e := range v {
+			ev, err := fromYAML(e)
+			if err != nil {
+				return nil, err
+			}
+			a[i] = ev
+		}
+		return a, nil
+	}
+	return v, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	SQLDialectPostgres = "postgres"
+	SQLDialectMySQL    = "mysql"
+	SQLDialectBigQuery = "bigquery"
+)
+
+const (
+	// SQLNestedJSON stores nested documents and arrays in JSON columns.
+	SQLNestedJSON = "json"
+	// SQLNestedFlatten stores the fields of nested documents in columns of
+	// their own, named by their path joined by underscores. Arrays are still
+	// stored as JSON.
+	SQLNestedFlatten = "flatten"
+)
+
+// sqlColumn is a column of a generated table.
+type sqlColumn struct {
+	name     string
+	typ      string
+	required bool
+}
+
+// renderSQL writes a CREATE TABLE statement for every collection, in the
+// configured dialect.
+func (s *Generator) renderSQL(w io.Writer, schemas []Schema) error {
+	for _, schema := range schemas {
+		var columns []sqlColumn
+		s.sqlColumns(schema.Root, "", true, &columns)
+		fmt.Fprintf(w, "CREATE TABLE %s (\n", s.sqlQuote(schema.Collection.Name))
+		for i, c := range columns {
+			fmt.Fprintf(w, "  %s %s", s.sqlQuote(c.name), c.typ)
+			if c.required && s.InferOptional {
+				fmt.Fprint(w, " NOT NULL")
+			}
+			if c.name == "_id" && s.SQLDialect != SQLDialectBigQuery {
+				fmt.Fprint(w, " PRIMARY KEY")
+			}
+			if i < len(columns)-1 {
+				fmt.Fprint(w, ",")
+			}
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintln(w, ");")
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+// sqlColumns appends the columns of the fields of st, prefixing their names
+// with prefix. Columns of nested documents are required only if the
+// documents and their fields are.
+func (s *Generator) sqlColumns(st *StructType, prefix string, required bool, columns *[]sqlColumn) {
+	for _, k := range st.keys(s) {
+		name := prefix + k
+		t := st.Fields[k].Type
+		switch v := t.(type) {
+		case NamedType:
+			t = v.Type
+		case OverrideType:
+			t = v.Inferred
+		case LegacyPointType:
+			t = v.Tuple()
+		}
+		if nested, ok := t.(*StructType); ok && s.SQLNested == SQLNestedFlatten {
+			s.sqlColumns(nested, name+"_", required && st.required(k), columns)
+			continue
+		}
+		for base, i := name, 2; sqlHasColumn(*columns, name); i++ {
+			name = fmt.Sprintf("%s_%d", base, i)
+		}
+		*columns = append(*columns, sqlColumn{name: name, typ: s.sqlType(t, name == "_id"), required: required && st.required(k)})
+	}
+}
+
+func sqlHasColumn(columns []sqlColumn, name string) bool {
+	for _, c := range columns {
+		if c.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// sqlType returns the column type of t. MySQL cannot index TEXT, so a key
+// string is a VARCHAR.
+func (s *Generator) sqlType(t Type, key bool) string {
+	p, ok := t.(PrimitiveType)
+	if !ok {
+		if _, ok := t.(EnumType); ok {
+			p = PrimitiveString
+		} else {
+			return s.sqlJSON()
+		}
+	}
+	var types map[PrimitiveType]string
+	switch s.SQLDialect {
+	case SQLDialectMySQL:
+		if key && p == PrimitiveString {
+			return "VARCHAR(255)"
+		}
+		types = mysqlTypes
+	case SQLDialectBigQuery:
+		types = bigQueryTypes
+	default:
+		types = postgresTypes
+	}
+	if typ, ok := types[p]; ok {
+		return typ
+	}
+	return s.sqlJSON()
+}
+
+func (s *Generator) sqlJSON() string {
+	switch s.SQLDialect {
+	case SQLDialectMySQL, SQLDialectBigQuery:
+		return "JSON"
+	}
+	return "JSONB"
+}
+
+func (s *Generator) sqlQuote(name string) string {
+	switch s.SQLDialect {
+	case SQLDialectMySQL, SQLDialectBigQuery:
+		return "`" + strings.Replace(name, "`", "``", -1) + "`"
+	}
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+var postgresTypes = map[PrimitiveType]string{
+	PrimitiveBool:           "BOOLEAN",
+	PrimitiveDouble:         "DOUBLE PRECISION",
+	PrimitiveInt32:          "INTEGER",
+	PrimitiveInt64:          "BIGINT",
+	PrimitiveString:         "TEXT",
+	PrimitiveTimestamp:      "TIMESTAMPTZ",
+	PrimitiveObjectId:       "CHAR(24)",
+	PrimitiveBinary:         "BYTEA",
+	PrimitiveBytes:          "BYTEA",
+	PrimitiveUUID:           "UUID",
+	PrimitiveDecimal128:     "NUMERIC",
+	PrimitiveRegEx:          "TEXT",
+	PrimitiveJavaScript:     "TEXT",
+	PrimitiveSymbol:         "TEXT",
+	PrimitiveMongoTimestamp: "BIGINT",
+}
+
+var mysqlTypes = map[PrimitiveType]string{
+	PrimitiveBool:           "BOOLEAN",
+	PrimitiveDouble:         "DOUBLE",
+	PrimitiveInt32:          "INT",
+	PrimitiveInt64:          "BIGINT",
+	PrimitiveString:         "TEXT",
+	PrimitiveTimestamp:      "DATETIME(3)",
+	PrimitiveObjectId:       "CHAR(24)",
+	PrimitiveBinary:         "BLOB",
+	PrimitiveBytes:          "BLOB",
+	PrimitiveUUID:           "CHAR(36)",
+	PrimitiveDecimal128:     "DECIMAL(65, 30)",
+	PrimitiveRegEx:          "TEXT",
+	PrimitiveJavaScript:     "TEXT",
+	PrimitiveSymbol:         "TEXT",
+	PrimitiveMongoTimestamp: "BIGINT",
+}
+
+var bigQueryTypes = map[PrimitiveType]string{
+	PrimitiveBool:           "BOOL",
+	PrimitiveDouble:         "FLOAT64",
+	PrimitiveInt32:          "INT64",
+	PrimitiveInt64:          "INT64",
+	PrimitiveString:         "STRING",
+	PrimitiveTimestamp:      "TIMESTAMP",
+	PrimitiveObjectId:       "STRING",
+	PrimitiveBinary:         "BYTES",
+	PrimitiveBytes:          "BYTES",
+	PrimitiveUUID:           "STRING",
+	PrimitiveDecimal128:     "BIGNUMERIC",
+	PrimitiveRegEx:          "STRING",
+	PrimitiveJavaScript:     "STRING",
+	PrimitiveSymbol:         "STRING",
+	PrimitiveMongoTimestamp: "INT64",
+}
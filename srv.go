@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	srvScheme   = "mongodb+srv://"
+	mongoScheme = "mongodb://"
+)
+
+// srvTXTOptions are the only options the TXT record of a seedlist may set.
+var srvTXTOptions = map[string]bool{"authSource": true, "replicaSet": true}
+
+// droppedURLOptions are options of standard connection strings that mgo
+// rejects and that do not matter for reading, like those of Atlas.
+var droppedURLOptions = map[string]bool{"retryWrites": true, "w": true, "appName": true}
+
+// dialURL returns the URL of the config in the form mgo parses, and whether
+// it requires TLS. A mongodb+srv URL is resolved to its seedlist: the hosts
+// of the SRV records of its host, with the options of its TXT record, and
+// TLS unless it disables it. The tls and ssl options, which mgo does not
+// know, enable TLS with the settings of the config.
+func (s *Generator) dialURL() (string, bool, error) {
+	raw := s.URL
+	srv := strings.HasPrefix(raw, srvScheme)
+	if !srv && !strings.Contains(raw, "?") {
+		return raw, false, nil
+	}
+	if !strings.Contains(raw, "://") {
+		raw = mongoScheme + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", false, fmt.Errorf("mongoschema: invalid url: %s", err)
+	}
+	options := u.Query()
+	useTLS := srv
+	for _, k := range []string{"tls", "ssl"} {
+		if v := options.Get(k); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return "", false, fmt.Errorf("mongoschema: url: invalid %s=%s", k, v)
+			}
+			useTLS = b
+			options.Del(k)
+		}
+	}
+	for k := range droppedURLOptions {
+		options.Del(k)
+	}
+	if srv {
+		if options.Get("connect") == "direct" {
+			return "", false, fmt.Errorf("mongoschema: url: a mongodb+srv url cannot connect directly")
+		}
+		hosts, txt, err := lookupSeedlist(u.Host)
+		if err != nil {
+			return "", false, err
+		}
+		for k, vs := range txt {
+			if _, ok := options[k]; !ok {
+				options[k] = vs
+			}
+		}
+		u.Host = strings.Join(hosts, ",")
+	}
+	u.Scheme = "mongodb"
+	u.RawQuery = options.Encode()
+	return u.String(), useTLS, nil
+}
+
+// lookupSeedlist returns the hosts of the SRV records of the mongodb+srv
+// host, which must be in its domain, and the options of its TXT record.
+func lookupSeedlist(host string) ([]string, url.Values, error) {
+	if strings.Contains(host, ",") || strings.Contains(host, ":") {
+		return nil, nil, fmt.Errorf("mongoschema: url: a mongodb+srv url has a single host without a port, not %s", host)
+	}
+	parts := strings.Split(host, ".")
+	if len(parts) < 3 {
+		return nil, nil, fmt.Errorf("mongoschema: url: %s is not a host name with a domain, like cluster0.example.net", host)
+	}
+	domain := "." + strings.Join(parts[1:], ".")
+	_, records, err := net.LookupSRV("mongodb", "tcp", host)
+	if err != nil {
+		return nil, nil, fmt.Errorf("mongoschema: looking up the seedlist of %s: %s", host, err)
+	}
+	var hosts []string
+	for _, r := range records {
+		target := strings.TrimSuffix(r.Target, ".")
+		if !strings.HasSuffix(target, domain) {
+			return nil, nil, fmt.Errorf("mongoschema: seedlist host %s is not in the domain of %s", target, host)
+		}
+		hosts = append(hosts, net.JoinHostPort(target, strconv.Itoa(int(r.Port))))
+	}
+	sort.Strings(hosts)
+	txt, err := net.LookupTXT(host)
+	if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+		return hosts, url.Values{}, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("mongoschema: looking up the options of %s: %s", host, err)
+	}
+	if len(txt) > 1 {
+		return nil, nil, fmt.Errorf("mongoschema: %s has more than one TXT record", host)
+	}
+	options := url.Values{}
+	if len(txt) == 1 {
+		if options, err = url.ParseQuery(txt[0]); err != nil {
+			return nil, nil, fmt.Errorf("mongoschema: invalid TXT record of %s: %s", host, err)
+		}
+		for k := range options {
+			if !srvTXTOptions[k] {
+				return nil, nil, fmt.Errorf("mongoschema: TXT record of %s sets %s, which it may not", host, k)
+			}
+		}
+	}
+	return hosts, options, nil
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// typeJSON is the JSON encoding of a Type. Kind selects the fields that are
+// used.
+type typeJSON struct {
+	Kind      string                `json:"kind"`
+	Literal   string                `json:"literal,omitempty"`
+	Primitive PrimitiveType         `json:"primitive,omitempty"`
+	Name      string                `json:"name,omitempty"`
+	Elem      *typeJSON             `json:"elem,omitempty"`
+	Members   []*typeJSON           `json:"members,omitempty"`
+	Fields    map[string]*fieldJSON `json:"fields,omitempty"`
+	Count     uint                  `json:"count,omitempty"`
+	Values    []string              `json:"values,omitempty"`
+	Variants  map[string]*typeJSON  `json:"variants,omitempty"`
+}
+
+type fieldJSON struct {
+	Type           *typeJSON         `json:"type"`
+	Count          uint              `json:"count"`
+	Values         map[string]uint   `json:"values,omitempty"`
+	Examples       []string          `json:"examples,omitempty"`
+	Refs           map[string]uint   `json:"refs,omitempty"`
+	Tuple          []*typeJSON       `json:"tuple,omitempty"`
+	Elems          map[string]uint   `json:"elems,omitempty"`
+	ArrayLen       int               `json:"array_len,omitempty"`
+	Min            *float64          `json:"min,omitempty"`
+	Max            *float64          `json:"max,omitempty"`
+	Nulls          uint              `json:"nulls,omitempty"`
+	NumericStrings uint              `json:"numeric_strings,omitempty"`
+	Order          uint              `json:"order,omitempty"`
+	TypeIDs        map[string]string `json:"type_ids,omitempty"`
+}
+
+func encodeType(t Type) *typeJSON {
+	switch v := t.(type) {
+	case LiteralType:
+		return &typeJSON{Kind: "literal", Literal: v.Literal}
+	case PrimitiveType:
+		return &typeJSON{Kind: "primitive", Primitive: v}
+	case MixedType:
+		j := &typeJSON{Kind: "mixed"}
+		for _, e := range v {
+			j.Members = append(j.Members, encodeType(e))
+		}
+		return j
+	case SliceType:
+		return &typeJSON{Kind: "slice", Elem: encodeType(v.Type)}
+	case TupleType:
+		j := &typeJSON{Kind: "tuple"}
+		for _, e := range v.Types {
+			j.Members = append(j.Members, encodeType(e))
+		}
+		return j
+	case MapType:
+		return &typeJSON{Kind: "map", Elem: encodeType(v.Value)}
+	case NamedType:
+		return &typeJSON{Kind: "named", Name: v.Name, Elem: encodeType(v.Type)}
+	case OverrideType:
+		return &typeJSON{Kind: "override", Name: v.Name, Elem: encodeType(v.Inferred)}
+	case EnumType:
+		return &typeJSON{Kind: "enum", Values: v.Values}
+	case GeoJSONType:
+		return &typeJSON{Kind: "geojson", Name: v.Shape}
+	case LegacyPointType:
+		return &typeJSON{Kind: "legacy_point"}
+	case *StructType:
+		j := &typeJSON{Kind: "struct", Count: v.Count, Fields: map[string]*fieldJSON{}}
+		for k, f := range v.Fields {
+			fj := &fieldJSON{Type: encodeType(f.Type), Count: f.Count, Values: f.Values, Examples: f.Examples, Refs: f.Refs, Elems: f.Elems, ArrayLen: f.ArrayLen, Min: f.Min, Max: f.Max, Nulls: f.Nulls, NumericStrings: f.NumericStrings, Order: f.Order, TypeIDs: f.TypeIDs}
+			for _, e := range f.Tuple {
+				fj.Tuple = append(fj.Tuple, encodeType(e))
+			}
+			j.Fields[k] = fj
+		}
+		for v, vt := range v.variants {
+			if j.Variants == nil {
+				j.Variants = map[string]*typeJSON{}
+			}
+			j.Variants[v] = encodeType(vt)
+		}
+		return j
+	}
+	panic(fmt.Sprintf("unknown type: %T", t))
+}
+
+func decodeType(j *typeJSON) (Type, error) {
+	if j == nil {
+		return nil, fmt.Errorf("mongoschema: missing type")
+	}
+	switch j.Kind {
+	case "literal":
+		return LiteralType{Literal: j.Literal}, nil
+	case "primitive":
+		return j.Primitive, nil
+	case "mixed", "tuple":
+		m := make([]Type, len(j.Members))
+		for i, e := range j.Members {
+			t, err := decodeType(e)
+			if err != nil {
+				return nil, err
+			}
+			m[i] = t
+		}
+		if j.Kind == "tuple" {
+			return TupleType{Types: m}, nil
+		}
+		return MixedType(m), nil
+	case "slice", "map", "named", "override":
+		t, err := decodeType(j.Elem)
+		if err != nil {
+			return nil, err
+		}
+		switch j.Kind {
+		case "slice":
+			return SliceType{Type: t}, nil
+		case "map":
+			return MapType{Value: t}, nil
+		case "override":
+			return OverrideType{Name: j.Name, Inferred: t}, nil
+		}
+		return NamedType{Name: j.Name, Type: t}, nil
+	case "enum":
+		return EnumType{Values: j.Values}, nil
+	case "geojson":
+		return GeoJSONType{Shape: j.Name}, nil
+	case "legacy_point":
+		return LegacyPointType{}, nil
+	case "struct":
+		s := newStructType()
+		s.Count = j.Count
+		for k, f := range j.Fields {
+			t, err := decodeType(f.Type)
+			if err != nil {
+				return nil, err
+			}
+			field := &Field{Type: t, Count: f.Count, Values: f.Values, Examples: f.Examples, Refs: f.Refs, Elems: f.Elems, ArrayLen: f.ArrayLen, Min: f.Min, Max: f.Max, Nulls: f.Nulls, NumericStrings: f.NumericStrings, Order: f.Order, TypeIDs: f.TypeIDs}
+			for _, e := range f.Tuple {
+				et, err := decodeType(e)
+				if err != nil {
+					return nil, err
+				}
+				field.Tuple = append(field.Tuple, et)
+			}
+			s.Fields[k] = field
+		}
+		for v, vj := range j.Variants {
+			vt, err := decodeType(vj)
+			if err != nil {
+				return nil, err
+			}
+			st, ok := vt.(*StructType)
+			if !ok {
+				return nil, fmt.Errorf("mongoschema: variant %q is not a struct", v)
+			}
+			if s.variants == nil {
+				s.variants = map[string]*StructType{}
+			}
+			s.variants[v] = st
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("mongoschema: unknown type kind %q", j.Kind)
+}
+
+// schemaState is the state file, which caches the types of the collections
+// between runs so that only new documents need to be scanned.
+type schemaState struct {
+	Collections map[string]*collectionState `json:"collections"`
+
+	mu sync.Mutex
+}
+
+// collectionState is the type of a collection as of the newest _id scanned.
+// Filter, Projection and Settings are those it was scanned with; the cache is
+// discarded when they change.
+type collectionState struct {
+	Root       *typeJSON     `json:"root"`
+	LastID     bson.ObjectId `json:"last_id"`
+	Filter     string        `json:"filter,omitempty"`
+	Projection string        `json:"projection,omitempty"`
+	Settings   string        `json:"settings,omitempty"`
+}
+
+// recordSettings returns the fingerprint of the settings that decide what the
+// raw type of c records, such as nulls, enum values and examples. The
+// documents cached under other settings did not record what is now needed.
+func (s *Generator) recordSettings(c Collection) string {
+	return fmt.Sprintf("nulls=%t enum_threshold=%d examples=%d tuples=%t legacy_coordinates=%s element_comments=%t typed_refs=%t conflict_ids=%t lint=%t ranges=%t geojson=%s int_policy=%s unknown_types=%s discriminator=%s order=%t",
+		s.tracksNulls(), s.EnumThreshold, s.Examples, s.Tuples, s.LegacyCoords, s.ElementComments, s.TypedRefs, s.ConflictIDs, s.Lint,
+		s.ValidateMethods || s.EpochTimes != "" || s.Lint, s.GeoJSON, s.IntPolicy, s.UnknownTypes, c.Discriminator,
+		s.LowMemory || s.FieldOrder == FieldOrderDocument)
+}
+
+func loadState(name string) (*schemaState, error) {
+	state := &schemaState{Collections: map[string]*collectionState{}}
+	b, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, fmt.Errorf("mongoschema: invalid state file %s: %s", name, err)
+	}
+	if state.Collections == nil {
+		state.Collections = map[string]*collectionState{}
+	}
+	return state, nil
+}
+
+func (st *schemaState) save(name string) error {
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, append(b, '\n'), 0644)
+}
+
+func (st *schemaState) get(name string) *collectionState {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.Collections[name]
+}
+
+func (st *schemaState) set(name string, c *collectionState) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.Collections[name] = c
+}
+
+// scanCached scans only the documents of c added since the state was saved,
+// which are those with a greater ObjectId, and merges them into the cached
+// type. The state is only used for natural samples from a mongo source, and
+// not for the output of pipelines, whose _ids are not those of the
+// collection they run on, nor for collections scanned on several clusters,
+// whose last _ids differ.
+func (s *Generator) scanCached(ctx context.Context, src Source, c Collection) (*StructType, error) {
+	m, ok := src.(*mongoSource)
+	if s.state == nil || !ok || s.sampling(c) != SamplingNatural || c.Pipeline != nil || len(c.Clusters) > 0 {
+		return s.scanCollection(ctx, src, c)
+	}
+	filter, err := toBSON(c.Filter)
+	if err != nil {
+		return nil, fmt.Errorf("mongoschema: invalid filter for collection %s: %s", c.Name, err)
+	}
+	projection, err := toBSON(c.Projection)
+	if err != nil {
+		return nil, fmt.Errorf("mongoschema: invalid projection for collection %s: %s", c.Name, err)
+	}
+	// States saved before projections have none.
+	var projected string
+	if projection != nil {
+		projected = fmt.Sprint(projection)
+	}
+	last, err := m.lastID(c.from(), filter)
+	if err != nil {
+		return nil, err
+	}
+	if last == "" {
+		return s.scanCollection(ctx, src, c)
+	}
+
+	root := newStructType()
+	ids := bson.M{"$lte": last}
+	settings := s.recordSettings(c)
+	cached := s.state.get(c.Name)
+	if cached != nil && cached.Filter == fmt.Sprint(filter) && cached.Projection == projected && cached.Settings == settings && cached.LastID != "" {
+		t, err := decodeType(cached.Root)
+		if err != nil {
+			return nil, err
+		}
+		if r, ok := t.(*StructType); ok {
+			root = r
+			ids["$gt"] = cached.LastID
+		}
+	}
+	if filter != nil {
+		c.Filter = bson.M{"$and": []interface{}{filter, bson.M{"_id": ids}}}
+	} else {
+		c.Filter = bson.M{"_id": ids}
+	}
+	scanned, err := s.scanCollection(ctx, src, c)
+	if err != nil {
+		return nil, err
+	}
+	root.Merge(scanned, s)
+	// A scan stopped early, or by a limit, did not reach the last _id, so
+	// the state is left as it was.
+	if ctx.Err() != nil || s.limit(c) > 0 {
+		return root, nil
+	}
+	s.state.set(c.Name, &collectionState{Root: encodeType(root), LastID: last, Filter: fmt.Sprint(filter), Projection: projected, Settings: settings})
+	return root, nil
+}
+
+// lastID returns the greatest _id matching filter, or "" if the collection
+// is empty or its _id is not an ObjectId.
+func (m *mongoSource) lastID(name string, filter interface{}) (bson.ObjectId, error) {
+	session := m.session.Copy()
+	defer session.Close()
+	var doc struct {
+		ID interface{} `bson:"_id"`
+	}
+	err := session.DB(m.gen.DB).C(name).Find(filter).SetMaxTime(m.gen.maxTime()).Sort("-_id").Select(bson.M{"_id": 1}).One(&doc)
+	if err == mgo.ErrNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	id, _ := doc.ID.(bson.ObjectId)
+	return id, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TagConfig describes one key of the struct tag emitted for each field. A tag
+// with a Value is static, and emitted as is on every field. Otherwise the
+// value is the field's key converted to Case, followed by omitempty unless
+// OmitEmpty is false.
+type TagConfig struct {
+	Key       string `yaml:"key"`
+	Case      string `yaml:"case"`
+	OmitEmpty *bool  `yaml:"omitempty"`
+	Value     string `yaml:"value"`
+}
+
+const (
+	CaseOriginal = "original"
+	CaseSnake    = "snake"
+	CaseCamel    = "camel"
+	CasePascal   = "pascal"
+	CaseKebab    = "kebab"
+	CaseLower    = "lower"
+)
+
+var defaultTags = []TagConfig{{Key: "bson"}, {Key: "json"}}
+
+const (
+	// OmitEmptyAlways tags every field omitempty, except the required
+	// fields of InferOptional.
+	OmitEmptyAlways = "always"
+	// OmitEmptyOptional tags only the fields missing from some documents
+	// omitempty, so that the zero values of the others, like false, 0 and
+	// "", are written back.
+	OmitEmptyOptional = "optional"
+	OmitEmptyNever    = "never"
+)
+
+// omitEmpty decides whether the field named k of s is tagged omitempty, by
+// its override in omitempty_fields or else by the OmitEmpty policy, given
+// whether its inferred type would be.
+func (s *Generator) omitEmpty(st *StructType, k string, inferred bool) bool {
+	f := st.Fields[k]
+	if f.omitEmpty != nil {
+		return *f.omitEmpty
+	}
+	switch s.OmitEmpty {
+	case OmitEmptyNever:
+		return false
+	case OmitEmptyOptional:
+		return inferred && !st.required(k)
+	}
+	return inferred
+}
+
+// markOmitEmpty records on the fields of root the overrides of
+// omitempty_fields for c, which are keyed like overrides.
+func (s *Generator) markOmitEmpty(root *StructType, c Collection) {
+	for key, omit := range s.OmitEmptyFields {
+		if !strings.HasPrefix(key, c.Name+".") {
+			continue
+		}
+		path := strings.TrimPrefix(key, c.Name+".")
+		f := lookupField(root, strings.Split(path, "."))
+		if f == nil {
+			s.logger().Warn("omitempty field not found", "collection", c.Name, "field", path)
+			continue
+		}
+		omit := omit
+		f.omitEmpty = &omit
+	}
+}
+
+// Keys of the tags of binary encodings that ExtraTags can add. Both
+// github.com/vmihailenco/msgpack and github.com/fxamacker/cbor read the field
+// name and omitempty like encoding/json, and inline embedded structs.
+const (
+	TagMsgpack = "msgpack"
+	TagCBOR    = "cbor"
+)
+
+// tags returns the configured or default tags, followed by ExtraTags, which
+// keep the original field names.
+func (s *Generator) tags() []TagConfig {
+	tags := s.Tags
+	if len(tags) == 0 {
+		tags = defaultTags
+	}
+	if len(s.ExtraTags) == 0 {
+		return tags
+	}
+	tags = append([]TagConfig(nil), tags...)
+	for _, key := range s.ExtraTags {
+		tags = append(tags, TagConfig{Key: key})
+	}
+	return tags
+}
+
+// structTag returns the struct tag for the field with the given document key.
+// omitempty is false for fields that should never be omitted.
+func (s *Generator) structTag(key string, omitempty bool) string {
+	tags := s.tags()
+	parts := make([]string, 0, len(tags))
+	for _, t := range tags {
+		if t.Value != "" {
+			parts = append(parts, fmt.Sprintf("%s:%q", t.Key, t.Value))
+			continue
+		}
+		v := convertCase(key, t.Case)
+		if omitempty && (t.OmitEmpty == nil || *t.OmitEmpty) {
+			v += ",omitempty"
+		}
+		parts = append(parts, fmt.Sprintf("%s:%q", t.Key, v))
+	}
+	return "`" + strings.Join(parts, " ") + "`"
+}
+
+// convertCase converts a document key to the named naming convention.
+func convertCase(key, c string) string {
+	switch c {
+	case "", CaseOriginal:
+		return key
+	case CaseLower:
+		return strings.ToLower(key)
+	}
+	parts := split(key)
+	for i, part := range parts {
+		part = strings.ToLower(part)
+		if c == CasePascal || c == CaseCamel && i > 0 {
+			part = strings.Title(part)
+		}
+		parts[i] = part
+	}
+	switch c {
+	case CaseSnake:
+		return strings.Join(parts, "_")
+	case CaseKebab:
+		return strings.Join(parts, "-")
+	}
+	return strings.Join(parts, "")
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Order was generated before total held fractions.
+type Order struct {
+	BaseModel `bson:",inline"`
+	Status    string `bson:"status,omitempty" json:"status,omitempty"`
+}
+
+type BaseModel struct {
+	ID    bson.ObjectId `bson:"_id,omitempty" json:"_id,omitempty"`
+	Total int           `bson:"total,omitempty" json:"total,omitempty"`
+}
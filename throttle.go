@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+)
+
+// defaultBatchSize is the number of documents the server returns per batch
+// when no batch size is configured.
+const defaultBatchSize = 1000
+
+// errNoSecondary is returned when secondary_only is set but the session is
+// not served by a secondary, such as with a standalone server.
+var errNoSecondary = errors.New("mongoschema: secondary_only is set but no secondary is reachable")
+
+// pacer is implemented by sources that limit the load their scans put on the
+// server.
+type pacer interface {
+	// pace is called after each document of a scan, the nth of its
+	// collection, and waits as long as the limits require. It reports
+	// whether it did so before ctx was done.
+	pace(ctx context.Context, n uint) bool
+}
+
+// rateLimiter spaces out the documents read by all the scans of a run, so
+// that together they read at most a fixed number per second.
+type rateLimiter struct {
+	interval time.Duration
+
+	mu sync.Mutex
+	// next is the earliest time the next document may be read.
+	next time.Time
+}
+
+func newRateLimiter(perSecond int) *rateLimiter {
+	return &rateLimiter{interval: time.Second / time.Duration(perSecond)}
+}
+
+// wait reserves the next slot and waits for it, reporting whether it did so
+// before ctx was done.
+func (l *rateLimiter) wait(ctx context.Context) bool {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	slot := l.next
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+	if d := time.Until(slot); d > 0 {
+		return sleep(ctx, d)
+	}
+	return ctx.Err() == nil
+}
+
+// batchSize returns the number of documents per batch of a cursor.
+func (s *Generator) batchSize() int {
+	if s.BatchSize > 0 {
+		return s.BatchSize
+	}
+	return defaultBatchSize
+}
+
+// batchDelay returns the pause after each batch of a cursor, zero for none.
+func (s *Generator) batchDelay() time.Duration {
+	return duration(s.BatchDelay)
+}
+
+func (m *mongoSource) pace(ctx context.Context, n uint) bool {
+	if m.limiter != nil && !m.limiter.wait(ctx) {
+		return false
+	}
+	if d := m.gen.batchDelay(); d > 0 && n%uint(m.gen.batchSize()) == 0 {
+		return sleep(ctx, d)
+	}
+	return true
+}
+
+// checkSecondary verifies that session, in secondary mode, reads from a
+// secondary. Without one, mgo would fail every read anyway; checking first
+// fails the run before any collection is listed.
+func checkSecondary(session *mgo.Session) error {
+	var result struct {
+		Secondary bool `bson:"secondary"`
+	}
+	if err := session.Run("isMaster", &result); err != nil {
+		return fmt.Errorf("%s: %s", errNoSecondary, err)
+	}
+	if !result.Secondary {
+		return errNoSecondary
+	}
+	return nil
+}
+
+// limits describes the limits on the load of the scans, or returns "".
+func (s *Generator) limits() string {
+	var limits []string
+	if s.RateLimit > 0 {
+		limits = append(limits, fmt.Sprintf("at most %d documents/s", s.RateLimit))
+	}
+	if s.BatchDelay != "" {
+		limits = append(limits, fmt.Sprintf("%s pause every %d documents", s.BatchDelay, s.batchSize()))
+	}
+	if s.SecondaryOnly {
+		limits = append(limits, "from secondaries only")
+	}
+	if s.MaxTimeMS > 0 {
+		limits = append(limits, fmt.Sprintf("%dms of server time per cursor", s.MaxTimeMS))
+	}
+	return strings.Join(limits, ", ")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// duration parses a timeout of the config, which has been validated, like
+// 90s or 10m. An empty timeout is zero, for none.
+func duration(v string) time.Duration {
+	d, _ := time.ParseDuration(v)
+	return d
+}
+
+// duration checks that the setting key is empty or a positive duration.
+func (e *configErrors) duration(key, value string) {
+	if value == "" {
+		return
+	}
+	if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+		e.add("%s: %q is not a positive duration like 90s or 10m", key, value)
+	}
+}
+
+// withTimeout returns ctx limited by the timeout of the whole run, if any.
+func (s *Generator) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d := duration(s.Timeout); d > 0 {
+		return context.WithTimeout(ctx, d)
+	}
+	return context.WithCancel(ctx)
+}
+
+// scanTimed scans c, stopping at its own timeout, if any, with the documents
+// scanned so far.
+func (s *Generator) scanTimed(ctx context.Context, src Source, c Collection) (*StructType, error) {
+	if d := duration(c.Timeout); d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	start := time.Now()
+	root, err := s.scanCached(ctx, src, c)
+	s.runReport.scanned(s.DB, c.Name, root, time.Since(start), err)
+	return root, err
+}
+
+// keepScanned drops the collections that were not scanned because the run
+// was stopped before they were started, whose roots are nil.
+func (s *Generator) keepScanned(cs []Collection, roots []*StructType) ([]Collection, []*StructType) {
+	var keptCs []Collection
+	var kept []*StructType
+	for i, root := range roots {
+		if root == nil {
+			s.logger().Warn("run stopped, collection left out", "collection", cs[i].Name)
+			continue
+		}
+		keptCs = append(keptCs, cs[i])
+		kept = append(kept, root)
+	}
+	return keptCs, kept
+}
+
+// interrupted returns the error of a run stopped by ctx, whose output, if
+// any, is partial.
+func interrupted(ctx context.Context) error {
+	return fmt.Errorf("mongoschema: interrupted, output is partial: %s", ctx.Err())
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// bucketsPrefix starts the name of the collection holding the buckets of a
+// time-series collection, which mongodump dumps instead of the collection.
+const bucketsPrefix = "system.buckets."
+
+// collectionInfo is the part of the metadata of a collection, as listed by
+// listCollections or dumped by mongodump, that affects the output.
+type collectionInfo struct {
+	Name    string `bson:"name" json:"name"`
+	Type    string `bson:"type" json:"type"`
+	Options struct {
+		Capped     bool            `bson:"capped" json:"capped"`
+		Size       int64           `bson:"size" json:"size"`
+		Max        int64           `bson:"max" json:"max"`
+		TimeSeries *timeSeriesInfo `bson:"timeseries" json:"timeseries"`
+	} `bson:"options" json:"options"`
+}
+
+// timeSeriesInfo holds the options of a time-series collection.
+type timeSeriesInfo struct {
+	TimeField   string `bson:"timeField" json:"timeField"`
+	MetaField   string `bson:"metaField" json:"metaField"`
+	Granularity string `bson:"granularity" json:"granularity"`
+}
+
+// infoLister is implemented by sources that know the metadata of their
+// collections.
+type infoLister interface {
+	collectionInfos() (map[string]collectionInfo, error)
+}
+
+// loadInfos reads the metadata of the collections, if src has it. Without
+// it, collections are scanned as plain ones, so a user lacking the privilege
+// to list collections only gets a warning.
+func (s *Generator) loadInfos(src Source) error {
+	l, ok := src.(infoLister)
+	if !ok {
+		return nil
+	}
+	infos, err := l.collectionInfos()
+	if err != nil {
+		if _, ok := src.(*mongoSource); ok {
+			s.logger().Warn("cannot read the metadata of the collections", "error", err)
+			return nil
+		}
+		return err
+	}
+	s.infos = infos
+	return nil
+}
+
+// timeSeries returns the options of c if it is a time-series collection.
+func (s *Generator) timeSeries(c Collection) *timeSeriesInfo {
+	return s.infos[c.Name].Options.TimeSeries
+}
+
+// describeCollection describes what a document of c is, and the options of
+// c if it is a capped or time-series collection.
+func (s *Generator) describeCollection(c Collection) (what, options string) {
+	if c.Pipeline != nil {
+		return fmt.Sprintf("a document output by an aggregation pipeline on %s", c.from()), ""
+	}
+	if s.isView(c) {
+		return fmt.Sprintf("a document of the %s view", c.Name), ""
+	}
+	info := s.infos[c.Name]
+	if ts := info.Options.TimeSeries; ts != nil {
+		options = ", with time field " + ts.TimeField
+		if ts.MetaField != "" {
+			options += " and meta field " + ts.MetaField
+		}
+		return fmt.Sprintf("a measurement of the %s time-series collection", c.Name), options
+	}
+	if info.Options.Capped {
+		options = fmt.Sprintf(", limited to %d bytes", info.Options.Size)
+		if info.Options.Max > 0 {
+			options += fmt.Sprintf(" and %d documents", info.Options.Max)
+		}
+		return fmt.Sprintf("a document of the %s capped collection", c.Name), options
+	}
+	return fmt.Sprintf("a document of the %s collection", c.Name), ""
+}
+
+// collectionNote describes c if it is a capped or time-series collection,
+// for the comments of the output, or returns "".
+func (s *Generator) collectionNote(c Collection) string {
+	what, options := s.describeCollection(c)
+	if options == "" {
+		return ""
+	}
+	return c.Struct + " is " + what + options + "."
+}
+
+// listCursor is the cursor returned by listCollections.
+type listCursor struct {
+	Cursor struct {
+		FirstBatch []bson.Raw `bson:"firstBatch"`
+		NS         string     `bson:"ns"`
+		ID         int64      `bson:"id"`
+	} `bson:"cursor"`
+}
+
+func (m *mongoSource) collectionInfos() (map[string]collectionInfo, error) {
+	session := m.session.Copy()
+	defer session.Close()
+	db := session.DB(m.gen.DB)
+	var result listCursor
+	if err := db.Run(bson.D{{Name: "listCollections", Value: 1}}, &result); err != nil {
+		return nil, fmt.Errorf("mongoschema: listing the collections of %s: %s", m.gen.DB, err)
+	}
+	c := db.C("")
+	if ns := strings.SplitN(result.Cursor.NS, ".", 2); len(ns) == 2 {
+		c = session.DB(ns[0]).C(ns[1])
+	}
+	iter := c.NewIter(nil, result.Cursor.FirstBatch, result.Cursor.ID, nil)
+	infos := map[string]collectionInfo{}
+	var info collectionInfo
+	for iter.Next(&info) {
+		infos[info.Name] = info
+		info = collectionInfo{}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, fmt.Errorf("mongoschema: listing the collections of %s: %s", m.gen.DB, err)
+	}
+	return infos, nil
+}
+
+// collectionInfos reads the metadata of a dump, which is in a .metadata.json
+// file per collection in a directory, or in the prelude of an archive.
+func (d *dumpSource) collectionInfos() (map[string]collectionInfo, error) {
+	if d.archive {
+		return archiveInfos(d.gen.Dump, d.gen.DB)
+	}
+	infos := map[string]collectionInfo{}
+	for _, dir := range []string{filepath.Join(d.gen.Dump, d.gen.DB), d.gen.Dump} {
+		names, err := filepath.Glob(filepath.Join(dir, "*.metadata.json"))
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			b, err := ioutil.ReadFile(name)
+			if err != nil {
+				return nil, err
+			}
+			info, err := parseMetadata(string(b))
+			if err != nil {
+				return nil, fmt.Errorf("mongoschema: %s: %s", name, err)
+			}
+			infos[strings.TrimSuffix(filepath.Base(name), ".metadata.json")] = info
+		}
+		if len(names) > 0 {
+			break
+		}
+	}
+	return infos, nil
+}
+
+// parseMetadata parses the metadata of a collection written by mongodump, in
+// MongoDB extended JSON.
+func parseMetadata(metadata string) (collectionInfo, error) {
+	var info collectionInfo
+	err := bson.UnmarshalJSON([]byte(metadata), &info)
+	return info, err
+}
+
+// archiveInfos reads the metadata of the collections of db from the prelude
+// of a mongodump archive, which lists every namespace after the header.
+func archiveInfos(name, db string) (map[string]collectionInfo, error) {
+	r, err := openMaybeGzip(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, err
+	}
+	infos := map[string]collectionInfo{}
+	for {
+		doc, err := readDoc(r)
+		if err != nil {
+			return nil, err
+		}
+		if doc == nil {
+			return infos, nil
+		}
+		var ns struct {
+			DB         string `bson:"db"`
+			Collection string `bson:"collection"`
+			Metadata   string `bson:"metadata"`
+		}
+		if err := bson.Unmarshal(doc, &ns); err != nil {
+			return nil, err
+		}
+		if ns.Collection == "" || ns.Metadata == "" || db != "" && ns.DB != db {
+			continue
+		}
+		info, err := parseMetadata(ns.Metadata)
+		if err != nil {
+			return nil, fmt.Errorf("mongoschema: metadata of %s: %s", ns.Collection, err)
+		}
+		infos[ns.Collection] = info
+	}
+}
+
+// logicalNames replaces the names of the bucket collections of time-series
+// collections with those of the collections.
+func logicalNames(names []string) []string {
+	for i, name := range names {
+		names[i] = strings.TrimPrefix(name, bucketsPrefix)
+	}
+	return names
+}
+
+// bucketIter unpacks the buckets of a time-series collection into the
+// measurements they hold. A bucket has the meta field of its measurements
+// and, for every other field, a column of their values keyed by their
+// position.
+type bucketIter struct {
+	Iter
+	ts *timeSeriesInfo
+	// pending are the measurements of the current bucket not returned yet.
+	pending []bson.D
+	err     error
+}
+
+type bucket struct {
+	Control struct {
+		Version int `bson:"version"`
+	} `bson:"control"`
+	Meta interface{} `bson:"meta"`
+	Data bson.RawD   `bson:"data"`
+}
+
+func (i *bucketIter) Next(result interface{}) bool {
+	for len(i.pending) == 0 {
+		if i.err != nil {
+			return false
+		}
+		var b bucket
+		if !i.Iter.Next(&b) {
+			return false
+		}
+		i.pending, i.err = i.unpack(b)
+	}
+	raw, err := bson.Marshal(i.pending[0])
+	i.pending = i.pending[1:]
+	if err == nil {
+		err = bson.Unmarshal(raw, result)
+	}
+	if err != nil {
+		i.err = err
+		return false
+	}
+	return true
+}
+
+// unpack returns the measurements of b, in the order of its time column.
+func (i *bucketIter) unpack(b bucket) ([]bson.D, error) {
+	if b.Control.Version != 1 {
+		return nil, errors.New("mongoschema: compressed time-series buckets are not supported")
+	}
+	columns := map[string]map[string]bson.Raw{}
+	var rows bson.RawD
+	for _, e := range b.Data {
+		var column bson.RawD
+		if err := e.Value.Unmarshal(&column); err != nil {
+			return nil, err
+		}
+		values := map[string]bson.Raw{}
+		for _, v := range column {
+			values[v.Name] = v.Value
+		}
+		columns[e.Name] = values
+		if e.Name == i.ts.TimeField {
+			rows = column
+		}
+	}
+	var docs []bson.D
+	for _, row := range rows {
+		var doc bson.D
+		for _, e := range b.Data {
+			v, ok := columns[e.Name][row.Name]
+			if !ok {
+				continue
+			}
+			var value interface{}
+			if err := v.Unmarshal(&value); err != nil {
+				return nil, err
+			}
+			doc = append(doc, bson.DocElem{Name: e.Name, Value: value})
+		}
+		if i.ts.MetaField != "" && b.Meta != nil {
+			doc = append(doc, bson.DocElem{Name: i.ts.MetaField, Value: b.Meta})
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+func (i *bucketIter) Close() error {
+	err := i.Iter.Close()
+	if i.err != nil {
+		return i.err
+	}
+	return err
+}
+
+// openBuckets opens the bucket collection of the time-series collection c in
+// a dump, unpacking its buckets.
+func (d *dumpSource) openBuckets(c Collection, ts *timeSeriesInfo) (Iter, error) {
+	c.Name = bucketsPrefix + c.Name
+	iter, err := d.Open(c)
+	if err != nil {
+		return nil, err
+	}
+	return &bucketIter{Iter: iter, ts: ts}, nil
+}
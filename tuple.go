@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxTupleLen is the longest array that is considered a tuple.
+const maxTupleLen = 4
+
+// maxFixedArrayLen is the longest array of constant length rendered as a Go
+// array, beyond which positional schemas, such as TypeScript tuples, grow
+// unwieldy.
+const maxFixedArrayLen = 32
+
+// defaultFixedArrayMin is the number of documents that must have held an
+// array field, always of the same length, for it to be rendered as a Go
+// array. mgo fails to decode a whole document whose array has another
+// length, so a few documents are not enough evidence.
+const defaultFixedArrayMin = 100
+
+// TupleType is an array of fixed length whose elements have a fixed type at
+// each position, like [lon, lat]. It is rendered as a Go array, which is
+// [N]interface{} if the positions differ in type.
+type TupleType struct {
+	Types []Type
+}
+
+// uniform returns the type of every element, if they all have the same.
+func (t TupleType) uniform(gen *Generator) (Type, bool) {
+	for _, e := range t.Types[1:] {
+		if e.GoType(gen) != t.Types[0].GoType(gen) {
+			return nil, false
+		}
+	}
+	return t.Types[0], true
+}
+
+func (t TupleType) GoType(gen *Generator) string {
+	if e, ok := t.uniform(gen); ok {
+		return fmt.Sprintf("[%d]%s", len(t.Types), e.GoType(gen))
+	}
+	return fmt.Sprintf("[%d]interface{}", len(t.Types))
+}
+
+// positions describes the types at each position, which [N]interface{} hides.
+func (t TupleType) positions(gen *Generator) string {
+	types := make([]string, len(t.Types))
+	for i, e := range t.Types {
+		types[i] = e.GoType(gen)
+	}
+	return "tuple: [" + strings.Join(types, ", ") + "]"
+}
+
+func (t TupleType) Merge(o Type, gen *Generator) Type {
+	if isNil(o) {
+		return t
+	}
+	if u, ok := o.(TupleType); ok && len(u.Types) == len(t.Types) {
+		types := make([]Type, len(t.Types))
+		for i := range types {
+			types[i] = t.Types[i].Merge(u.Types[i], gen)
+		}
+		return TupleType{Types: types}
+	}
+	return SliceType{Type: newMixedType(gen, t.Types...)}.Merge(o, gen)
+}
+
+// arrayStats returns the positional types of the array v, if it is short
+// enough to be a tuple or a legacy coordinate pair, and counts the Go types
+// of its elements.
+func arrayStats(v interface{}, gen *Generator) (tuple []Type, elems map[string]uint) {
+	a, ok := v.([]interface{})
+	if !ok {
+		return nil, nil
+	}
+	if gen.ElementComments {
+		elems = map[string]uint{}
+	}
+	for _, e := range a {
+		t := NewType(e, gen)
+		if elems != nil && !isNil(t) {
+			elems[t.GoType(gen)]++
+		}
+		if (gen.Tuples || gen.LegacyCoords != "") && len(a) >= 2 && len(a) <= maxTupleLen {
+			tuple = append(tuple, t)
+		}
+	}
+	return tuple, elems
+}
+
+// mergeTuple merges the positional types of two observations of a field. The
+// field is not a tuple if their lengths differ.
+func mergeTuple(a, b []Type, gen *Generator) []Type {
+	if len(a) != len(b) {
+		return nil
+	}
+	for i := range a {
+		a[i] = a[i].Merge(b[i], gen)
+	}
+	return a
+}
+
+// tuples replaces the slice fields of s and its nested structs that always
+// held arrays of the same length, with a primitive type at each position,
+// with tuple types.
+func (s *Generator) tuples(st *StructType) {
+	for _, f := range st.Fields {
+		if _, ok := f.Type.(SliceType); ok && f.Count > 1 && isTuple(f.Tuple) {
+			f.Type = TupleType{Types: f.Tuple}
+			continue
+		}
+		s.tupleTypes(f.Type)
+	}
+}
+
+func (s *Generator) tupleTypes(t Type) {
+	switch v := t.(type) {
+	case *StructType:
+		s.tuples(v)
+	case SliceType:
+		s.tupleTypes(v.Type)
+	case MixedType:
+		for _, e := range v {
+			s.tupleTypes(e)
+		}
+	}
+}
+
+// arrayLen returns the length of v for Field.ArrayLen, if it is an array.
+func arrayLen(v interface{}) int {
+	a, ok := v.([]interface{})
+	switch {
+	case !ok:
+		return 0
+	case len(a) == 0:
+		return -1
+	}
+	return len(a)
+}
+
+// mergeArrayLen merges the array lengths of two observations of a field.
+func mergeArrayLen(a, b int) int {
+	switch {
+	case a == 0:
+		return b
+	case b == 0 || a == b:
+		return a
+	}
+	return -1
+}
+
+// fixedArrays replaces the slice fields of st and its nested structs that
+// held arrays in at least fixed_array_min documents, always of the same
+// length and of a single primitive type, with Go arrays of that length, such
+// as [3]float64 for coordinates. Later documents may hold arrays of
+// other lengths, which no_fixed_arrays guards against.
+func (s *Generator) fixedArrays(st *StructType) {
+	min := uint(s.FixedArrayMin)
+	if min == 0 {
+		min = defaultFixedArrayMin
+	}
+	for _, f := range st.Fields {
+		if slice, ok := f.Type.(SliceType); ok && f.Count >= min && f.ArrayLen >= 2 && f.ArrayLen <= maxFixedArrayLen {
+			if _, ok := slice.Type.(PrimitiveType); ok {
+				types := make([]Type, f.ArrayLen)
+				for i := range types {
+					types[i] = slice.Type
+				}
+				f.Type = TupleType{Types: types}
+				continue
+			}
+		}
+		s.fixedArrayTypes(f.Type)
+	}
+}
+
+func (s *Generator) fixedArrayTypes(t Type) {
+	switch v := t.(type) {
+	case *StructType:
+		s.fixedArrays(v)
+	case SliceType:
+		s.fixedArrayTypes(v.Type)
+	case MixedType:
+		for _, e := range v {
+			s.fixedArrayTypes(e)
+		}
+	}
+}
+
+func isTuple(types []Type) bool {
+	if len(types) < 2 {
+		return false
+	}
+	for _, t := range types {
+		if _, ok := t.(PrimitiveType); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// elementStats describes the distribution of the element types of a slice
+// field.
+func elementStats(f *Field) string {
+	var total uint
+	var types []string
+	for t, n := range f.Elems {
+		total += n
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		if f.Elems[types[i]] != f.Elems[types[j]] {
+			return f.Elems[types[i]] > f.Elems[types[j]]
+		}
+		return types[i] < types[j]
+	})
+	parts := make([]string, len(types))
+	for i, t := range types {
+		parts[i] = fmt.Sprintf("%s %.1f%%", t, 100*float64(f.Elems[t])/float64(total))
+	}
+	return "elements: " + strings.Join(parts, ", ")
+}
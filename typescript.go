@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var tsIdentRe = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// renderTypeScript writes an exported interface for every collection and
+// named struct.
+func (s *Generator) renderTypeScript(w io.Writer, schemas []Schema) error {
+	for _, schema := range schemas {
+		if note := s.collectionNote(schema.Collection); note != "" {
+			fmt.Fprintf(w, "/** %s */\n", note)
+		}
+		fmt.Fprintf(w, "export interface %s %s\n\n", schema.Collection.Struct, s.tsType(schema.Root, 0))
+		for _, n := range schema.Decls {
+			if _, ok := n.Type.(EnumType); ok {
+				fmt.Fprintf(w, "export type %s = %s;\n\n", n.Name, s.tsType(n.Type, 0))
+				continue
+			}
+			fmt.Fprintf(w, "export interface %s %s\n\n", n.Name, s.tsType(n.Type, 0))
+		}
+	}
+	return nil
+}
+
+// tsType returns the TypeScript type of t, indenting nested object types to
+// the given depth.
+func (s *Generator) tsType(t Type, depth int) string {
+	switch v := t.(type) {
+	case *StructType:
+		var b strings.Builder
+		indent := strings.Repeat("  ", depth+1)
+		fmt.Fprintln(&b, "{")
+		for _, k := range v.keys(s) {
+			name := k
+			if !tsIdentRe.MatchString(k) {
+				name = fmt.Sprintf("%q", k)
+			}
+			optional := "?"
+			if s.InferOptional && v.required(k) {
+				optional = ""
+			}
+			fmt.Fprintf(&b, "%s%s%s: %s;\n", indent, name, optional, s.tsType(v.Fields[k].Type, depth+1))
+		}
+		fmt.Fprintf(&b, "%s}", strings.Repeat("  ", depth))
+		return b.String()
+	case SliceType:
+		if isNil(v.Type) {
+			return "unknown[]"
+		}
+		elem := s.tsType(v.Type, depth)
+		if _, ok := v.Type.(MixedType); ok {
+			elem = "(" + elem + ")"
+		}
+		return elem + "[]"
+	case TupleType:
+		elems := make([]string, len(v.Types))
+		for i, e := range v.Types {
+			elems[i] = s.tsType(e, depth)
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	case MapType:
+		return "Record<string, " + s.tsType(v.Value, depth) + ">"
+	case MixedType:
+		members := make([]string, len(v))
+		for i, e := range v {
+			members[i] = s.tsType(e, depth)
+		}
+		return strings.Join(members, " | ")
+	case NamedType:
+		return v.Name
+	case OverrideType:
+		return s.tsType(v.Inferred, depth)
+	case GeoJSONType:
+		return s.tsType(v.Struct(), depth)
+	case LegacyPointType:
+		return s.tsType(v.Tuple(), depth)
+	case EnumType:
+		values := make([]string, len(v.Values))
+		for i, e := range v.Values {
+			values[i] = fmt.Sprintf("%q", e)
+		}
+		return strings.Join(values, " | ")
+	case PrimitiveType:
+		return s.tsPrimitive(v)
+	}
+	if t == NilType {
+		return "null"
+	}
+	return "unknown"
+}
+
+func (s *Generator) tsPrimitive(p PrimitiveType) string {
+	switch p {
+	case PrimitiveBool:
+		return "boolean"
+	case PrimitiveDouble, PrimitiveInt32, PrimitiveInt64, PrimitiveMongoTimestamp:
+		return "number"
+	case PrimitiveObjectId, PrimitiveString, PrimitiveBinary, PrimitiveBytes, PrimitiveUUID,
+		PrimitiveDecimal128, PrimitiveRegEx, PrimitiveJavaScript, PrimitiveSymbol:
+		return "string"
+	case PrimitiveTimestamp:
+		if s.TSDateType != "" {
+			return s.TSDateType
+		}
+		return "string"
+	case PrimitiveDBRef:
+		return "{ $ref: string; $id: unknown; $db?: string }"
+	}
+	return "unknown"
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"gopkg.in/mgo.v2"
+)
+
+// typeTreeVersion is the version of the format of exported type trees,
+// increased whenever a change breaks reading older ones.
+const typeTreeVersion = 1
+
+// typeTree holds the inferred types of the collections of a run, with their
+// statistics, indexes and metadata, as exported with export_schema. Importing
+// it with import_schema generates the output offline, as if from the same
+// scan.
+type typeTree struct {
+	Version     int              `json:"version"`
+	DB          string           `json:"db,omitempty"`
+	Time        time.Time        `json:"time"`
+	Collections []treeCollection `json:"collections"`
+}
+
+type treeCollection struct {
+	Name    string          `json:"name"`
+	Root    *typeJSON       `json:"root"`
+	Indexes []mgo.Index     `json:"indexes,omitempty"`
+	Info    *collectionInfo `json:"info,omitempty"`
+}
+
+// exportTypes writes the raw types roots of the collections to the
+// export_schema file.
+func (s *Generator) exportTypes(roots []*StructType) error {
+	tree := typeTree{Version: typeTreeVersion, DB: s.DB, Time: s.generated()}
+	for i, c := range s.Collections {
+		tc := treeCollection{Name: c.Name, Root: encodeType(roots[i]), Indexes: s.indexes[c.Name]}
+		if info, ok := s.infos[c.Name]; ok {
+			tc.Info = &info
+		}
+		tree.Collections = append(tree.Collections, tc)
+	}
+	b, err := json.MarshalIndent(tree, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.ExportSchema, append(b, '\n'), 0644)
+}
+
+// importTypes reads the raw types of the collections from the import_schema
+// file, in place of scanning them, and returns them in the same order as
+// s.Collections. Without configured collections, those of the file selected
+// by include and exclude are generated.
+func (s *Generator) importTypes() ([]*StructType, error) {
+	b, err := ioutil.ReadFile(s.ImportSchema)
+	if err != nil {
+		return nil, err
+	}
+	var tree typeTree
+	if err := json.Unmarshal(b, &tree); err != nil {
+		return nil, fmt.Errorf("mongoschema: %s: %s", s.ImportSchema, err)
+	}
+	if tree.Version != typeTreeVersion {
+		return nil, fmt.Errorf("mongoschema: %s: unsupported version %d, expected %d", s.ImportSchema, tree.Version, typeTreeVersion)
+	}
+	if s.DB == "" {
+		s.DB = tree.DB
+	}
+	byName := map[string]treeCollection{}
+	for _, tc := range tree.Collections {
+		byName[tc.Name] = tc
+	}
+	if len(s.Collections) == 0 {
+		for _, tc := range tree.Collections {
+			ok, err := s.selected(tc.Name)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				s.Collections = append(s.Collections, Collection{Name: tc.Name})
+			}
+		}
+		if len(s.Collections) == 0 {
+			return nil, fmt.Errorf("mongoschema: no collections found in %s", s.ImportSchema)
+		}
+	}
+	s.indexes = map[string][]mgo.Index{}
+	s.infos = map[string]collectionInfo{}
+	roots := make([]*StructType, len(s.Collections))
+	for i, c := range s.Collections {
+		if c.Struct == "" {
+			s.Collections[i].Struct = s.structName(c.Name)
+		}
+		tc, ok := byName[c.Name]
+		if !ok {
+			return nil, fmt.Errorf("mongoschema: %s does not hold collection %s", s.ImportSchema, c.Name)
+		}
+		t, err := decodeType(tc.Root)
+		if err != nil {
+			return nil, fmt.Errorf("mongoschema: %s: collection %s: %s", s.ImportSchema, c.Name, err)
+		}
+		root, ok := t.(*StructType)
+		if !ok {
+			return nil, fmt.Errorf("mongoschema: %s: collection %s is not a document", s.ImportSchema, c.Name)
+		}
+		roots[i] = root
+		if tc.Indexes != nil {
+			s.indexes[c.Name] = tc.Indexes
+		}
+		if tc.Info != nil {
+			s.infos[c.Name] = *tc.Info
+		}
+	}
+	return roots, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// number returns v as a float64 if it is a number.
+func number(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// writeValidate writes the Validate method of the struct named name.
+func (s *Generator) writeValidate(w io.Writer, name string, st *StructType) {
+	fmt.Fprintf(w, "func (v %s) Validate() error {\n", name)
+	for _, stmt := range s.validations(st, "v", "") {
+		fmt.Fprintln(w, stmt)
+	}
+	fmt.Fprintln(w, "return nil")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w)
+}
+
+// validates reports whether any Validate method of schemas checks something
+// itself, and so needs fmt.
+func (s *Generator) validates(schemas []Schema) bool {
+	for _, schema := range schemas {
+		if usesFmt(s.validations(schema.Root, "v", "")) {
+			return true
+		}
+		for _, n := range schema.Decls {
+			if st, ok := n.Type.(*StructType); ok && usesFmt(s.validations(st, "v", "")) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// usesFmt reports whether any of stmts calls fmt, which all but the call of
+// the Validate method of an embedded struct do.
+func usesFmt(stmts []string) bool {
+	for _, stmt := range stmts {
+		if strings.Contains(stmt, "fmt.") {
+			return true
+		}
+	}
+	return false
+}
+
+// validations returns the statements checking the fields of st, which is
+// the value of the Go expression expr and at the BSON path prefix. Fields
+// seen in every document are required to be set, enums to hold one of their
+// values and numbers to be within the range seen. Nested anonymous structs
+// are checked in place and named structs, embedded or not, by their own
+// Validate methods.
+func (s *Generator) validations(st *StructType, expr, prefix string) []string {
+	var stmts []string
+	if st.embed != nil {
+		stmts = append(stmts, fmt.Sprintf("if err := %s.%s.Validate(); err != nil {\nreturn err\n}", expr, st.embed.Name))
+	}
+	for _, k := range st.keys(s) {
+		if !isValidFieldName(k) {
+			continue
+		}
+		f := st.Fields[k]
+		field := expr + "." + s.goFieldName(st, k)
+		path := prefix + k
+		goType, _ := s.fieldType(st, k)
+		if strings.HasPrefix(goType, "sql.") {
+			continue
+		}
+		pointer := strings.HasPrefix(goType, "*")
+		value := field
+		if pointer {
+			value = "*" + field
+		}
+		// guard skips the checks of the value of an optional field that is
+		// not set.
+		guard := func(zero string) string {
+			switch {
+			case pointer:
+				return field + " != nil && "
+			case st.required(k) || zero == "":
+				return ""
+			}
+			return field + " != " + zero + " && "
+		}
+
+		t := f.Type
+		if n, ok := t.(NamedType); ok {
+			if _, ok := n.Type.(EnumType); ok {
+				t = n.Type
+			}
+		}
+		if st.required(k) && !pointer {
+			if zero := zeroCheck(t, field); zero != "" {
+				stmts = append(stmts, fmt.Sprintf("if %s {\nreturn fmt.Errorf(%q)\n}", zero, path+" is required"))
+			}
+		}
+		switch v := t.(type) {
+		case EnumType:
+			conds := make([]string, len(v.Values))
+			for i, e := range v.Values {
+				conds[i] = fmt.Sprintf("%s != %q", value, e)
+			}
+			stmts = append(stmts, fmt.Sprintf("if %s%s {\nreturn fmt.Errorf(%q, %s)\n}",
+				guard(`""`), strings.Join(conds, " && "), path+": invalid value %q", value))
+		case PrimitiveType:
+			if f.Min == nil || f.Max == nil {
+				break
+			}
+			var min, max string
+			switch v {
+			case PrimitiveInt32, PrimitiveInt64:
+				min, max = strconv.FormatInt(int64(*f.Min), 10), strconv.FormatInt(int64(*f.Max), 10)
+			case PrimitiveDouble:
+				min, max = strconv.FormatFloat(*f.Min, 'g', -1, 64), strconv.FormatFloat(*f.Max, 'g', -1, 64)
+			default:
+				continue
+			}
+			stmts = append(stmts, fmt.Sprintf("if %s(%s < %s || %s > %s) {\nreturn fmt.Errorf(%q, %s)\n}",
+				guard("0"), value, min, value, max, path+": %v is out of the range ["+min+", "+max+"]", value))
+		case *StructType:
+			nested := s.validations(v, field, path+".")
+			if len(nested) > 0 && pointer {
+				nested = []string{fmt.Sprintf("if %s != nil {\n%s\n}", field, strings.Join(nested, "\n"))}
+			}
+			stmts = append(stmts, nested...)
+		case NamedType:
+			if _, ok := v.Type.(*StructType); !ok {
+				break
+			}
+			stmt := fmt.Sprintf("if err := %s.Validate(); err != nil {\nreturn fmt.Errorf(%q, err)\n}", field, path+": %s")
+			if pointer {
+				stmt = fmt.Sprintf("if %s != nil {\n%s\n}", field, stmt)
+			}
+			stmts = append(stmts, stmt)
+		case SliceType:
+			if n, ok := v.Type.(NamedType); ok {
+				if _, ok := n.Type.(*StructType); ok {
+					stmts = append(stmts, fmt.Sprintf("for i, e := range %s {\nif err := e.Validate(); err != nil {\nreturn fmt.Errorf(%q, i, err)\n}\n}", field, path+"[%d]: %s"))
+				}
+			}
+		}
+	}
+	return stmts
+}
+
+// zeroCheck returns the condition under which field, of type t, is not set,
+// or "" if its zero value is also a valid value, like for numbers.
+func zeroCheck(t Type, field string) string {
+	switch t.(type) {
+	case EnumType:
+		return field + ` == ""`
+	case SliceType, MapType, MixedType:
+		return field + " == nil"
+	}
+	switch t {
+	case PrimitiveString, PrimitiveObjectId:
+		return field + ` == ""`
+	case PrimitiveTimestamp:
+		return field + ".IsZero()"
+	}
+	return ""
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Variant is a variant of the documents of a collection with a
+// discriminator: the value of the discriminator and the name of its struct.
+type Variant struct {
+	Value string
+	Name  string
+}
+
+// variant is a Variant with its type, while the schemas are transformed.
+type variant struct {
+	Variant
+	st *StructType
+}
+
+// mergeDoc merges the type t of a document of c into root and, if c has a
+// discriminator, a copy of it into the type of the variant its value tells.
+// Documents without a string discriminator only make up root.
+func (s *Generator) mergeDoc(root *StructType, c Collection, t Type) {
+	if st, ok := t.(*StructType); ok && c.Discriminator != "" {
+		if f, ok := st.Fields[c.Discriminator]; ok && f.str != "" {
+			root.mergeVariant(f.str, st.clone(), s)
+		}
+	}
+	root.Merge(t, s)
+}
+
+// clone returns a deep copy of s, which shares no map, slice or field
+// with it, so that merging into one leaves the other unchanged.
+func (s *StructType) clone() *StructType {
+	c := &StructType{Fields: make(map[string]*Field, len(s.Fields)), Count: s.Count, embed: s.embed}
+	for k, f := range s.Fields {
+		c.Fields[k] = f.clone()
+	}
+	c.rare = append([]string(nil), s.rare...)
+	for v, vt := range s.variants {
+		if c.variants == nil {
+			c.variants = map[string]*StructType{}
+		}
+		c.variants[v] = vt.clone()
+	}
+	return c
+}
+
+// clone returns a deep copy of f.
+func (f *Field) clone() *Field {
+	c := *f
+	c.Type = cloneType(f.Type)
+	c.Values = cloneCounts(f.Values)
+	c.Refs = cloneCounts(f.Refs)
+	c.Elems = cloneCounts(f.Elems)
+	c.Examples = append([]string(nil), f.Examples...)
+	c.Indexes = append([]string(nil), f.Indexes...)
+	if f.Tuple != nil {
+		c.Tuple = make([]Type, len(f.Tuple))
+		for i, t := range f.Tuple {
+			c.Tuple[i] = cloneType(t)
+		}
+	}
+	if f.Min != nil {
+		min := *f.Min
+		c.Min = &min
+	}
+	if f.Max != nil {
+		max := *f.Max
+		c.Max = &max
+	}
+	if f.TypeIDs != nil {
+		c.TypeIDs = make(map[string]string, len(f.TypeIDs))
+		for k, v := range f.TypeIDs {
+			c.TypeIDs[k] = v
+		}
+	}
+	return &c
+}
+
+func cloneCounts(m map[string]uint) map[string]uint {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]uint, len(m))
+	for k, n := range m {
+		c[k] = n
+	}
+	return c
+}
+
+// cloneType returns a deep copy of t. The types other than structs and
+// those holding types are values, copied as they are.
+func cloneType(t Type) Type {
+	switch v := t.(type) {
+	case *StructType:
+		return v.clone()
+	case SliceType:
+		return SliceType{Type: cloneType(v.Type)}
+	case MapType:
+		return MapType{Value: cloneType(v.Value)}
+	case NamedType:
+		return NamedType{Name: v.Name, Type: cloneType(v.Type)}
+	case OverrideType:
+		return OverrideType{Name: v.Name, Inferred: cloneType(v.Inferred)}
+	case MixedType:
+		c := make(MixedType, len(v))
+		for i, e := range v {
+			c[i] = cloneType(e)
+		}
+		return c
+	case TupleType:
+		c := TupleType{Types: make([]Type, len(v.Types))}
+		for i, e := range v.Types {
+			c.Types[i] = cloneType(e)
+		}
+		return c
+	}
+	return t
+}
+
+// mergeVariant merges t into the type of the variant v of s.
+func (s *StructType) mergeVariant(v string, t *StructType, gen *Generator) {
+	if e, ok := s.variants[v]; ok {
+		e.Merge(t, gen)
+		return
+	}
+	if s.variants == nil {
+		s.variants = map[string]*StructType{}
+	}
+	s.variants[v] = t
+}
+
+// splitVariants moves the fields of root that only some of the variants of
+// c have to the structs of those variants, which embed root, and returns
+// them sorted by value. Root keeps the fields all the variants share, and
+// those also seen in documents without a discriminator.
+func (s *Generator) splitVariants(root *StructType, c Collection, h *hoister) []variant {
+	if c.Discriminator == "" || len(root.variants) == 0 {
+		return nil
+	}
+	values := make([]string, 0, len(root.variants))
+	for v := range root.variants {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	variants := make([]variant, len(values))
+	for i, v := range values {
+		name := s.makeFieldName(v)
+		if !isValidFieldName(v) || name == "" {
+			name = "Variant"
+		}
+		variants[i] = variant{Variant{Value: v, Name: h.unique(c.Struct + name)}, root.variants[v]}
+	}
+	for k, f := range root.Fields {
+		n, count := 0, uint(0)
+		for _, v := range variants {
+			if vf, ok := v.st.Fields[k]; ok {
+				n++
+				count += vf.Count
+			}
+		}
+		// A field also held by documents without a discriminator stays in
+		// root, which they are decoded into.
+		if n == 0 || n == len(variants) || f.Count > count {
+			for _, v := range variants {
+				delete(v.st.Fields, k)
+			}
+		} else {
+			delete(root.Fields, k)
+		}
+	}
+	for _, v := range variants {
+		v.st.embed = &NamedType{Name: c.Struct, Type: root}
+	}
+	root.variants = nil
+	return variants
+}
+
+// unmarshalsVariants reports whether an unmarshal function is written for
+// the variants of schema, which needs its discriminator in the struct.
+func (s *Generator) unmarshalsVariants(schema Schema) bool {
+	k := schema.Collection.Discriminator
+	_, ok := schema.Root.Fields[k]
+	return len(schema.Variants) > 0 && ok && isValidFieldName(k)
+}
+
+// writeUnmarshal writes the UnmarshalX function of the collection of
+// schema, which decodes a document into the struct of its variant, chosen
+// by the value of the discriminator.
+func (s *Generator) writeUnmarshal(w io.Writer, schema Schema) {
+	c, root := schema.Collection, schema.Root
+	if !s.unmarshalsVariants(schema) {
+		s.logger().Warn("discriminator left out of the struct, no unmarshal function", "collection", c.Name, "field", c.Discriminator)
+		return
+	}
+	goType, _ := s.fieldType(root, c.Discriminator)
+	field := "doc." + s.goFieldName(root, c.Discriminator)
+	fmt.Fprintf(w, "// Unmarshal%s decodes a document of %s into the struct of\n", c.Struct, c.Name)
+	fmt.Fprintf(w, "// its variant, chosen by its %s field, or into %s for\n// another value.\n", c.Discriminator, c.Struct)
+	decode := "json.Unmarshal(data, %s)"
+	if s.bsonTagged() {
+		decode = "data.Unmarshal(%s)"
+		fmt.Fprintf(w, "func Unmarshal%s(data bson.Raw) (interface{}, error) {\n", c.Struct)
+	} else {
+		fmt.Fprintf(w, "func Unmarshal%s(data []byte) (interface{}, error) {\n", c.Struct)
+	}
+	fmt.Fprintf(w, "var doc %s\nif err := %s; err != nil {\nreturn nil, err\n}\n", c.Struct, fmt.Sprintf(decode, "&doc"))
+	if strings.HasPrefix(goType, "*") {
+		fmt.Fprintf(w, "if %s == nil {\nreturn &doc, nil\n}\n", field)
+		field = "*" + field
+	}
+	fmt.Fprintf(w, "var v interface{}\nswitch %s {\n", field)
+	for _, v := range schema.Variants {
+		fmt.Fprintf(w, "case %q:\nv = &%s{}\n", v.Value, v.Name)
+	}
+	fmt.Fprint(w, "default:\nreturn &doc, nil\n}\n")
+	fmt.Fprintf(w, "return v, %s\n}\n\n", fmt.Sprintf(decode, "v"))
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// watchInterval is how often Watch regenerates the output after documents
+// have changed, so that a burst of changes is handled at once.
+const watchInterval = time.Second
+
+// changeEvent is the part of a change stream event that Watch uses.
+type changeEvent struct {
+	OperationType string `bson:"operationType"`
+	FullDocument  bson.M `bson:"fullDocument"`
+}
+
+// change is a changed document of the collection at index i.
+type change struct {
+	i   int
+	doc bson.M
+	err error
+}
+
+// Watch generates the output like Generate, and then follows a change stream
+// per collection, merging inserted, updated and replaced documents into the
+// schemas. Whenever the generated types change, the output is rewritten; if
+// it is stdout, the changes are reported to w instead, in the format of
+// Check. Collection filters do not apply to changes. Watch runs until ctx is
+// done or a change stream fails; the timeout of the run only limits the
+// initial scan.
+func (s *Generator) Watch(ctx context.Context, w io.Writer) error {
+	if len(s.Databases) > 0 {
+		return errDatabases
+	}
+	if len(s.Packages) > 0 {
+		return errors.New("mongoschema: watch does not support packages")
+	}
+	scan, cancel := s.withTimeout(ctx)
+	defer cancel()
+	src, err := s.source(scan)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	mongo, ok := src.(*mongoSource)
+	if !ok {
+		return errors.New("mongoschema: watch requires a mongo source")
+	}
+	roots, err := s.scanRoots(scan, src)
+	if err != nil {
+		return err
+	}
+	prev, err := s.regenerate(roots, nil, w)
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return interrupted(ctx)
+	}
+
+	changes := make(chan change)
+	for i, c := range s.Collections {
+		go mongo.watch(i, c, changes)
+	}
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	dirty := false
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ch := <-changes:
+			if ch.err != nil {
+				return ch.err
+			}
+			s.mergeDoc(roots[ch.i], s.Collections[ch.i], NewType(ch.doc, s))
+			dirty = true
+		case <-ticker.C:
+			if !dirty {
+				continue
+			}
+			dirty = false
+			if prev, err = s.regenerate(roots, prev, w); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// copyRoots returns deep copies of the raw roots, which transform modifies.
+func copyRoots(roots []*StructType) ([]*StructType, error) {
+	copies := make([]*StructType, len(roots))
+	for i, root := range roots {
+		t, err := decodeType(encodeType(root))
+		if err != nil {
+			return nil, err
+		}
+		copies[i] = t.(*StructType)
+	}
+	return copies, nil
+}
+
+// regenerate transforms a copy of the raw roots, and writes the output if
+// its types differ from prev, the types written last time. It returns the
+// types it compared.
+func (s *Generator) regenerate(roots []*StructType, prev []byte, w io.Writer) ([]byte, error) {
+	copies, err := copyRoots(roots)
+	if err != nil {
+		return nil, err
+	}
+	schemas, err := s.transform(copies)
+	if err != nil {
+		return nil, err
+	}
+	types, err := s.typesSource(schemas)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(types, prev) {
+		return prev, nil
+	}
+	if prev == nil || s.Output.File != "" || s.Output.Dir != "" {
+		if prev != nil {
+			s.logger().Info("schema changed, rewriting output")
+		}
+		return types, s.write(schemas)
+	}
+	fset := token.NewFileSet()
+	have, err := parser.ParseFile(fset, "previous.go", prev, 0)
+	if err != nil {
+		return nil, err
+	}
+	want, err := parser.ParseFile(fset, "generated.go", types, 0)
+	if err != nil {
+		return nil, err
+	}
+	diffTypes(fset, have, want, w)
+	return types, nil
+}
+
+// watch sends the documents changed in c to changes, until the change stream
+// fails.
+func (m *mongoSource) watch(i int, c Collection, changes chan<- change) {
+	session := m.session.Copy()
+	defer session.Close()
+	pipeline := []bson.M{
+		{"$changeStream": bson.M{"fullDocument": "updateLookup"}},
+		{"$match": bson.M{"operationType": bson.M{"$in": []string{"insert", "update", "replace"}}}},
+	}
+	iter := session.DB(m.gen.DB).C(c.Name).Pipe(pipeline).Iter()
+	var ev changeEvent
+	for iter.Next(&ev) {
+		// The document of an update is gone if it was deleted since.
+		if ev.FullDocument != nil {
+			changes <- change{i: i, doc: ev.FullDocument}
+		}
+		ev = changeEvent{}
+	}
+	err := iter.Close()
+	if err == nil {
+		err = fmt.Errorf("mongoschema: change stream of %s ended", c.Name)
+	}
+	changes <- change{i: i, err: err}
+}